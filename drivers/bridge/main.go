@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	resourcev1 "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	nodeutil "k8s.io/component-helpers/node/util"
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	kndconfig "github.com/aojea/kubernetes-network-drivers/pkg/config"
+	"github.com/aojea/kubernetes-network-drivers/pkg/driver"
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+	kndnet "github.com/aojea/kubernetes-network-drivers/pkg/net"
+)
+
+//================================================================
+// Driver Implementation
+//================================================================
+
+const (
+	// portsCapacity is the capacity consumed by every pod interface attached to a bridge.
+	portsCapacity = "ports"
+
+	deviceTypeVeth   = "veth"
+	deviceTypeNetkit = "netkit"
+)
+
+// bridgeConfig is the opaque configuration accepted in the claim or in the DeviceClass.
+type bridgeConfig struct {
+	// InterfaceName is the name of the interface inside the pod, it defaults to the request name.
+	InterfaceName string `json:"interfaceName,omitempty"`
+	// MTU of the pair, it defaults to the interface MTU of the node
+	// configuration or to the bridge MTU.
+	MTU int `json:"mtu,omitempty"`
+	// HardwareAddress of the interface inside the pod, it is random by default.
+	HardwareAddress string `json:"hardwareAddress,omitempty"`
+	// Vlan is the untagged VLAN of the bridge port, it requires VLAN filtering on the bridge.
+	Vlan int `json:"vlan,omitempty"`
+	// TrunkVlans are the tagged VLANs allowed on the bridge port, for example "100-199,300".
+	TrunkVlans string `json:"trunkVlans,omitempty"`
+	// Hairpin enables the hairpin mode on the bridge port.
+	Hairpin bool `json:"hairpin,omitempty"`
+	// Learning enables or disables the MAC learning on the bridge port, it is enabled by default.
+	Learning *bool `json:"learning,omitempty"`
+	// DeviceType is the type of the pair connecting the pod to the bridge: veth
+	// (default) or netkit. Netkit falls back to veth on the kernels without support
+	// for it, unless BPF programs are configured.
+	DeviceType string `json:"deviceType,omitempty"`
+	// BPFPrograms are the pinned BPF programs attached to the netkit pair.
+	BPFPrograms *kndnet.NetkitPrograms `json:"bpfPrograms,omitempty"`
+	// IPAM configures the addresses and routes of the interface inside the pod.
+	IPAM ipam.Config `json:"ipam,omitempty"`
+	// PodInterfaceConfig configures the VRF and the rate limits of the interface
+	// inside the pod.
+	driver.PodInterfaceConfig `json:",inline"`
+}
+
+// preparedDevice is the validated configuration of a bridge port.
+type preparedDevice struct {
+	bridge    string
+	linkAttrs netlink.LinkAttrs
+	port      kndnet.BridgePortConfig
+	// netkit is true if the pod is connected with a netkit pair instead of a veth pair.
+	netkit bool
+	// programs are the BPF programs attached to the netkit pair, nil if there are none.
+	programs *kndnet.NetkitPrograms
+	// ipam is the addressing of the interface, nil if it is not configured.
+	ipam *ipam.Result
+	// podInterface is the configuration of the interface inside the pod.
+	podInterface driver.PodInterface
+}
+
+// preparedClaim maps the allocated devices of a claim to their configuration.
+type preparedClaim map[string]*preparedDevice
+
+func deviceKey(request, device, shareID string) string {
+	return request + "/" + device + "/" + shareID
+}
+
+// bridgeDriver implements the driver.Driver interface.
+type bridgeDriver struct {
+	bridges  []string
+	config   kndnet.BridgeConfig
+	maxPorts int64
+	// ipam allocates the addresses of the pod interfaces.
+	ipam *ipam.Allocator
+	// interfaceDefaults are the default settings of the pod interfaces, they are
+	// replaced on configuration reloads.
+	interfaceDefaults atomic.Pointer[kndconfig.InterfaceConfig]
+}
+
+// NewDriver creates a new instance of the bridge driver.
+func NewDriver(bridges []string, config kndnet.BridgeConfig, maxPorts int64, allocator *ipam.Allocator) *bridgeDriver {
+	d := &bridgeDriver{
+		bridges:  bridges,
+		config:   config,
+		maxPorts: maxPorts,
+		ipam:     allocator,
+	}
+	d.interfaceDefaults.Store(&kndconfig.InterfaceConfig{})
+	return d
+}
+
+// SetInterfaceDefaults replaces the default settings of the pod interfaces, they
+// are used by the claims prepared afterwards.
+func (d *bridgeDriver) SetInterfaceDefaults(defaults kndconfig.InterfaceConfig) {
+	d.interfaceDefaults.Store(&defaults)
+}
+
+// GetDevices advertises each bridge as a device that can be allocated multiple
+// times, creating the bridges that do not exist.
+func (d *bridgeDriver) GetDevices() ([]resourcev1.Device, error) {
+	var devices []resourcev1.Device
+	for _, name := range d.bridges {
+		bridge, err := kndnet.EnsureBridge(name, d.config)
+		if err != nil {
+			klog.Errorf("Failed to ensure bridge %s: %v", name, err)
+			continue
+		}
+		vlanFiltering := bridge.VlanFiltering != nil && *bridge.VlanFiltering
+		device := resourcev1.Device{
+			Name: name,
+			Attributes: map[resourcev1.QualifiedName]resourcev1.DeviceAttribute{
+				"bridge-name":    {StringValue: ptr.To(name)},
+				"mtu":            {IntValue: ptr.To(int64(bridge.MTU))},
+				"vlan-filtering": {BoolValue: ptr.To(vlanFiltering)},
+			},
+			AllowMultipleAllocations: ptr.To(true),
+			Capacity: map[resourcev1.QualifiedName]resourcev1.DeviceCapacity{
+				portsCapacity: {
+					Value: *resource.NewQuantity(d.maxPorts, resource.DecimalSI),
+					RequestPolicy: &resourcev1.CapacityRequestPolicy{
+						Default:     resource.NewQuantity(1, resource.DecimalSI),
+						ValidValues: []resource.Quantity{*resource.NewQuantity(1, resource.DecimalSI)},
+					},
+				},
+			},
+		}
+		devices = append(devices, device)
+		klog.V(2).Infof("Discovered bridge device: %s", name)
+	}
+	return devices, nil
+}
+
+// PrepareDevice validates the port configuration of every device allocated to the claim.
+func (d *bridgeDriver) PrepareDevice(ctx context.Context, claim *resourcev1.ResourceClaim) (interface{}, error) {
+	if claim.Status.Allocation == nil || len(claim.Status.Allocation.Devices.Results) == 0 {
+		return nil, fmt.Errorf("claim %s has no allocated devices", claim.Name)
+	}
+
+	prepared := preparedClaim{}
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if result.Driver != driverName {
+			continue
+		}
+		config := bridgeConfig{MTU: d.interfaceDefaults.Load().MTU}
+		if err := driver.DecodeDeviceConfig(claim, driverName, result.Request, &config); err != nil {
+			return nil, err
+		}
+		device, err := d.newPreparedDevice(result.Device, result.Request, config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration for request %s of claim %s/%s: %w", result.Request, claim.Namespace, claim.Name, err)
+		}
+		shareID := ""
+		if result.ShareID != nil {
+			shareID = string(*result.ShareID)
+		}
+		key := deviceKey(result.Request, result.Device, shareID)
+		if config.IPAM.Pool != "" {
+			device.ipam, err = ipam.PoolResult(claim, result, config.IPAM)
+			if err != nil {
+				return nil, err
+			}
+		} else if !config.IPAM.Empty() {
+			device.ipam, err = d.ipam.Allocate(claim.UID, key, config.IPAM)
+			if err != nil {
+				return nil, fmt.Errorf("failed to allocate addresses for request %s of claim %s/%s: %w", result.Request, claim.Namespace, claim.Name, err)
+			}
+		}
+		prepared[key] = device
+		klog.Infof("Preparing interface %q on bridge %q for claim %s/%s", device.linkAttrs.Name, device.bridge, claim.Namespace, claim.Name)
+	}
+	return prepared, nil
+}
+
+func (d *bridgeDriver) newPreparedDevice(bridge, request string, config bridgeConfig) (*preparedDevice, error) {
+	device := &preparedDevice{
+		bridge: bridge,
+		port: kndnet.BridgePortConfig{
+			Vlan:     config.Vlan,
+			Hairpin:  config.Hairpin,
+			Learning: config.Learning,
+		},
+	}
+
+	var err error
+	device.port.TrunkVlans, err = kndnet.ParseVlanRanges(config.TrunkVlans)
+	if err != nil {
+		return nil, err
+	}
+	if config.Vlan != 0 && (config.Vlan < kndnet.MinVlanID || config.Vlan > kndnet.MaxVlanID) {
+		return nil, fmt.Errorf("invalid VLAN ID %d, must be between %d and %d", config.Vlan, kndnet.MinVlanID, kndnet.MaxVlanID)
+	}
+	if (config.Vlan != 0 || len(device.port.TrunkVlans) > 0) && !d.config.VlanFiltering {
+		return nil, fmt.Errorf("VLANs require VLAN filtering on bridge %s", bridge)
+	}
+
+	switch config.DeviceType {
+	case "", deviceTypeVeth:
+		if !config.BPFPrograms.Empty() {
+			return nil, fmt.Errorf("BPF programs require a netkit device")
+		}
+	case deviceTypeNetkit:
+		device.netkit = true
+		if !config.BPFPrograms.Empty() {
+			device.programs = config.BPFPrograms
+		}
+	default:
+		return nil, fmt.Errorf("unknown device type %q", config.DeviceType)
+	}
+
+	device.linkAttrs.Name = config.InterfaceName
+	if device.linkAttrs.Name == "" {
+		// subrequests are named <request>/<subrequest>
+		device.linkAttrs.Name = request[strings.LastIndex(request, "/")+1:]
+	}
+	if len(device.linkAttrs.Name) > unix.IFNAMSIZ-1 {
+		device.linkAttrs.Name = device.linkAttrs.Name[:unix.IFNAMSIZ-1]
+	}
+	device.linkAttrs.MTU = config.MTU
+	if config.HardwareAddress != "" {
+		device.linkAttrs.HardwareAddr, err = net.ParseMAC(config.HardwareAddress)
+		if err != nil {
+			return nil, err
+		}
+	}
+	device.podInterface, err = driver.NewPodInterface(config.PodInterfaceConfig)
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// UnprepareDevice releases the addresses of the claim, the pairs are deleted
+// when the pod sandbox stops.
+func (d *bridgeDriver) UnprepareDevice(ctx context.Context, claim kubeletplugin.NamespacedObject) error {
+	klog.Infof("Unpreparing resources for claim %s", claim.Name)
+	return d.ipam.Release(claim.UID)
+}
+
+// ConfigureDeviceForPod creates a veth or netkit pair between the pod's network namespace
+// and the bridge.
+func (d *bridgeDriver) ConfigureDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
+	port, err := getPreparedDevice(device, preparedData)
+	if err != nil {
+		return err
+	}
+
+	bridge, err := kndnet.EnsureBridge(port.bridge, d.config)
+	if err != nil {
+		return err
+	}
+
+	hostIfName := hostInterfaceName(podSandbox.Uid, port.linkAttrs.Name)
+	klog.Infof("Creating interface %q on bridge %q in pod %s/%s network namespace %s, host interface %q",
+		port.linkAttrs.Name, port.bridge, podSandbox.Namespace, podSandbox.Name, networkNamespace, hostIfName)
+
+	hostLink, err := addPair(hostIfName, networkNamespace, port)
+	if err != nil {
+		return err
+	}
+	if err := kndnet.SetBridgePort(hostLink, bridge, port.port); err != nil {
+		_ = kndnet.DelHostLink(hostIfName)
+		return err
+	}
+	if err := port.podInterface.Configure(networkNamespace, port.linkAttrs.Name, port.ipam, d.interfaceDefaults.Load().Announce); err != nil {
+		_ = kndnet.DelHostLink(hostIfName)
+		return err
+	}
+	return nil
+}
+
+// addPair connects the pod namespace with a veth or netkit pair and returns the
+// device in the host namespace.
+func addPair(hostIfName string, networkNamespace string, port *preparedDevice) (netlink.Link, error) {
+	if !port.netkit {
+		hostLink, _, err := kndnet.NsAddVeth(hostIfName, networkNamespace, port.linkAttrs, port.ipam.IPNets())
+		return hostLink, err
+	}
+
+	hostLink, _, err := kndnet.NsAddNetkit(hostIfName, networkNamespace, port.linkAttrs, port.ipam.IPNets())
+	if errors.Is(err, kndnet.ErrNetkitNotSupported) && port.programs == nil {
+		klog.Infof("Netkit devices are not supported, using a veth pair for interface %q", port.linkAttrs.Name)
+		hostLink, _, err = kndnet.NsAddVeth(hostIfName, networkNamespace, port.linkAttrs, port.ipam.IPNets())
+		return hostLink, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if port.programs != nil {
+		if err := kndnet.AttachNetkitPrograms(hostLink, *port.programs); err != nil {
+			_ = kndnet.DelHostLink(hostIfName)
+			return nil, err
+		}
+	}
+	return hostLink, nil
+}
+
+// CleanupDeviceForPod deletes the veth or netkit pair.
+func (d *bridgeDriver) CleanupDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
+	port, err := getPreparedDevice(device, preparedData)
+	if err != nil {
+		return err
+	}
+
+	port.podInterface.Cleanup(networkNamespace, port.linkAttrs.Name)
+	hostIfName := hostInterfaceName(podSandbox.Uid, port.linkAttrs.Name)
+	klog.Infof("Deleting interface %q of pod %s/%s from bridge %q", hostIfName, podSandbox.Namespace, podSandbox.Name, port.bridge)
+	return kndnet.DelHostLink(hostIfName)
+}
+
+// hostInterfaceName returns a deterministic name for the host end of the
+// pair, so it can be found again when the pod sandbox stops.
+func hostInterfaceName(podUID, ifName string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(podUID + "/" + ifName))
+	return fmt.Sprintf("veth%08x", h.Sum32())
+}
+
+func getPreparedDevice(device driver.AllocatedDevice, preparedData interface{}) (*preparedDevice, error) {
+	prepared, ok := preparedData.(preparedClaim)
+	if !ok {
+		return nil, fmt.Errorf("invalid prepared data type: expected preparedClaim, got %T", preparedData)
+	}
+	port, ok := prepared[deviceKey(device.Request, device.Name, device.ShareID)]
+	if !ok {
+		return nil, fmt.Errorf("device %s for request %s was not prepared", device.Name, device.Request)
+	}
+	return port, nil
+}
+
+// HandleError logs background errors from the driver framework.
+func (d *bridgeDriver) HandleError(ctx context.Context, err error, msg string) {
+	klog.Errorf("Background error in driver framework: %s: %v", msg, err)
+}
+
+//================================================================
+// Main Entrypoint
+//================================================================
+
+const (
+	driverName = "bridge.k8s.io"
+)
+
+var (
+	hostnameOverride string
+	kubeconfig       string
+	bindAddress      string
+	bridgeNames      string
+	bridgeMTU        int
+	vlanFiltering    bool
+	maxPorts         int64
+	configFile       string
+	ipamStateFile    string
+	ready            atomic.Bool
+)
+
+func init() {
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "Absolute path to the kubeconfig file.")
+	flag.StringVar(&bindAddress, "bind-address", ":9180", "The IP address and port for the metrics and healthz server.")
+	flag.StringVar(&hostnameOverride, "hostname-override", "", "Node name to use. Defaults to the node's hostname.")
+	flag.StringVar(&bridgeNames, "bridges", "knd0", "Comma separated list of bridges to attach the pods to. The bridges that do not exist are created by the driver.")
+	flag.IntVar(&bridgeMTU, "bridge-mtu", 0, "MTU of the bridges created by the driver. Defaults to the kernel default.")
+	flag.BoolVar(&vlanFiltering, "vlan-filtering", false, "Enable VLAN filtering on the bridges, required to configure VLANs on the bridge ports.")
+	flag.Int64Var(&maxPorts, "max-ports", 256, "Maximum number of pod interfaces that can be attached to each bridge.")
+	flag.StringVar(&configFile, "config", "", "Path to the YAML or JSON node configuration file, it is reloaded when it changes.")
+	flag.StringVar(&ipamStateFile, "ipam-state-file", "/var/lib/knd/"+driverName+"/ipam.json", "Path to the file that persists the addresses allocated to the claims.")
+	klog.InitFlags(nil)
+}
+
+func main() {
+	flag.Parse()
+	printVersion()
+
+	// Create a context that is cancelled on interruption signals.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, unix.SIGTERM)
+	defer cancel()
+
+	// Set up healthz and metrics endpoints.
+	setupHTTPServer()
+
+	// Set up Kubernetes client.
+	clientset, err := newClientset()
+	if err != nil {
+		klog.Fatalf("Failed to create Kubernetes client: %v", err)
+	}
+
+	nodeName, err := nodeutil.GetHostname(hostnameOverride)
+	if err != nil {
+		klog.Fatalf("Cannot get node name: %v", err)
+	}
+
+	nodeConfig, err := kndconfig.Load(configFile)
+	if err != nil {
+		klog.Fatalf("Invalid node configuration: %v", err)
+	}
+
+	allocator, err := ipam.NewAllocator(ipamStateFile)
+	if err != nil {
+		klog.Fatalf("Failed to load IPAM state: %v", err)
+	}
+
+	// 1. Create an instance of the bridge driver.
+	bridgeDriver := NewDriver(strings.Split(bridgeNames, ","), kndnet.BridgeConfig{MTU: bridgeMTU, VlanFiltering: vlanFiltering}, maxPorts, allocator)
+	bridgeDriver.SetInterfaceDefaults(nodeConfig.Interface)
+
+	// 2. Create the plugin framework, passing in the driver.
+	plugin := driver.NewPlugin(bridgeDriver, driverName, nodeName, clientset, driver.WithNodeConfig(nodeConfig))
+
+	// 3. Start the plugin framework.
+	if err := plugin.Start(ctx); err != nil {
+		klog.Fatalf("Driver failed to start: %v", err)
+	}
+	defer plugin.Stop()
+
+	if configFile != "" {
+		err := kndconfig.Watch(ctx, configFile, nodeConfig, func(c *kndconfig.NodeConfig) {
+			bridgeDriver.SetInterfaceDefaults(c.Interface)
+			plugin.UpdateNodeConfig(c)
+		})
+		if err != nil {
+			klog.Fatalf("Failed to watch node configuration: %v", err)
+		}
+	}
+
+	ready.Store(true)
+	klog.Infof("Driver started successfully on node %s", nodeName)
+
+	// Wait for the context to be cancelled.
+	<-ctx.Done()
+	klog.Info("Driver shutting down.")
+}
+
+func setupHTTPServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if ready.Load() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: bindAddress, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			klog.Fatalf("Failed to listen and serve: %v", err)
+		}
+	}()
+}
+
+func newClientset() (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create client-go config: %w", err)
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+func printVersion() {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		klog.Infof("Version: %s, Go version: %s", info.Main.Version, info.GoVersion)
+	}
+}
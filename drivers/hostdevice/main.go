@@ -9,7 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime/debug"
-	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -19,6 +19,7 @@ import (
 	"golang.org/x/sys/unix"
 
 	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -26,7 +27,10 @@ import (
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
 	"k8s.io/klog/v2"
 
+	kndconfig "github.com/aojea/kubernetes-network-drivers/pkg/config"
+	"github.com/aojea/kubernetes-network-drivers/pkg/discovery"
 	"github.com/aojea/kubernetes-network-drivers/pkg/driver"
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
 	kndnet "github.com/aojea/kubernetes-network-drivers/pkg/net"
 )
 
@@ -35,45 +39,92 @@ import (
 //================================================================
 
 // hostdeviceDriver implements the driver.Driver interface.
-type hostdeviceDriver struct{}
+type hostdeviceDriver struct {
+	// policy selects the interfaces that are published, it is replaced on configuration reloads.
+	policy atomic.Pointer[discovery.Policy]
+	// nodeIPs are the node addresses, the interfaces holding them are never published.
+	nodeIPs []net.IP
+	// ipam allocates the addresses of the pod interfaces.
+	ipam *ipam.Allocator
+
+	// interfaceDefaults are the default settings of the pod interfaces, they are
+	// replaced on configuration reloads.
+	interfaceDefaults atomic.Pointer[kndconfig.InterfaceConfig]
+
+	mu sync.Mutex
+	// dhcpClients keeps the leases of the pod interfaces, indexed by pod UID and interface name.
+	dhcpClients map[string]*kndnet.DHCPClient
+}
 
 // NewDriver creates a new instance of the hostdevice driver.
-func NewDriver() driver.Driver {
-	return &hostdeviceDriver{}
+func NewDriver(policy *discovery.Policy, nodeIPs []net.IP, allocator *ipam.Allocator) *hostdeviceDriver {
+	d := &hostdeviceDriver{
+		nodeIPs:     nodeIPs,
+		ipam:        allocator,
+		dhcpClients: map[string]*kndnet.DHCPClient{},
+	}
+	d.policy.Store(policy)
+	d.interfaceDefaults.Store(&kndconfig.InterfaceConfig{})
+	return d
+}
+
+// SetPolicy replaces the device policy, it is used on the next discovery.
+func (d *hostdeviceDriver) SetPolicy(policy *discovery.Policy) {
+	d.policy.Store(policy)
+}
+
+// SetInterfaceDefaults replaces the default settings of the pod interfaces, they
+// are used by the devices attached afterwards.
+func (d *hostdeviceDriver) SetInterfaceDefaults(defaults kndconfig.InterfaceConfig) {
+	d.interfaceDefaults.Store(&defaults)
 }
 
 // GetDevices discovers all physical network interfaces on the host.
 func (d *hostdeviceDriver) GetDevices() ([]resourceapi.Device, error) {
-	links, err := netlink.LinkList()
+	interfaces, err := discovery.Discover()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list network interfaces: %w", err)
 	}
 
+	policy := d.policy.Load()
 	var devices []resourceapi.Device
-	for _, link := range links {
-		attrs := link.Attrs()
-
-		// Skip loopback, virtual, and down interfaces
-		if attrs.Flags&net.FlagLoopback != 0 || attrs.Flags&net.FlagUp == 0 {
-			continue
-		}
-		if strings.HasPrefix(attrs.Name, "veth") || strings.HasPrefix(attrs.Name, "docker") || strings.HasPrefix(attrs.Name, "cni") {
+	for _, iface := range interfaces {
+		if !policy.Allowed(iface, d.nodeIPs) {
+			klog.V(4).Infof("Skipping device %s not allowed by the device policy", iface.Name)
 			continue
 		}
 
-		device := resourceapi.Device{
-			Name: attrs.Name,
-			Attributes: map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
-				"interface-name": {StringValue: &attrs.Name},
-				"mac-address":    {StringValue: func() *string { s := attrs.HardwareAddr.String(); return &s }()},
-			},
-		}
-		devices = append(devices, device)
-		klog.V(2).Infof("Discovered device: %s", attrs.Name)
+		devices = append(devices, iface.Device())
+		klog.V(2).Infof("Discovered device: %s", iface.Name)
 	}
 	return devices, nil
 }
 
+// hostdeviceConfig is the opaque configuration accepted in the claim or in the DeviceClass.
+type hostdeviceConfig struct {
+	// IPAM configures the addresses and routes of the interface inside the pod.
+	IPAM ipam.Config `json:"ipam,omitempty"`
+	// PodInterfaceConfig configures the VRF and the rate limits of the interface
+	// inside the pod.
+	driver.PodInterfaceConfig `json:",inline"`
+}
+
+// preparedDevice is the information needed to move the device into the pod.
+type preparedDevice struct {
+	// name of the network interface in the host namespace.
+	name string
+	// ipam is the addressing of the interface, nil if it is not configured.
+	ipam *ipam.Result
+	// dhcp is the configuration of the DHCP client of the interface, nil if it is not used.
+	dhcp *ipam.Config
+	// podInterface is the configuration of the interface inside the pod.
+	podInterface driver.PodInterface
+	// rdmaDevice associated to the network interface, if any.
+	rdmaDevice *kndnet.RdmaDevice
+	// moveRdma is true if the RDMA device has to be moved with the network interface.
+	moveRdma bool
+}
+
 // PrepareDevice extracts the target interface name from the claim.
 func (d *hostdeviceDriver) PrepareDevice(ctx context.Context, claim *resourceapi.ResourceClaim) (interface{}, error) {
 	if claim.Status.Allocation == nil || len(claim.Status.Allocation.Devices.Results) == 0 {
@@ -82,24 +133,66 @@ func (d *hostdeviceDriver) PrepareDevice(ctx context.Context, claim *resourceapi
 
 	// For this simple driver, we just need the name of the device to move.
 	// The device name is the primary information we need for ConfigureDeviceForPod.
-	deviceName := claim.Status.Allocation.Devices.Results[0].Device
+	result := claim.Status.Allocation.Devices.Results[0]
+	deviceName := result.Device
 	klog.Infof("Preparing device %q for claim %s", deviceName, claim.Name)
 
-	return deviceName, nil
+	config := hostdeviceConfig{}
+	if err := driver.DecodeDeviceConfig(claim, driverName, result.Request, &config); err != nil {
+		return nil, err
+	}
+
+	// The RDMA device is only visible in the host namespace before the interface is moved.
+	rdmaDevice, err := kndnet.GetRdmaDevice(deviceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get RDMA device for %s: %w", deviceName, err)
+	}
+	prepared := &preparedDevice{
+		name:       deviceName,
+		rdmaDevice: rdmaDevice,
+	}
+	prepared.podInterface, err = driver.NewPodInterface(config.PodInterfaceConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration for device %s of claim %s/%s: %w", deviceName, claim.Namespace, claim.Name, err)
+	}
+	if config.IPAM.DHCP != nil {
+		// the address is obtained inside the pod namespace once the interface is attached
+		if err := config.IPAM.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid IPAM configuration for device %s of claim %s/%s: %w", deviceName, claim.Namespace, claim.Name, err)
+		}
+		prepared.dhcp = &config.IPAM
+	} else if config.IPAM.Pool != "" {
+		prepared.ipam, err = ipam.PoolResult(claim, result, config.IPAM)
+		if err != nil {
+			return nil, err
+		}
+	} else if !config.IPAM.Empty() {
+		prepared.ipam, err = d.ipam.Allocate(claim.UID, result.Request+"/"+deviceName, config.IPAM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate addresses for device %s of claim %s/%s: %w", deviceName, claim.Namespace, claim.Name, err)
+		}
+	}
+	if rdmaDevice != nil {
+		prepared.moveRdma = kndnet.RdmaNetnsExclusive()
+		klog.Infof("Device %q has RDMA device %q, exclusive network namespace mode: %v", deviceName, rdmaDevice.Name, prepared.moveRdma)
+	}
+	return prepared, nil
 }
 
-// UnprepareDevice is a no-op for this simple driver.
+// UnprepareDevice releases the addresses of the claim.
 func (d *hostdeviceDriver) UnprepareDevice(ctx context.Context, claim kubeletplugin.NamespacedObject) error {
 	klog.Infof("Unpreparing resources for claim %s", claim.Name)
-	return nil
+	return d.ipam.Release(claim.UID)
 }
 
-// ConfigureDeviceForPod moves the allocated network device into the pod's namespace.
-func (d *hostdeviceDriver) ConfigureDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
-	hostDeviceName, ok := preparedData.(string)
+// ConfigureDeviceForPod moves the allocated network device into the pod's namespace,
+// it is moved back to the host if it can not be configured.
+func (d *hostdeviceDriver) ConfigureDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) (err error) {
+	prepared, ok := preparedData.(*preparedDevice)
 	if !ok {
-		return fmt.Errorf("invalid prepared data type: expected string, got %T", preparedData)
+		return fmt.Errorf("invalid prepared data type: expected *preparedDevice, got %T", preparedData)
 	}
+	hostDeviceName := prepared.name
 
 	// The device name inside the pod will be the same as on the host.
 	podInterfaceName := hostDeviceName
@@ -108,19 +201,76 @@ func (d *hostdeviceDriver) ConfigureDeviceForPod(device driver.AllocatedDevice,
 		hostDeviceName, podSandbox.Namespace, podSandbox.Name, networkNamespace, podInterfaceName)
 
 	// Here we use the plumbing library to do the actual work.
-	_, err := kndnet.NsAttachNetdev(hostDeviceName, networkNamespace, netlink.LinkAttrs{Name: podInterfaceName}, nil)
-	return err
+	_, err = kndnet.NsAttachNetdev(hostDeviceName, networkNamespace, netlink.LinkAttrs{Name: podInterfaceName}, prepared.ipam.IPNets())
+	if err != nil {
+		return err
+	}
+	// the device is moved back to the host if it can not be configured, so it is not
+	// lost in the namespace of a pod that fails to start
+	defer func() {
+		if err == nil {
+			return
+		}
+		if cleanupErr := d.CleanupDeviceForPod(device, networkNamespace, podSandbox, preparedData); cleanupErr != nil {
+			klog.Errorf("failed to move device %s back to host namespace: %v", podInterfaceName, cleanupErr)
+		}
+	}()
+
+	if prepared.moveRdma {
+		klog.Infof("Moving RDMA device %q to pod %s/%s network namespace %s",
+			prepared.rdmaDevice.Name, podSandbox.Namespace, podSandbox.Name, networkNamespace)
+		if err := kndnet.NsAttachRdma(prepared.rdmaDevice.Name, networkNamespace); err != nil {
+			return err
+		}
+	}
+	// the DHCP client is only started without static addresses, so nothing fails
+	// once the addresses are announced
+	if err := prepared.podInterface.Configure(networkNamespace, podInterfaceName, prepared.ipam, d.interfaceDefaults.Load().Announce); err != nil {
+		return err
+	}
+	if prepared.dhcp != nil {
+		client, err := kndnet.NsStartDHCP(networkNamespace, podInterfaceName, *prepared.dhcp)
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		d.dhcpClients[podSandbox.Uid+"/"+podInterfaceName] = client
+		d.mu.Unlock()
+	}
+	return nil
 }
 
 // CleanupDeviceForPod moves the network device back to the host namespace.
 func (d *hostdeviceDriver) CleanupDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
-	hostDeviceName, ok := preparedData.(string)
+	prepared, ok := preparedData.(*preparedDevice)
 	if !ok {
-		return fmt.Errorf("invalid prepared data type: expected string, got %T", preparedData)
+		return fmt.Errorf("invalid prepared data type: expected *preparedDevice, got %T", preparedData)
 	}
+	hostDeviceName := prepared.name
 
 	podInterfaceName := hostDeviceName
 
+	// release the lease before the interface leaves the pod namespace
+	d.mu.Lock()
+	client, ok := d.dhcpClients[podSandbox.Uid+"/"+podInterfaceName]
+	delete(d.dhcpClients, podSandbox.Uid+"/"+podInterfaceName)
+	d.mu.Unlock()
+	if ok {
+		if err := client.Stop(); err != nil {
+			klog.Errorf("failed to stop DHCP client of device %s: %v", podInterfaceName, err)
+		}
+	}
+
+	if prepared.moveRdma {
+		klog.Infof("Moving RDMA device %q from pod %s/%s back to host namespace",
+			prepared.rdmaDevice.Name, podSandbox.Namespace, podSandbox.Name)
+		if err := kndnet.NsDetachRdma(networkNamespace, prepared.rdmaDevice.Name); err != nil {
+			klog.Errorf("failed to move RDMA device %s back to host namespace: %v", prepared.rdmaDevice.Name, err)
+		}
+	}
+
+	prepared.podInterface.Cleanup(networkNamespace, podInterfaceName)
+
 	klog.Infof("Moving device %q from pod %s/%s back to host namespace",
 		podInterfaceName, podSandbox.Namespace, podSandbox.Name)
 
@@ -128,6 +278,27 @@ func (d *hostdeviceDriver) CleanupDeviceForPod(device driver.AllocatedDevice, ne
 	return kndnet.NsDetachNetdev(networkNamespace, podInterfaceName, hostDeviceName)
 }
 
+// ContainerDevices exposes the RDMA char devices of the device to the containers.
+func (d *hostdeviceDriver) ContainerDevices(device driver.AllocatedDevice, preparedData interface{}) ([]*api.LinuxDevice, error) {
+	prepared, ok := preparedData.(*preparedDevice)
+	if !ok {
+		return nil, fmt.Errorf("invalid prepared data type: expected *preparedDevice, got %T", preparedData)
+	}
+	if prepared.rdmaDevice == nil {
+		return nil, nil
+	}
+
+	var devices []*api.LinuxDevice
+	for _, path := range prepared.rdmaDevice.CharDevices {
+		linuxDevice, err := driver.LinuxDeviceFromPath(path)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, linuxDevice)
+	}
+	return devices, nil
+}
+
 // HandleError logs background errors.
 func (d *hostdeviceDriver) HandleError(ctx context.Context, err error, msg string) {
 	klog.Errorf("background error: %s: %v", msg, err)
@@ -145,6 +316,10 @@ var (
 	hostnameOverride string
 	kubeconfig       string
 	bindAddress      string
+	devicePolicy     string
+	devicePolicyFile string
+	configFile       string
+	ipamStateFile    string
 	ready            atomic.Bool
 )
 
@@ -152,6 +327,10 @@ func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "absolute path to the kubeconfig file")
 	flag.StringVar(&bindAddress, "bind-address", ":9177", "The IP address and port for the metrics and healthz server to serve on")
 	flag.StringVar(&hostnameOverride, "hostname-override", "", "If non-empty, will be used as the name of the Node is running on.")
+	flag.StringVar(&devicePolicy, "device-policy", "", "Inline YAML or JSON policy with the include and exclude rules of the published interfaces.")
+	flag.StringVar(&devicePolicyFile, "device-policy-file", "", "Path to a YAML or JSON file with the device policy, it is ignored if --device-policy is set.")
+	flag.StringVar(&configFile, "config", "", "Path to the YAML or JSON node configuration file, it is reloaded when it changes. The device policy flags take precedence over its device policy.")
+	flag.StringVar(&ipamStateFile, "ipam-state-file", "/var/lib/knd/"+driverName+"/ipam.json", "Path to the file that persists the addresses allocated to the claims.")
 	klog.InitFlags(nil)
 	flag.Parse()
 }
@@ -176,11 +355,39 @@ func main() {
 		klog.Fatalf("Cannot get node name: %v", err)
 	}
 
+	nodeConfig, err := kndconfig.Load(configFile)
+	if err != nil {
+		klog.Fatalf("Invalid node configuration: %v", err)
+	}
+
+	policy, err := loadDevicePolicy()
+	if err != nil {
+		klog.Fatalf("Invalid device policy: %v", err)
+	}
+	if policy == nil {
+		policy = nodeConfig.DevicePolicy
+	}
+
+	// The interfaces holding the node IPs are never published, to not disconnect the node.
+	var nodeIPs []net.IP
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("Failed to get node %s, only the default route interfaces are protected: %v", nodeName, err)
+	} else {
+		nodeIPs = discovery.NodeIPs(node)
+	}
+
+	allocator, err := ipam.NewAllocator(ipamStateFile)
+	if err != nil {
+		klog.Fatalf("Failed to load IPAM state: %v", err)
+	}
+
 	// 1. Create an instance of the driver
-	hdDriver := NewDriver()
+	hdDriver := NewDriver(policy, nodeIPs, allocator)
+	hdDriver.SetInterfaceDefaults(nodeConfig.Interface)
 
 	// 2. Create the plugin, passing in your driver instance
-	plugin := driver.NewPlugin(hdDriver, driverName, nodeName, clientset)
+	plugin := driver.NewPlugin(hdDriver, driverName, nodeName, clientset, driver.WithNodeConfig(nodeConfig))
 
 	// 3. Start the plugin
 	if err := plugin.Start(ctx); err != nil {
@@ -188,6 +395,19 @@ func main() {
 	}
 	defer plugin.Stop()
 
+	if configFile != "" {
+		err := kndconfig.Watch(ctx, configFile, nodeConfig, func(c *kndconfig.NodeConfig) {
+			if devicePolicy == "" && devicePolicyFile == "" {
+				hdDriver.SetPolicy(c.DevicePolicy)
+			}
+			hdDriver.SetInterfaceDefaults(c.Interface)
+			plugin.UpdateNodeConfig(c)
+		})
+		if err != nil {
+			klog.Fatalf("Failed to watch node configuration: %v", err)
+		}
+	}
+
 	ready.Store(true)
 	klog.Infof("Driver started successfully on node %s", nodeName)
 
@@ -196,6 +416,17 @@ func main() {
 	klog.Info("Driver shutting down")
 }
 
+// loadDevicePolicy returns the device policy from the flags, or nil if they are not set.
+func loadDevicePolicy() (*discovery.Policy, error) {
+	if devicePolicy != "" {
+		return discovery.ParsePolicy([]byte(devicePolicy))
+	}
+	if devicePolicyFile != "" {
+		return discovery.LoadPolicy(devicePolicyFile)
+	}
+	return nil, nil
+}
+
 func setupHTTPServer() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/controller"
+	"github.com/aojea/kubernetes-network-drivers/pkg/ippool"
+)
+
+const (
+	controllerName = "ippool-controller"
+)
+
+var (
+	kubeconfig    string
+	deviceClasses string
+	gcInterval    time.Duration
+	workers       int
+	resyncPeriod  time.Duration
+
+	bindAddress    string
+	leaderElection *controller.LeaderElectionFlags
+)
+
+func init() {
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "absolute path to the kubeconfig file")
+	flag.StringVar(&deviceClasses, "device-classes", "", "Comma separated list of the DeviceClasses whose claims are handled, all if empty. Only the claims with devices configured with an IP pool are handled.")
+	flag.DurationVar(&gcInterval, "gc-interval", 5*time.Minute, "Interval of the garbage collection of the addresses of deleted claims.")
+	flag.IntVar(&workers, "workers", 2, "Number of claims reconciled in parallel.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute, "Period of the reconciliation of all the claims.")
+	leaderElection = controller.AddLeaderElectionFlags(flag.CommandLine, controllerName)
+	flag.StringVar(&bindAddress, "bind-address", ":9182", "The IP address and port for the metrics, healthz and readyz server.")
+	klog.InitFlags(nil)
+	flag.Parse()
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, unix.SIGTERM)
+	defer cancel()
+
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		klog.Fatalf("Failed to create Kubernetes client config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Failed to create Kubernetes client: %v", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Failed to create Kubernetes dynamic client: %v", err)
+	}
+
+	var classes []string
+	for _, class := range strings.Split(deviceClasses, ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			classes = append(classes, class)
+		}
+	}
+
+	// 1. Create the reconciler
+	reconciler := ippool.NewReconciler(clientset, dynamicClient, classes...)
+
+	// 2. Create and run the controller, with leader election when several replicas
+	// run. The leader garbage collects the addresses leaked while the controller was
+	// not running.
+	opts := []controller.Option{
+		controller.WithWorkers(workers),
+		controller.WithResyncPeriod(resyncPeriod),
+		controller.WithLeaderFunc(func(ctx context.Context) { reconciler.Run(ctx, gcInterval) }),
+	}
+	if config, ok := leaderElection.LeaderElection(); ok {
+		opts = append(opts, controller.WithLeaderElection(config))
+	}
+	ctrl := controller.NewController(clientset, reconciler, controllerName, opts...)
+	controller.StartHTTPServer(bindAddress, ctrl.Readyz)
+	ctrl.Run(ctx)
+}
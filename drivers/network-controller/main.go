@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/controller"
+	"github.com/aojea/kubernetes-network-drivers/pkg/ippool"
+	"github.com/aojea/kubernetes-network-drivers/pkg/vlanid"
+)
+
+const (
+	managerName = "network-controller"
+
+	ippoolControllerName = "ippool-controller"
+	vlanidControllerName = "vlanid-controller"
+)
+
+var (
+	kubeconfig   string
+	controllers  string
+	workers      int
+	resyncPeriod time.Duration
+
+	ippoolDeviceClasses string
+	ippoolGCInterval    time.Duration
+
+	vlanidDriverName    string
+	vlanidFabrics       string
+	vlanidDeviceClasses string
+
+	bindAddress    string
+	leaderElection *controller.LeaderElectionFlags
+)
+
+func init() {
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "absolute path to the kubeconfig file")
+	flag.StringVar(&controllers, "controllers", "ippool,vlanid", "Comma separated list of the controllers to run, ippool and vlanid.")
+	flag.IntVar(&workers, "workers", 2, "Number of claims reconciled in parallel by every controller.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute, "Period of the reconciliation of all the claims.")
+	flag.StringVar(&ippoolDeviceClasses, "ippool-device-classes", "", "Comma separated list of the DeviceClasses whose claims get addresses of the IP pools, all if empty. Only the claims with devices configured with an IP pool are handled.")
+	flag.DurationVar(&ippoolGCInterval, "ippool-gc-interval", 5*time.Minute, "Interval of the garbage collection of the addresses of deleted claims.")
+	flag.StringVar(&vlanidDriverName, "vlanid-driver-name", "vlan.k8s.io", "Name of the VLAN driver of the nodes, the VLAN IDs are published as its devices.")
+	flag.StringVar(&vlanidFabrics, "vlanid-fabrics", "", "Semicolon separated list of fabrics with their VLAN IDs and the labels of their nodes, for example \"storage:100-199:fabric=storage;backend:10-20\".")
+	flag.StringVar(&vlanidDeviceClasses, "vlanid-device-classes", "vlan-id", "Comma separated list of the DeviceClasses of the VLAN IDs, whose claims are tracked.")
+	leaderElection = controller.AddLeaderElectionFlags(flag.CommandLine, managerName)
+	flag.StringVar(&bindAddress, "bind-address", ":9182", "The IP address and port for the metrics, healthz and readyz server.")
+	klog.InitFlags(nil)
+	flag.Parse()
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, unix.SIGTERM)
+	defer cancel()
+
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		klog.Fatalf("Failed to create Kubernetes client config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Failed to create Kubernetes client: %v", err)
+	}
+
+	// 1. Create the manager, the controllers share its informers and its Lease
+	var managerOpts []controller.ManagerOption
+	managerOpts = append(managerOpts, controller.WithManagerResyncPeriod(resyncPeriod))
+	if config, ok := leaderElection.LeaderElection(); ok {
+		managerOpts = append(managerOpts, controller.WithManagerLeaderElection(config))
+	}
+	manager := controller.NewManager(clientset, managerName, managerOpts...)
+	opts := []controller.Option{
+		controller.WithWorkers(workers),
+		controller.WithResyncPeriod(resyncPeriod),
+	}
+
+	// 2. Register the controllers, each one with its own queue and workers
+	for _, name := range splitList(controllers) {
+		switch name {
+		case "ippool":
+			dynamicClient, err := dynamic.NewForConfig(config)
+			if err != nil {
+				klog.Fatalf("Failed to create Kubernetes dynamic client: %v", err)
+			}
+			reconciler := ippool.NewReconciler(clientset, dynamicClient, splitList(ippoolDeviceClasses)...)
+			// the addresses leaked while the controller was not running are garbage
+			// collected by the leader
+			gc := controller.WithLeaderFunc(func(ctx context.Context) { reconciler.Run(ctx, ippoolGCInterval) })
+			if _, err := manager.Register(reconciler, ippoolControllerName, append(opts, gc)...); err != nil {
+				klog.Fatalf("Failed to register the IP pool controller: %v", err)
+			}
+		case "vlanid":
+			fabrics, err := vlanid.ParseFabrics(vlanidFabrics)
+			if err != nil {
+				klog.Fatalf("Invalid fabrics %q: %v", vlanidFabrics, err)
+			}
+			if len(fabrics) == 0 {
+				klog.Fatalf("No fabrics configured, use --vlanid-fabrics")
+			}
+			// the VLAN IDs are only allocated from the devices of the VLAN driver
+			reconciler := vlanid.NewReconciler(vlanidDriverName, fabrics, splitList(vlanidDeviceClasses)...)
+			vlanidOpts := append([]controller.Option{controller.WithDrivers(vlanidDriverName)}, opts...)
+			if _, err := manager.Register(reconciler, vlanidControllerName, vlanidOpts...); err != nil {
+				klog.Fatalf("Failed to register the VLAN ID controller: %v", err)
+			}
+		default:
+			klog.Fatalf("Unknown controller %q", name)
+		}
+	}
+
+	// 3. Run the controllers
+	controller.StartHTTPServer(bindAddress, manager.Readyz)
+	manager.Run(ctx)
+}
+
+// splitList returns the items of a comma separated list.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	resourcev1 "k8s.io/api/resource/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	nodeutil "k8s.io/component-helpers/node/util"
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	kndconfig "github.com/aojea/kubernetes-network-drivers/pkg/config"
+	"github.com/aojea/kubernetes-network-drivers/pkg/driver"
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+	kndnet "github.com/aojea/kubernetes-network-drivers/pkg/net"
+)
+
+//================================================================
+// Driver Implementation
+//================================================================
+
+// vfConfig is the opaque configuration accepted in the claim or in the DeviceClass.
+type vfConfig struct {
+	// InterfaceName is the name of the interface inside the pod, it defaults to the request name.
+	InterfaceName string `json:"interfaceName,omitempty"`
+	// MTU of the interface inside the pod, it defaults to the interface MTU of the node
+	// configuration or to the VF MTU.
+	MTU int `json:"mtu,omitempty"`
+	// HardwareAddress of the VF, set through the PF.
+	HardwareAddress string `json:"hardwareAddress,omitempty"`
+	// Vlan is the VLAN the VF traffic is tagged with by the PF.
+	Vlan int `json:"vlan,omitempty"`
+	// VlanQoS is the 802.1p priority of the VLAN.
+	VlanQoS int `json:"vlanQoS,omitempty"`
+	// VlanProtocol is the tag protocol of the VLAN: 802.1Q (default) or 802.1ad.
+	VlanProtocol string `json:"vlanProtocol,omitempty"`
+	// SpoofCheck enables the MAC spoof checking on the VF.
+	SpoofCheck *bool `json:"spoofCheck,omitempty"`
+	// Trust allows the VF to change its MAC address and to enter promiscuous mode.
+	Trust *bool `json:"trust,omitempty"`
+	// MinTxRate is the minimum transmit rate of the VF in Mbps.
+	MinTxRate int `json:"minTxRate,omitempty"`
+	// MaxTxRate is the maximum transmit rate of the VF in Mbps.
+	MaxTxRate int `json:"maxTxRate,omitempty"`
+	// IPAM configures the addresses and routes of the interface inside the pod.
+	IPAM ipam.Config `json:"ipam,omitempty"`
+	// PodInterfaceConfig configures the VRF and the rate limits of the interface
+	// inside the pod, unlike MaxTxRate the rates also limit the received traffic.
+	driver.PodInterfaceConfig `json:",inline"`
+}
+
+// preparedDevice is the validated configuration of a VF.
+type preparedDevice struct {
+	vf        kndnet.VirtualFunction
+	linkAttrs netlink.LinkAttrs
+	config    kndnet.VFConfig
+	// ipam is the addressing of the interface, nil if it is not configured.
+	ipam *ipam.Result
+	// dhcp is the configuration of the DHCP client of the interface, nil if it is not used.
+	dhcp *ipam.Config
+	// podInterface is the configuration of the interface inside the pod.
+	podInterface driver.PodInterface
+}
+
+// preparedClaim maps the allocated devices of a claim to their configuration.
+type preparedClaim map[string]*preparedDevice
+
+func deviceKey(request, device string) string {
+	return request + "/" + device
+}
+
+// sriovDriver implements the driver.Driver interface.
+type sriovDriver struct {
+	// pfs are the physical functions whose VFs are published, all the SR-IOV capable
+	// interfaces with VFs enabled are used if it is empty.
+	pfs []string
+	// ipam allocates the addresses of the pod interfaces.
+	ipam *ipam.Allocator
+	// interfaceDefaults are the default settings of the pod interfaces, they are
+	// replaced on configuration reloads.
+	interfaceDefaults atomic.Pointer[kndconfig.InterfaceConfig]
+
+	mu sync.Mutex
+	// dhcpClients keeps the leases of the pod interfaces, indexed by pod UID and interface name.
+	dhcpClients map[string]*kndnet.DHCPClient
+}
+
+// NewDriver creates a new instance of the SR-IOV driver.
+func NewDriver(pfs []string, allocator *ipam.Allocator) *sriovDriver {
+	d := &sriovDriver{
+		pfs:         pfs,
+		ipam:        allocator,
+		dhcpClients: map[string]*kndnet.DHCPClient{},
+	}
+	d.interfaceDefaults.Store(&kndconfig.InterfaceConfig{})
+	return d
+}
+
+// SetInterfaceDefaults replaces the default settings of the pod interfaces, they
+// are used by the claims prepared afterwards.
+func (d *sriovDriver) SetInterfaceDefaults(defaults kndconfig.InterfaceConfig) {
+	d.interfaceDefaults.Store(&defaults)
+}
+
+// virtualFunctions returns the VFs of the configured physical functions.
+func (d *sriovDriver) virtualFunctions() ([]kndnet.VirtualFunction, error) {
+	pfs := d.pfs
+	if len(pfs) == 0 {
+		discovered, err := kndnet.DiscoverPhysicalFunctions()
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover SR-IOV physical functions: %w", err)
+		}
+		for _, pf := range discovered {
+			pfs = append(pfs, pf.Name)
+		}
+	}
+
+	var vfs []kndnet.VirtualFunction
+	for _, pf := range pfs {
+		pfVFs, err := kndnet.ListVirtualFunctions(pf)
+		if err != nil {
+			klog.Errorf("Failed to list VFs of %s: %v", pf, err)
+			continue
+		}
+		vfs = append(vfs, pfVFs...)
+	}
+	return vfs, nil
+}
+
+func vfDeviceName(vf kndnet.VirtualFunction) string {
+	return fmt.Sprintf("%s-vf%d", vf.PFName, vf.Index)
+}
+
+// GetDevices advertises each VF of the physical functions as a device.
+func (d *sriovDriver) GetDevices() ([]resourcev1.Device, error) {
+	vfs, err := d.virtualFunctions()
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []resourcev1.Device
+	for _, vf := range vfs {
+		device := resourcev1.Device{
+			Name: vfDeviceName(vf),
+			Attributes: map[resourcev1.QualifiedName]resourcev1.DeviceAttribute{
+				"pf-name":     {StringValue: ptr.To(vf.PFName)},
+				"vf-index":    {IntValue: ptr.To(int64(vf.Index))},
+				"pci-address": {StringValue: ptr.To(vf.PCIAddress)},
+				"numa-node":   {IntValue: ptr.To(int64(vf.NUMANode))},
+			},
+		}
+		if vf.Driver != "" {
+			device.Attributes["kernel-driver"] = resourcev1.DeviceAttribute{StringValue: ptr.To(vf.Driver)}
+		}
+		// the interface is not visible while the VF is in a pod namespace
+		if vf.Name != "" {
+			device.Attributes["interface-name"] = resourcev1.DeviceAttribute{StringValue: ptr.To(vf.Name)}
+		}
+		devices = append(devices, device)
+		klog.V(2).Infof("Discovered VF %d of %s: %s", vf.Index, vf.PFName, vf.PCIAddress)
+	}
+	return devices, nil
+}
+
+// PrepareDevice resolves the VFs allocated to the claim and validates their configuration.
+func (d *sriovDriver) PrepareDevice(ctx context.Context, claim *resourcev1.ResourceClaim) (interface{}, error) {
+	if claim.Status.Allocation == nil || len(claim.Status.Allocation.Devices.Results) == 0 {
+		return nil, fmt.Errorf("claim %s has no allocated devices", claim.Name)
+	}
+
+	vfs, err := d.virtualFunctions()
+	if err != nil {
+		return nil, err
+	}
+
+	prepared := preparedClaim{}
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if result.Driver != driverName {
+			continue
+		}
+		var vf *kndnet.VirtualFunction
+		for i := range vfs {
+			if vfDeviceName(vfs[i]) == result.Device {
+				vf = &vfs[i]
+				break
+			}
+		}
+		if vf == nil {
+			return nil, fmt.Errorf("VF %s not found", result.Device)
+		}
+		if vf.Name == "" {
+			return nil, fmt.Errorf("VF %s has no network interface in the host namespace", result.Device)
+		}
+
+		config := vfConfig{MTU: d.interfaceDefaults.Load().MTU}
+		if err := driver.DecodeDeviceConfig(claim, driverName, result.Request, &config); err != nil {
+			return nil, err
+		}
+		device, err := newPreparedDevice(*vf, result.Request, config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration for request %s of claim %s/%s: %w", result.Request, claim.Namespace, claim.Name, err)
+		}
+		key := deviceKey(result.Request, result.Device)
+		if config.IPAM.DHCP != nil {
+			// the address is obtained inside the pod namespace once the VF is attached
+			if err := config.IPAM.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid IPAM configuration for request %s of claim %s/%s: %w", result.Request, claim.Namespace, claim.Name, err)
+			}
+			device.dhcp = &config.IPAM
+		} else if config.IPAM.Pool != "" {
+			device.ipam, err = ipam.PoolResult(claim, result, config.IPAM)
+			if err != nil {
+				return nil, err
+			}
+		} else if !config.IPAM.Empty() {
+			device.ipam, err = d.ipam.Allocate(claim.UID, key, config.IPAM)
+			if err != nil {
+				return nil, fmt.Errorf("failed to allocate addresses for request %s of claim %s/%s: %w", result.Request, claim.Namespace, claim.Name, err)
+			}
+		}
+		prepared[key] = device
+		klog.Infof("Preparing VF %d of %s (%s) for claim %s/%s", vf.Index, vf.PFName, vf.Name, claim.Namespace, claim.Name)
+	}
+	return prepared, nil
+}
+
+func newPreparedDevice(vf kndnet.VirtualFunction, request string, config vfConfig) (*preparedDevice, error) {
+	protocol, err := kndnet.ParseVlanProtocol(config.VlanProtocol)
+	if err != nil {
+		return nil, err
+	}
+	if config.Vlan < 0 || config.Vlan > kndnet.MaxVlanID {
+		return nil, fmt.Errorf("invalid VLAN ID %d, must be between %d and %d", config.Vlan, kndnet.MinVlanID, kndnet.MaxVlanID)
+	}
+	if config.VlanQoS < 0 || config.VlanQoS > 7 {
+		return nil, fmt.Errorf("invalid VLAN QoS %d, must be between 0 and 7", config.VlanQoS)
+	}
+	if config.MinTxRate < 0 || config.MaxTxRate < 0 || (config.MaxTxRate != 0 && config.MinTxRate > config.MaxTxRate) {
+		return nil, fmt.Errorf("invalid rates, minTxRate %d maxTxRate %d", config.MinTxRate, config.MaxTxRate)
+	}
+
+	device := &preparedDevice{
+		vf: vf,
+		config: kndnet.VFConfig{
+			Vlan:         config.Vlan,
+			VlanQoS:      config.VlanQoS,
+			VlanProtocol: protocol,
+			SpoofCheck:   config.SpoofCheck,
+			Trust:        config.Trust,
+			MinTxRate:    config.MinTxRate,
+			MaxTxRate:    config.MaxTxRate,
+		},
+	}
+	if config.HardwareAddress != "" {
+		device.config.HardwareAddr, err = net.ParseMAC(config.HardwareAddress)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	device.linkAttrs.Name = config.InterfaceName
+	if device.linkAttrs.Name == "" {
+		// subrequests are named <request>/<subrequest>
+		device.linkAttrs.Name = request[strings.LastIndex(request, "/")+1:]
+	}
+	if len(device.linkAttrs.Name) > unix.IFNAMSIZ-1 {
+		device.linkAttrs.Name = device.linkAttrs.Name[:unix.IFNAMSIZ-1]
+	}
+	device.linkAttrs.MTU = config.MTU
+
+	device.podInterface, err = driver.NewPodInterface(config.PodInterfaceConfig)
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// UnprepareDevice releases the addresses of the claim, the VFs are returned to
+// the host when the pod sandbox stops.
+func (d *sriovDriver) UnprepareDevice(ctx context.Context, claim kubeletplugin.NamespacedObject) error {
+	klog.Infof("Unpreparing resources for claim %s", claim.Name)
+	return d.ipam.Release(claim.UID)
+}
+
+// ConfigureDeviceForPod configures the VF through its PF and moves the VF
+// network interface into the pod's namespace, it is moved back to the host and
+// reset if it can not be configured.
+func (d *sriovDriver) ConfigureDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) (err error) {
+	vf, err := getPreparedDevice(device, preparedData)
+	if err != nil {
+		return err
+	}
+
+	if err := kndnet.ConfigureVF(vf.vf.PFName, vf.vf.Index, vf.config); err != nil {
+		return err
+	}
+
+	klog.Infof("Moving VF %d of %s (%s) to pod %s/%s network namespace %s as %q",
+		vf.vf.Index, vf.vf.PFName, vf.vf.Name, podSandbox.Namespace, podSandbox.Name, networkNamespace, vf.linkAttrs.Name)
+	_, err = kndnet.NsAttachNetdev(vf.vf.Name, networkNamespace, vf.linkAttrs, vf.ipam.IPNets())
+	if err != nil {
+		if resetErr := kndnet.ResetVF(vf.vf.PFName, vf.vf.Index); resetErr != nil {
+			klog.Errorf("failed to reset VF %d of %s: %v", vf.vf.Index, vf.vf.PFName, resetErr)
+		}
+		return err
+	}
+	// the VF is not left in the namespace of a pod that fails to start, with the
+	// settings of the claim
+	defer func() {
+		if err == nil {
+			return
+		}
+		if cleanupErr := d.CleanupDeviceForPod(device, networkNamespace, podSandbox, preparedData); cleanupErr != nil {
+			klog.Errorf("failed to move VF %s back to host namespace: %v", vf.linkAttrs.Name, cleanupErr)
+		}
+	}()
+	if err := vf.podInterface.Configure(networkNamespace, vf.linkAttrs.Name, vf.ipam, d.interfaceDefaults.Load().Announce); err != nil {
+		return err
+	}
+	if vf.dhcp != nil {
+		client, err := kndnet.NsStartDHCP(networkNamespace, vf.linkAttrs.Name, *vf.dhcp)
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		d.dhcpClients[podSandbox.Uid+"/"+vf.linkAttrs.Name] = client
+		d.mu.Unlock()
+	}
+	return nil
+}
+
+// CleanupDeviceForPod moves the VF network interface back to the host namespace
+// and resets the VF configuration.
+func (d *sriovDriver) CleanupDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
+	vf, err := getPreparedDevice(device, preparedData)
+	if err != nil {
+		return err
+	}
+
+	// release the lease before the VF leaves the pod namespace
+	d.mu.Lock()
+	client, ok := d.dhcpClients[podSandbox.Uid+"/"+vf.linkAttrs.Name]
+	delete(d.dhcpClients, podSandbox.Uid+"/"+vf.linkAttrs.Name)
+	d.mu.Unlock()
+	if ok {
+		if err := client.Stop(); err != nil {
+			klog.Errorf("failed to stop DHCP client of VF %s: %v", vf.linkAttrs.Name, err)
+		}
+	}
+
+	vf.podInterface.Cleanup(networkNamespace, vf.linkAttrs.Name)
+
+	klog.Infof("Moving VF %d of %s from pod %s/%s back to host namespace as %q",
+		vf.vf.Index, vf.vf.PFName, podSandbox.Namespace, podSandbox.Name, vf.vf.Name)
+	// the VF is reset through the PF even if its interface can not be moved back
+	detachErr := kndnet.NsDetachNetdev(networkNamespace, vf.linkAttrs.Name, vf.vf.Name)
+	return errors.Join(detachErr, kndnet.ResetVF(vf.vf.PFName, vf.vf.Index))
+}
+
+func getPreparedDevice(device driver.AllocatedDevice, preparedData interface{}) (*preparedDevice, error) {
+	prepared, ok := preparedData.(preparedClaim)
+	if !ok {
+		return nil, fmt.Errorf("invalid prepared data type: expected preparedClaim, got %T", preparedData)
+	}
+	vf, ok := prepared[deviceKey(device.Request, device.Name)]
+	if !ok {
+		return nil, fmt.Errorf("device %s for request %s was not prepared", device.Name, device.Request)
+	}
+	return vf, nil
+}
+
+// HandleError logs background errors from the driver framework.
+func (d *sriovDriver) HandleError(ctx context.Context, err error, msg string) {
+	klog.Errorf("Background error in driver framework: %s: %v", msg, err)
+}
+
+// parsePhysicalFunctions parses the physical functions and the optional number of
+// VFs to enable on them in the format <interface>[:<num vfs>][,<interface>[:<num vfs>]...].
+func parsePhysicalFunctions(s string) (map[string]int, []string, error) {
+	numVFs := map[string]int{}
+	var pfs []string
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, num, found := strings.Cut(entry, ":")
+		if name == "" {
+			return nil, nil, fmt.Errorf("invalid physical function %q", entry)
+		}
+		pfs = append(pfs, name)
+		if !found {
+			continue
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("invalid number of VFs in %q", entry)
+		}
+		numVFs[name] = n
+	}
+	return numVFs, pfs, nil
+}
+
+//================================================================
+// Main Entrypoint
+//================================================================
+
+const (
+	driverName = "sriov.k8s.io"
+)
+
+var (
+	hostnameOverride  string
+	kubeconfig        string
+	bindAddress       string
+	physicalFunctions string
+	configFile        string
+	ipamStateFile     string
+	ready             atomic.Bool
+)
+
+func init() {
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "Absolute path to the kubeconfig file.")
+	flag.StringVar(&bindAddress, "bind-address", ":9181", "The IP address and port for the metrics and healthz server.")
+	flag.StringVar(&hostnameOverride, "hostname-override", "", "Node name to use. Defaults to the node's hostname.")
+	flag.StringVar(&physicalFunctions, "physical-functions", "", "Comma separated list of physical functions whose VFs are published, optionally with the number of VFs to enable, for example \"eth1:8,eth2\", the number can not be changed while the VFs are in use. Defaults to all the SR-IOV capable interfaces.")
+	flag.StringVar(&configFile, "config", "", "Path to the YAML or JSON node configuration file, it is reloaded when it changes.")
+	flag.StringVar(&ipamStateFile, "ipam-state-file", "/var/lib/knd/"+driverName+"/ipam.json", "Path to the file that persists the addresses allocated to the claims.")
+	klog.InitFlags(nil)
+}
+
+func main() {
+	flag.Parse()
+	printVersion()
+
+	numVFs, pfs, err := parsePhysicalFunctions(physicalFunctions)
+	if err != nil {
+		klog.Fatalf("Invalid --physical-functions flag: %v", err)
+	}
+	for pf, n := range numVFs {
+		if err := kndnet.SetNumVFs(pf, n); err != nil {
+			klog.Fatalf("Failed to provision VFs: %v", err)
+		}
+		klog.Infof("%d VFs enabled on %s", n, pf)
+	}
+
+	// Create a context that is cancelled on interruption signals.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, unix.SIGTERM)
+	defer cancel()
+
+	// Set up healthz and metrics endpoints.
+	setupHTTPServer()
+
+	// Set up Kubernetes client.
+	clientset, err := newClientset()
+	if err != nil {
+		klog.Fatalf("Failed to create Kubernetes client: %v", err)
+	}
+
+	nodeName, err := nodeutil.GetHostname(hostnameOverride)
+	if err != nil {
+		klog.Fatalf("Cannot get node name: %v", err)
+	}
+
+	nodeConfig, err := kndconfig.Load(configFile)
+	if err != nil {
+		klog.Fatalf("Invalid node configuration: %v", err)
+	}
+
+	allocator, err := ipam.NewAllocator(ipamStateFile)
+	if err != nil {
+		klog.Fatalf("Failed to load IPAM state: %v", err)
+	}
+
+	// 1. Create an instance of the SR-IOV driver.
+	sriovDriver := NewDriver(pfs, allocator)
+	sriovDriver.SetInterfaceDefaults(nodeConfig.Interface)
+
+	// 2. Create the plugin framework, passing in the driver.
+	plugin := driver.NewPlugin(sriovDriver, driverName, nodeName, clientset, driver.WithNodeConfig(nodeConfig))
+
+	// 3. Start the plugin framework.
+	if err := plugin.Start(ctx); err != nil {
+		klog.Fatalf("Driver failed to start: %v", err)
+	}
+	defer plugin.Stop()
+
+	if configFile != "" {
+		err := kndconfig.Watch(ctx, configFile, nodeConfig, func(c *kndconfig.NodeConfig) {
+			sriovDriver.SetInterfaceDefaults(c.Interface)
+			plugin.UpdateNodeConfig(c)
+		})
+		if err != nil {
+			klog.Fatalf("Failed to watch node configuration: %v", err)
+		}
+	}
+
+	ready.Store(true)
+	klog.Infof("Driver started successfully on node %s", nodeName)
+
+	// Wait for the context to be cancelled.
+	<-ctx.Done()
+	klog.Info("Driver shutting down.")
+}
+
+func setupHTTPServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if ready.Load() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: bindAddress, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			klog.Fatalf("Failed to listen and serve: %v", err)
+		}
+	}()
+}
+
+func newClientset() (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create client-go config: %w", err)
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+func printVersion() {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		klog.Infof("Version: %s, Go version: %s", info.Main.Version, info.GoVersion)
+	}
+}
@@ -6,15 +6,17 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 
 	"golang.org/x/sys/unix"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
-	"k8s.io/utils/ptr"
 
 	"github.com/aojea/kubernetes-network-drivers/pkg/controller"
 	resourcev1 "k8s.io/api/resource/v1"
@@ -32,6 +34,8 @@ const (
 // sampleReconciler implements the controller.Reconciler interface.
 type sampleReconciler struct {
 	kubeClient kubernetes.Interface
+	// nodeLabels are the labels of the nodes the virtual GPUs are reachable from.
+	nodeLabels map[string]string
 }
 
 // IsDeviceClassRelevant checks if this controller should handle the claim.
@@ -39,8 +43,8 @@ func (c *sampleReconciler) IsDeviceClassRelevant(deviceClass *resourcev1.DeviceC
 	return deviceClass.Name == driverName
 }
 
-// Reconcile creates a ResourceSlice for a virtual GPU.
-func (c *sampleReconciler) Reconcile(ctx context.Context, claim *resourcev1.ResourceClaim) (*resourcev1.ResourceSlice, error) {
+// Reconcile returns the ResourceSlice of a virtual GPU, in a pool of the claim.
+func (c *sampleReconciler) Reconcile(ctx context.Context, claim *resourcev1.ResourceClaim) ([]*resourcev1.ResourceSlice, error) {
 	sliceName := c.getResourceSliceName(claim)
 
 	virtualDevice := resourcev1.Device{
@@ -53,21 +57,18 @@ func (c *sampleReconciler) Reconcile(ctx context.Context, claim *resourcev1.Reso
 		},
 	}
 
-	return &resourcev1.ResourceSlice{
+	return []*resourcev1.ResourceSlice{{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: sliceName,
 		},
 		Spec: resourcev1.ResourceSliceSpec{
-			NodeName: ptr.To("node0"),
-			Driver:   driverName,
+			Driver: driverName,
 			Pool: resourcev1.ResourcePool{
-				Name:               "virtual-gpus",
-				Generation:         1,
-				ResourceSliceCount: 1,
+				Name: sliceName,
 			},
 			Devices: []resourcev1.Device{virtualDevice},
 		},
-	}, nil
+	}}, nil
 }
 
 // Delete removes the ResourceSlice associated with a ResourceClaim.
@@ -82,6 +83,21 @@ func (c *sampleReconciler) Delete(ctx context.Context, claim *resourcev1.Resourc
 	return nil
 }
 
+// NodeLabels makes the virtual GPUs reachable from the nodes with the labels, the
+// controller publishes them for all the nodes if there are no labels.
+func (c *sampleReconciler) NodeLabels(slice *resourcev1.ResourceSlice) (map[string]string, bool) {
+	return c.nodeLabels, true
+}
+
+// ClaimOfSlice identifies the ResourceSlices created without the claim label.
+func (c *sampleReconciler) ClaimOfSlice(slice *resourcev1.ResourceSlice) (types.UID, bool) {
+	uid, ok := strings.CutPrefix(slice.Name, controllerName+"-")
+	if !ok || slice.Spec.Driver != driverName {
+		return "", false
+	}
+	return types.UID(uid), true
+}
+
 // getResourceSliceName generates a deterministic name for the ResourceSlice.
 func (c *sampleReconciler) getResourceSliceName(claim *resourcev1.ResourceClaim) string {
 	return fmt.Sprintf("%s-%s", controllerName, claim.UID)
@@ -91,7 +107,16 @@ func (c *sampleReconciler) getResourceSliceName(claim *resourcev1.ResourceClaim)
 // Main Entrypoint
 //================================================================
 
+var (
+	bindAddress    string
+	nodeLabels     string
+	leaderElection *controller.LeaderElectionFlags
+)
+
 func main() {
+	leaderElection = controller.AddLeaderElectionFlags(flag.CommandLine, controllerName)
+	flag.StringVar(&bindAddress, "bind-address", ":9182", "The IP address and port for the metrics, healthz and readyz server.")
+	flag.StringVar(&nodeLabels, "node-labels", "", "Comma separated list of key=value labels of the nodes the devices are reachable from, all the nodes if empty.")
 	klog.InitFlags(nil)
 	flag.Parse()
 
@@ -108,11 +133,21 @@ func main() {
 	}
 
 	// 1. Create an instance of your reconciler implementation
+	selector, err := labels.ConvertSelectorToLabelsMap(nodeLabels)
+	if err != nil {
+		klog.Fatalf("Invalid node labels %q: %v", nodeLabels, err)
+	}
 	myReconciler := &sampleReconciler{
 		kubeClient: clientset,
+		nodeLabels: selector,
 	}
 
-	// 2. Create and run the controller
-	ctrl := controller.NewController(clientset, myReconciler, controllerName)
+	// 2. Create and run the controller, with leader election when several replicas run
+	var opts []controller.Option
+	if config, ok := leaderElection.LeaderElection(); ok {
+		opts = append(opts, controller.WithLeaderElection(config))
+	}
+	ctrl := controller.NewController(clientset, myReconciler, controllerName, opts...)
+	controller.StartHTTPServer(bindAddress, ctrl.Readyz)
 	ctrl.Run(ctx)
 }
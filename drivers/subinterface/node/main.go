@@ -4,98 +4,374 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime/debug"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/containerd/nri/pkg/api"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 	resourcev1 "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	nodeutil "k8s.io/component-helpers/node/util"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
 
+	kndconfig "github.com/aojea/kubernetes-network-drivers/pkg/config"
+	"github.com/aojea/kubernetes-network-drivers/pkg/discovery"
 	"github.com/aojea/kubernetes-network-drivers/pkg/driver"
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+	kndnet "github.com/aojea/kubernetes-network-drivers/pkg/net"
 )
 
 //================================================================
-// Agent (Driver) Implementation
+// Driver Implementation
 //================================================================
 
 const (
-	// The resourceFile simulates a unique hardware or software feature on the node.
-	// If this file exists, the agent advertises the resource.
-	resourceFile = "/tmp/special-node-resource"
+	modeMacvlan = "macvlan"
+	modeIPVlan  = "ipvlan"
+
+	// childrenCapacity is the capacity consumed by every subinterface created on a parent.
+	childrenCapacity = "children"
+	// bandwidthCapacity is the link speed of a parent in bits per second, shared by its
+	// subinterfaces.
+	bandwidthCapacity = "bandwidth"
 )
 
-// nodeAgentDriver implements the driver.Driver interface.
-type nodeAgentDriver struct{}
+// subinterfaceConfig is the opaque configuration accepted in the claim or in the DeviceClass.
+type subinterfaceConfig struct {
+	// Mode is the type of subinterface to create: macvlan (default) or ipvlan.
+	Mode string `json:"mode,omitempty"`
+	// MacvlanMode is the macvlan mode: bridge (default), private, vepa, passthru or source.
+	MacvlanMode string `json:"macvlanMode,omitempty"`
+	// IPVlanMode is the ipvlan mode: l2 (default), l3 or l3s.
+	IPVlanMode string `json:"ipvlanMode,omitempty"`
+	// IPVlanFlag is the ipvlan flag: bridge (default), private or vepa.
+	IPVlanFlag string `json:"ipvlanFlag,omitempty"`
+	// InterfaceName is the name of the interface inside the pod, it defaults to the request name.
+	InterfaceName string `json:"interfaceName,omitempty"`
+	// MTU of the interface inside the pod, it defaults to the interface MTU of the node
+	// configuration or to the parent MTU.
+	MTU int `json:"mtu,omitempty"`
+	// HardwareAddress of the macvlan interface, it is random by default.
+	HardwareAddress string `json:"hardwareAddress,omitempty"`
+	// IPAM configures the addresses and routes of the interface inside the pod.
+	IPAM ipam.Config `json:"ipam,omitempty"`
+	// PodInterfaceConfig configures the VRF and the rate limits of the interface
+	// inside the pod, both rates default to the bandwidth capacity consumed from
+	// the parent.
+	driver.PodInterfaceConfig `json:",inline"`
+}
+
+// preparedDevice is the validated configuration of a subinterface.
+type preparedDevice struct {
+	parent    string
+	mode      string
+	linkAttrs netlink.LinkAttrs
+
+	macvlanMode netlink.MacvlanMode
+	ipvlanMode  netlink.IPVlanMode
+	ipvlanFlag  netlink.IPVlanFlag
 
-// NewDriver creates a new instance of the node agent driver.
-func NewDriver() driver.Driver {
-	return &nodeAgentDriver{}
+	// ipam is the addressing of the interface, nil if it is not configured.
+	ipam *ipam.Result
+	// podInterface is the configuration of the interface inside the pod.
+	podInterface driver.PodInterface
 }
 
-// GetDevices checks for the presence of the special resource file and advertises it as a device.
-func (d *nodeAgentDriver) GetDevices() ([]resourcev1.Device, error) {
-	if _, err := os.Stat(resourceFile); os.IsNotExist(err) {
-		klog.V(2).Infof("Special resource file not found at %s. No devices to advertise.", resourceFile)
-		return nil, nil
+// preparedClaim maps the allocated devices of a claim to their configuration.
+type preparedClaim map[string]*preparedDevice
+
+func deviceKey(request, device, shareID string) string {
+	return request + "/" + device + "/" + shareID
+}
+
+// subinterfaceDriver implements the driver.Driver interface.
+type subinterfaceDriver struct {
+	parents     sets.Set[string]
+	maxChildren int64
+	// ipam allocates the addresses of the pod interfaces.
+	ipam *ipam.Allocator
+	// interfaceDefaults are the default settings of the pod interfaces, they are
+	// replaced on configuration reloads.
+	interfaceDefaults atomic.Pointer[kndconfig.InterfaceConfig]
+}
+
+// NewDriver creates a new instance of the subinterface driver. If parents is empty
+// all the physical interfaces on the host are eligible to be parents.
+func NewDriver(parents []string, maxChildren int64, allocator *ipam.Allocator) *subinterfaceDriver {
+	d := &subinterfaceDriver{
+		parents:     sets.New(parents...),
+		maxChildren: maxChildren,
+		ipam:        allocator,
 	}
+	d.interfaceDefaults.Store(&kndconfig.InterfaceConfig{})
+	return d
+}
 
-	klog.V(1).Infof("Discovered special resource at %s", resourceFile)
-	device := resourcev1.Device{
-		Name: "special-resource-0",
-		Attributes: map[resourcev1.QualifiedName]resourcev1.DeviceAttribute{
-			"feature": {StringValue: func() *string { s := "enabled"; return &s }()},
-		},
+// SetInterfaceDefaults replaces the default settings of the pod interfaces, they
+// are used by the claims prepared afterwards.
+func (d *subinterfaceDriver) SetInterfaceDefaults(defaults kndconfig.InterfaceConfig) {
+	d.interfaceDefaults.Store(&defaults)
+}
+
+// GetDevices advertises each eligible parent interface as a device that can be
+// allocated multiple times, up to the configured number of children.
+func (d *subinterfaceDriver) GetDevices() ([]resourcev1.Device, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+
+	var devices []resourcev1.Device
+	for _, link := range links {
+		if !d.isEligibleParent(link) {
+			continue
+		}
+		attrs := link.Attrs()
+		device := resourcev1.Device{
+			Name: attrs.Name,
+			Attributes: map[resourcev1.QualifiedName]resourcev1.DeviceAttribute{
+				"interface-name": {StringValue: ptr.To(attrs.Name)},
+				"mac-address":    {StringValue: ptr.To(attrs.HardwareAddr.String())},
+				"mtu":            {IntValue: ptr.To(int64(attrs.MTU))},
+			},
+			AllowMultipleAllocations: ptr.To(true),
+			Capacity: map[resourcev1.QualifiedName]resourcev1.DeviceCapacity{
+				childrenCapacity: {
+					Value: *resource.NewQuantity(d.maxChildren, resource.DecimalSI),
+					RequestPolicy: &resourcev1.CapacityRequestPolicy{
+						Default:     resource.NewQuantity(1, resource.DecimalSI),
+						ValidValues: []resource.Quantity{*resource.NewQuantity(1, resource.DecimalSI)},
+					},
+				},
+			},
+		}
+		// the bandwidth can only be accounted if the parent reports its speed
+		if speed := discovery.LinkSpeed(attrs.Name); speed > 0 {
+			device.Capacity[bandwidthCapacity] = resourcev1.DeviceCapacity{
+				Value: *resource.NewQuantity(speed*1000*1000, resource.DecimalSI),
+				RequestPolicy: &resourcev1.CapacityRequestPolicy{
+					Default: resource.NewQuantity(0, resource.DecimalSI),
+					ValidRange: &resourcev1.CapacityRequestPolicyRange{
+						Min:  resource.NewQuantity(0, resource.DecimalSI),
+						Step: resource.NewQuantity(1000*1000, resource.DecimalSI),
+					},
+				},
+			}
+		}
+		devices = append(devices, device)
+		klog.V(2).Infof("Discovered parent device: %s", attrs.Name)
 	}
-	return []resourcev1.Device{device}, nil
+	return devices, nil
 }
 
-// PrepareDevice simulates claiming the resource by writing the pod's UID to the file.
-func (d *nodeAgentDriver) PrepareDevice(ctx context.Context, claim *resourcev1.ResourceClaim) (interface{}, error) {
-	podUID := string(claim.UID)
-	klog.Infof("Preparing special resource for claim %s (Pod UID: %s)", claim.Name, podUID)
+func (d *subinterfaceDriver) isEligibleParent(link netlink.Link) bool {
+	attrs := link.Attrs()
+	if d.parents.Len() > 0 {
+		return d.parents.Has(attrs.Name)
+	}
+	// Only physical interfaces that are up can be used as parents by default.
+	if attrs.Flags&net.FlagLoopback != 0 || attrs.Flags&net.FlagUp == 0 {
+		return false
+	}
+	return link.Type() == "device"
+}
 
-	// "Claim" the resource by writing the UID of the pod to the file.
-	err := os.WriteFile(resourceFile, []byte(podUID), 0644)
+// PrepareDevice validates the configuration of every subinterface allocated to the claim.
+func (d *subinterfaceDriver) PrepareDevice(ctx context.Context, claim *resourcev1.ResourceClaim) (interface{}, error) {
+	if claim.Status.Allocation == nil || len(claim.Status.Allocation.Devices.Results) == 0 {
+		return nil, fmt.Errorf("claim %s has no allocated devices", claim.Name)
+	}
+
+	prepared := preparedClaim{}
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if result.Driver != driverName {
+			continue
+		}
+		config := subinterfaceConfig{MTU: d.interfaceDefaults.Load().MTU}
+		if err := driver.DecodeDeviceConfig(claim, driverName, result.Request, &config); err != nil {
+			return nil, err
+		}
+		device, err := newPreparedDevice(result.Device, result.Request, config, result.ConsumedCapacity[bandwidthCapacity])
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration for request %s of claim %s/%s: %w", result.Request, claim.Namespace, claim.Name, err)
+		}
+		shareID := ""
+		if result.ShareID != nil {
+			shareID = string(*result.ShareID)
+		}
+		key := deviceKey(result.Request, result.Device, shareID)
+		if config.IPAM.Pool != "" {
+			device.ipam, err = ipam.PoolResult(claim, result, config.IPAM)
+			if err != nil {
+				return nil, err
+			}
+		} else if !config.IPAM.Empty() {
+			device.ipam, err = d.ipam.Allocate(claim.UID, key, config.IPAM)
+			if err != nil {
+				return nil, fmt.Errorf("failed to allocate addresses for request %s of claim %s/%s: %w", result.Request, claim.Namespace, claim.Name, err)
+			}
+		}
+		prepared[key] = device
+		klog.Infof("Preparing %s subinterface %q on parent %q for claim %s/%s", device.mode, device.linkAttrs.Name, device.parent, claim.Namespace, claim.Name)
+	}
+	return prepared, nil
+}
+
+func newPreparedDevice(parent, request string, config subinterfaceConfig, consumedBandwidth resource.Quantity) (*preparedDevice, error) {
+	device := &preparedDevice{
+		parent: parent,
+		mode:   config.Mode,
+	}
+
+	device.linkAttrs.Name = config.InterfaceName
+	if device.linkAttrs.Name == "" {
+		// subrequests are named <request>/<subrequest>
+		device.linkAttrs.Name = request[strings.LastIndex(request, "/")+1:]
+	}
+	if len(device.linkAttrs.Name) > unix.IFNAMSIZ-1 {
+		device.linkAttrs.Name = device.linkAttrs.Name[:unix.IFNAMSIZ-1]
+	}
+	device.linkAttrs.MTU = config.MTU
+
+	var err error
+	switch config.Mode {
+	case "", modeMacvlan:
+		device.mode = modeMacvlan
+		device.macvlanMode, err = kndnet.ParseMacvlanMode(config.MacvlanMode)
+		if err != nil {
+			return nil, err
+		}
+		if config.HardwareAddress != "" {
+			device.linkAttrs.HardwareAddr, err = net.ParseMAC(config.HardwareAddress)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case modeIPVlan:
+		device.ipvlanMode, device.ipvlanFlag, err = kndnet.ParseIPVlanMode(config.IPVlanMode, config.IPVlanFlag)
+		if err != nil {
+			return nil, err
+		}
+		if config.HardwareAddress != "" {
+			return nil, fmt.Errorf("ipvlan interfaces use the parent hardware address")
+		}
+	default:
+		return nil, fmt.Errorf("unknown mode %q", config.Mode)
+	}
+
+	config.Bandwidth, err = bandwidthLimits(config.Bandwidth, consumedBandwidth)
 	if err != nil {
-		return nil, fmt.Errorf("failed to write pod UID to resource file: %w", err)
+		return nil, err
 	}
+	device.podInterface, err = driver.NewPodInterface(config.PodInterfaceConfig)
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
 
-	return podUID, nil
+// bandwidthLimits returns the rate limits of a subinterface. The rates that are not
+// configured default to the bandwidth consumed from the parent, and the configured
+// ones can not exceed it, or the scheduler accounting would be wrong.
+func bandwidthLimits(config *kndnet.Bandwidth, consumed resource.Quantity) (*kndnet.Bandwidth, error) {
+	bandwidth := &kndnet.Bandwidth{}
+	if config != nil {
+		*bandwidth = *config
+	}
+	if consumed.Sign() > 0 {
+		if bandwidth.EgressRate == nil {
+			bandwidth.EgressRate = ptr.To(consumed.DeepCopy())
+		}
+		if bandwidth.IngressRate == nil {
+			bandwidth.IngressRate = ptr.To(consumed.DeepCopy())
+		}
+		if bandwidth.EgressRate.Cmp(consumed) > 0 || bandwidth.IngressRate.Cmp(consumed) > 0 {
+			return nil, fmt.Errorf("the rates can not exceed the consumed %s capacity %s", bandwidthCapacity, consumed.String())
+		}
+	}
+	if bandwidth.Empty() {
+		return nil, nil
+	}
+	return bandwidth, nil
 }
 
-// UnprepareDevice simulates releasing the resource.
-func (d *nodeAgentDriver) UnprepareDevice(ctx context.Context, claim kubeletplugin.NamespacedObject) error {
-	klog.Infof("Unpreparing special resource for claim %s", claim.Name)
-	// Clear the file to indicate the resource is free.
-	return os.WriteFile(resourceFile, []byte(""), 0644)
+// UnprepareDevice releases the addresses of the claim, the subinterfaces are
+// deleted when the pod sandbox stops.
+func (d *subinterfaceDriver) UnprepareDevice(ctx context.Context, claim kubeletplugin.NamespacedObject) error {
+	klog.Infof("Unpreparing resources for claim %s", claim.Name)
+	return d.ipam.Release(claim.UID)
 }
 
-// ConfigureDeviceForPod is a no-op for this agent, as the pod would typically mount the
-// resource file directly to see that it has been allocated the resource.
-func (d *nodeAgentDriver) ConfigureDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
-	klog.Infof("No-op configuration for pod %s/%s. Pod can access resource via mounted file.", podSandbox.Namespace, podSandbox.Name)
+// ConfigureDeviceForPod creates the subinterface inside the pod's network namespace,
+// it is deleted if it can not be configured so the pod sandbox can be created again.
+func (d *subinterfaceDriver) ConfigureDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
+	subinterface, err := getPreparedDevice(device, preparedData)
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("Creating %s interface %q on parent %q in pod %s/%s network namespace %s",
+		subinterface.mode, subinterface.linkAttrs.Name, subinterface.parent, podSandbox.Namespace, podSandbox.Name, networkNamespace)
+
+	switch subinterface.mode {
+	case modeIPVlan:
+		_, err = kndnet.NsAddIPVlan(subinterface.parent, networkNamespace, subinterface.linkAttrs, subinterface.ipvlanMode, subinterface.ipvlanFlag, subinterface.ipam.IPNets())
+	default:
+		_, err = kndnet.NsAddMacvlan(subinterface.parent, networkNamespace, subinterface.linkAttrs, subinterface.macvlanMode, subinterface.ipam.IPNets())
+	}
+	if err != nil {
+		return err
+	}
+	if err := subinterface.podInterface.Configure(networkNamespace, subinterface.linkAttrs.Name, subinterface.ipam, d.interfaceDefaults.Load().Announce); err != nil {
+		if cleanupErr := d.CleanupDeviceForPod(device, networkNamespace, podSandbox, preparedData); cleanupErr != nil {
+			klog.Errorf("failed to delete %s interface %s: %v", subinterface.mode, subinterface.linkAttrs.Name, cleanupErr)
+		}
+		return err
+	}
 	return nil
 }
 
-// CleanupDeviceForPod is a no-op. The resource is released in UnprepareDevice.
-func (d *nodeAgentDriver) CleanupDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
-	klog.Infof("No-op cleanup for pod %s/%s.", podSandbox.Namespace, podSandbox.Name)
-	return nil
+// CleanupDeviceForPod deletes the subinterface from the pod's network namespace.
+func (d *subinterfaceDriver) CleanupDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
+	subinterface, err := getPreparedDevice(device, preparedData)
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("Deleting %s interface %q from pod %s/%s",
+		subinterface.mode, subinterface.linkAttrs.Name, podSandbox.Namespace, podSandbox.Name)
+	subinterface.podInterface.Cleanup(networkNamespace, subinterface.linkAttrs.Name)
+	return kndnet.NsDelLink(networkNamespace, subinterface.linkAttrs.Name)
+}
+
+func getPreparedDevice(device driver.AllocatedDevice, preparedData interface{}) (*preparedDevice, error) {
+	prepared, ok := preparedData.(preparedClaim)
+	if !ok {
+		return nil, fmt.Errorf("invalid prepared data type: expected preparedClaim, got %T", preparedData)
+	}
+	subinterface, ok := prepared[deviceKey(device.Request, device.Name, device.ShareID)]
+	if !ok {
+		return nil, fmt.Errorf("device %s for request %s was not prepared", device.Name, device.Request)
+	}
+	return subinterface, nil
 }
 
 // HandleError logs background errors from the driver framework.
-func (d *nodeAgentDriver) HandleError(ctx context.Context, err error, msg string) {
+func (d *subinterfaceDriver) HandleError(ctx context.Context, err error, msg string) {
 	klog.Errorf("Background error in driver framework: %s: %v", msg, err)
 }
 
@@ -104,13 +380,17 @@ func (d *nodeAgentDriver) HandleError(ctx context.Context, err error, msg string
 //================================================================
 
 const (
-	driverName = "node-agent.k8s.io"
+	driverName = "subinterface.k8s.io"
 )
 
 var (
 	hostnameOverride string
 	kubeconfig       string
 	bindAddress      string
+	parentInterfaces string
+	maxChildren      int64
+	configFile       string
+	ipamStateFile    string
 	ready            atomic.Bool
 )
 
@@ -118,6 +398,10 @@ func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Absolute path to the kubeconfig file.")
 	flag.StringVar(&bindAddress, "bind-address", ":9178", "The IP address and port for the metrics and healthz server.")
 	flag.StringVar(&hostnameOverride, "hostname-override", "", "Node name to use. Defaults to the node's hostname.")
+	flag.StringVar(&parentInterfaces, "parent-interfaces", "", "Comma separated list of interfaces that can be used as parents. Defaults to all the physical interfaces that are up.")
+	flag.Int64Var(&maxChildren, "max-children", 32, "Maximum number of subinterfaces that can be created on each parent interface.")
+	flag.StringVar(&configFile, "config", "", "Path to the YAML or JSON node configuration file, it is reloaded when it changes.")
+	flag.StringVar(&ipamStateFile, "ipam-state-file", "/var/lib/knd/"+driverName+"/ipam.json", "Path to the file that persists the addresses allocated to the claims.")
 	klog.InitFlags(nil)
 }
 
@@ -143,24 +427,50 @@ func main() {
 		klog.Fatalf("Cannot get node name: %v", err)
 	}
 
-	// 1. Create an instance of the node agent driver.
-	nodeAgent := NewDriver()
+	var parents []string
+	if parentInterfaces != "" {
+		parents = strings.Split(parentInterfaces, ",")
+	}
+
+	nodeConfig, err := kndconfig.Load(configFile)
+	if err != nil {
+		klog.Fatalf("Invalid node configuration: %v", err)
+	}
+
+	allocator, err := ipam.NewAllocator(ipamStateFile)
+	if err != nil {
+		klog.Fatalf("Failed to load IPAM state: %v", err)
+	}
+
+	// 1. Create an instance of the subinterface driver.
+	subinterfaceDriver := NewDriver(parents, maxChildren, allocator)
+	subinterfaceDriver.SetInterfaceDefaults(nodeConfig.Interface)
 
-	// 2. Create the plugin framework, passing in the agent.
-	plugin := driver.NewPlugin(nodeAgent, driverName, nodeName, clientset)
+	// 2. Create the plugin framework, passing in the driver.
+	plugin := driver.NewPlugin(subinterfaceDriver, driverName, nodeName, clientset, driver.WithNodeConfig(nodeConfig))
 
 	// 3. Start the plugin framework.
 	if err := plugin.Start(ctx); err != nil {
-		klog.Fatalf("Node agent failed to start: %v", err)
+		klog.Fatalf("Driver failed to start: %v", err)
 	}
 	defer plugin.Stop()
 
+	if configFile != "" {
+		err := kndconfig.Watch(ctx, configFile, nodeConfig, func(c *kndconfig.NodeConfig) {
+			subinterfaceDriver.SetInterfaceDefaults(c.Interface)
+			plugin.UpdateNodeConfig(c)
+		})
+		if err != nil {
+			klog.Fatalf("Failed to watch node configuration: %v", err)
+		}
+	}
+
 	ready.Store(true)
-	klog.Infof("Node agent started successfully on node %s", nodeName)
+	klog.Infof("Driver started successfully on node %s", nodeName)
 
 	// Wait for the context to be cancelled.
 	<-ctx.Done()
-	klog.Info("Node agent shutting down.")
+	klog.Info("Driver shutting down.")
 }
 
 func setupHTTPServer() {
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	resourcev1 "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	nodeutil "k8s.io/component-helpers/node/util"
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	kndconfig "github.com/aojea/kubernetes-network-drivers/pkg/config"
+	"github.com/aojea/kubernetes-network-drivers/pkg/driver"
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+	kndnet "github.com/aojea/kubernetes-network-drivers/pkg/net"
+	"github.com/aojea/kubernetes-network-drivers/pkg/vlanid"
+)
+
+//================================================================
+// Driver Implementation
+//================================================================
+
+const (
+	// vlansCapacity is the capacity consumed by every VLAN interface created on a parent.
+	vlansCapacity = "vlans"
+)
+
+// vlanConfig is the opaque configuration accepted in the claim or in the DeviceClass.
+type vlanConfig struct {
+	// VlanID is the VLAN identifier of the interface, it defaults to the VLAN ID of
+	// a fabric allocated to the claim.
+	VlanID int `json:"vlanId,omitempty"`
+	// VlanIDRequest is the request of the claim of the allocated VLAN ID, it is only
+	// needed when several VLAN IDs are allocated to the claim.
+	VlanIDRequest string `json:"vlanIdRequest,omitempty"`
+	// Protocol is the tag protocol of the VLAN: 802.1Q (default) or 802.1ad.
+	Protocol string `json:"protocol,omitempty"`
+	// OuterVlanID is the service VLAN identifier for QinQ interfaces.
+	OuterVlanID int `json:"outerVlanId,omitempty"`
+	// InterfaceName is the name of the interface inside the pod, it defaults to the request name.
+	InterfaceName string `json:"interfaceName,omitempty"`
+	// MTU of the interface inside the pod, it defaults to the interface MTU of the node
+	// configuration or to the parent MTU.
+	MTU int `json:"mtu,omitempty"`
+	// HardwareAddress of the interface, it defaults to the parent hardware address.
+	HardwareAddress string `json:"hardwareAddress,omitempty"`
+	// IPAM configures the addresses and routes of the interface inside the pod.
+	IPAM ipam.Config `json:"ipam,omitempty"`
+	// PodInterfaceConfig configures the VRF and the rate limits of the interface
+	// inside the pod.
+	driver.PodInterfaceConfig `json:",inline"`
+}
+
+// preparedDevice is the validated configuration of a VLAN interface.
+type preparedDevice struct {
+	parent    string
+	linkAttrs netlink.LinkAttrs
+	vlan      kndnet.VlanConfig
+	// ipam is the addressing of the interface, nil if it is not configured.
+	ipam *ipam.Result
+	// podInterface is the configuration of the interface inside the pod.
+	podInterface driver.PodInterface
+}
+
+// preparedClaim maps the allocated devices of a claim to their configuration.
+type preparedClaim map[string]*preparedDevice
+
+func deviceKey(request, device, shareID string) string {
+	return request + "/" + device + "/" + shareID
+}
+
+// vlanDriver implements the driver.Driver interface.
+type vlanDriver struct {
+	// parents maps the interfaces that can be used as parents to their allowed VLAN ranges.
+	parents map[string][]kndnet.VlanRange
+	// ipam allocates the addresses of the pod interfaces.
+	ipam *ipam.Allocator
+	// interfaceDefaults are the default settings of the pod interfaces, they are
+	// replaced on configuration reloads.
+	interfaceDefaults atomic.Pointer[kndconfig.InterfaceConfig]
+
+	mu sync.Mutex
+	// vlans maps the VLANs of the prepared claims to their claims, the capacity of
+	// a parent limits how many VLANs it has but not their IDs, and the kernel only
+	// creates one interface per VLAN ID of a parent.
+	vlans map[vlanKey]types.UID
+}
+
+// vlanKey identifies a VLAN interface on a parent.
+type vlanKey struct {
+	parent   string
+	outerID  int
+	protocol netlink.VlanProtocol
+	id       int
+}
+
+// NewDriver creates a new instance of the VLAN driver.
+func NewDriver(parents map[string][]kndnet.VlanRange, allocator *ipam.Allocator) *vlanDriver {
+	d := &vlanDriver{
+		parents: parents,
+		ipam:    allocator,
+		vlans:   map[vlanKey]types.UID{},
+	}
+	d.interfaceDefaults.Store(&kndconfig.InterfaceConfig{})
+	return d
+}
+
+// SetInterfaceDefaults replaces the default settings of the pod interfaces, they
+// are used by the claims prepared afterwards.
+func (d *vlanDriver) SetInterfaceDefaults(defaults kndconfig.InterfaceConfig) {
+	d.interfaceDefaults.Store(&defaults)
+}
+
+// GetDevices advertises each configured parent interface as a device that can be
+// allocated once per allowed VLAN.
+func (d *vlanDriver) GetDevices() ([]resourcev1.Device, error) {
+	var devices []resourcev1.Device
+	for name, ranges := range d.parents {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			klog.V(2).Infof("Parent interface %s not found: %v", name, err)
+			continue
+		}
+		attrs := link.Attrs()
+
+		total := 0
+		allowed := make([]string, 0, len(ranges))
+		minID, maxID := kndnet.MaxVlanID, kndnet.MinVlanID
+		for _, r := range ranges {
+			total += r.Max - r.Min + 1
+			allowed = append(allowed, r.String())
+			minID = min(minID, r.Min)
+			maxID = max(maxID, r.Max)
+		}
+
+		device := resourcev1.Device{
+			Name: attrs.Name,
+			Attributes: map[resourcev1.QualifiedName]resourcev1.DeviceAttribute{
+				"interface-name": {StringValue: ptr.To(attrs.Name)},
+				"mac-address":    {StringValue: ptr.To(attrs.HardwareAddr.String())},
+				"mtu":            {IntValue: ptr.To(int64(attrs.MTU))},
+				"allowed-vlans":  {StringValue: ptr.To(strings.Join(allowed, ","))},
+				"vlan-id-min":    {IntValue: ptr.To(int64(minID))},
+				"vlan-id-max":    {IntValue: ptr.To(int64(maxID))},
+			},
+			AllowMultipleAllocations: ptr.To(true),
+			Capacity: map[resourcev1.QualifiedName]resourcev1.DeviceCapacity{
+				vlansCapacity: {
+					Value: *resource.NewQuantity(int64(total), resource.DecimalSI),
+					RequestPolicy: &resourcev1.CapacityRequestPolicy{
+						Default:     resource.NewQuantity(1, resource.DecimalSI),
+						ValidValues: []resource.Quantity{*resource.NewQuantity(1, resource.DecimalSI)},
+					},
+				},
+			},
+		}
+		devices = append(devices, device)
+		klog.V(2).Infof("Discovered parent device: %s with VLANs %s", attrs.Name, strings.Join(allowed, ","))
+	}
+	return devices, nil
+}
+
+// PrepareDevice validates the VLAN configuration of every device allocated to the claim.
+func (d *vlanDriver) PrepareDevice(ctx context.Context, claim *resourcev1.ResourceClaim) (interface{}, error) {
+	if claim.Status.Allocation == nil || len(claim.Status.Allocation.Devices.Results) == 0 {
+		return nil, fmt.Errorf("claim %s has no allocated devices", claim.Name)
+	}
+
+	prepared := preparedClaim{}
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		// the VLAN IDs of the fabrics are not interfaces
+		if result.Driver != driverName || vlanid.IsFabricPool(result.Pool) {
+			continue
+		}
+		config := vlanConfig{MTU: d.interfaceDefaults.Load().MTU}
+		if err := driver.DecodeDeviceConfig(claim, driverName, result.Request, &config); err != nil {
+			return nil, err
+		}
+		if config.VlanID == 0 {
+			id, err := allocatedVlanID(claim, config.VlanIDRequest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid configuration for request %s of claim %s/%s: %w", result.Request, claim.Namespace, claim.Name, err)
+			}
+			config.VlanID = id
+		}
+		device, err := d.newPreparedDevice(result.Device, result.Request, config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration for request %s of claim %s/%s: %w", result.Request, claim.Namespace, claim.Name, err)
+		}
+		shareID := ""
+		if result.ShareID != nil {
+			shareID = string(*result.ShareID)
+		}
+		key := deviceKey(result.Request, result.Device, shareID)
+		if config.IPAM.Pool != "" {
+			device.ipam, err = ipam.PoolResult(claim, result, config.IPAM)
+			if err != nil {
+				return nil, err
+			}
+		} else if !config.IPAM.Empty() {
+			device.ipam, err = d.ipam.Allocate(claim.UID, key, config.IPAM)
+			if err != nil {
+				return nil, fmt.Errorf("failed to allocate addresses for request %s of claim %s/%s: %w", result.Request, claim.Namespace, claim.Name, err)
+			}
+		}
+		prepared[key] = device
+		klog.Infof("Preparing VLAN %d interface %q on parent %q for claim %s/%s", device.vlan.ID, device.linkAttrs.Name, device.parent, claim.Namespace, claim.Name)
+	}
+	if err := d.reserveVlans(claim.UID, prepared); err != nil {
+		return nil, fmt.Errorf("invalid configuration for claim %s/%s: %w", claim.Namespace, claim.Name, err)
+	}
+	return prepared, nil
+}
+
+// reserveVlans records the VLANs of the claim, it fails if any of them is used by
+// another claim or twice by the claim, the pod sandbox would fail to create it.
+func (d *vlanDriver) reserveVlans(claimUID types.UID, prepared preparedClaim) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	keys := map[vlanKey]bool{}
+	for _, device := range prepared {
+		key := vlanKey{parent: device.parent, outerID: device.vlan.OuterID, protocol: device.vlan.Protocol, id: device.vlan.ID}
+		if key.protocol == netlink.VLAN_PROTOCOL_UNKNOWN {
+			key.protocol = netlink.VLAN_PROTOCOL_8021Q
+		}
+		if keys[key] {
+			return fmt.Errorf("VLAN %d is used twice on parent %s", key.id, key.parent)
+		}
+		if uid, ok := d.vlans[key]; ok && uid != claimUID {
+			return fmt.Errorf("VLAN %d is already used on parent %s by claim %s", key.id, key.parent, uid)
+		}
+		keys[key] = true
+	}
+	// the claim can be prepared again with other configuration
+	d.releaseVlans(claimUID)
+	for key := range keys {
+		d.vlans[key] = claimUID
+	}
+	return nil
+}
+
+// releaseVlans forgets the VLANs of the claim, it must be called with the lock held.
+func (d *vlanDriver) releaseVlans(claimUID types.UID) {
+	for key, uid := range d.vlans {
+		if uid == claimUID {
+			delete(d.vlans, key)
+		}
+	}
+}
+
+// allocatedVlanID returns the VLAN ID of a fabric allocated to the claim for the
+// request, or the only one allocated if the request is empty. It returns 0 if no
+// VLAN ID is allocated.
+func allocatedVlanID(claim *resourcev1.ResourceClaim, request string) (int, error) {
+	var ids []int
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if result.Driver != driverName {
+			continue
+		}
+		_, id, ok := vlanid.AllocatedID(result)
+		if !ok {
+			continue
+		}
+		// subrequests are named <request>/<subrequest>
+		if request != "" && result.Request != request && !strings.HasPrefix(result.Request, request+"/") {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	switch {
+	case len(ids) == 1:
+		return ids[0], nil
+	case len(ids) > 1:
+		return 0, fmt.Errorf("%d VLAN IDs allocated, the vlanIdRequest must select one of them", len(ids))
+	case request != "":
+		return 0, fmt.Errorf("no VLAN ID allocated for request %s", request)
+	}
+	return 0, nil
+}
+
+func (d *vlanDriver) newPreparedDevice(parent, request string, config vlanConfig) (*preparedDevice, error) {
+	ranges, ok := d.parents[parent]
+	if !ok {
+		return nil, fmt.Errorf("interface %s is not a configured parent", parent)
+	}
+
+	protocol, err := kndnet.ParseVlanProtocol(config.Protocol)
+	if err != nil {
+		return nil, err
+	}
+	device := &preparedDevice{
+		parent: parent,
+		vlan: kndnet.VlanConfig{
+			ID:       config.VlanID,
+			Protocol: protocol,
+			OuterID:  config.OuterVlanID,
+		},
+	}
+	if err := device.vlan.Validate(); err != nil {
+		return nil, err
+	}
+
+	// The VLAN seen by the network attached to the parent is the outer one for QinQ.
+	fabricID := device.vlan.ID
+	if device.vlan.OuterID != 0 {
+		fabricID = device.vlan.OuterID
+	}
+	if !slices.ContainsFunc(ranges, func(r kndnet.VlanRange) bool { return r.Contains(fabricID) }) {
+		return nil, fmt.Errorf("VLAN %d is not allowed on parent %s", fabricID, parent)
+	}
+
+	device.linkAttrs.Name = config.InterfaceName
+	if device.linkAttrs.Name == "" {
+		// subrequests are named <request>/<subrequest>
+		device.linkAttrs.Name = request[strings.LastIndex(request, "/")+1:]
+	}
+	if len(device.linkAttrs.Name) > unix.IFNAMSIZ-1 {
+		device.linkAttrs.Name = device.linkAttrs.Name[:unix.IFNAMSIZ-1]
+	}
+	device.linkAttrs.MTU = config.MTU
+	if config.HardwareAddress != "" {
+		device.linkAttrs.HardwareAddr, err = net.ParseMAC(config.HardwareAddress)
+		if err != nil {
+			return nil, err
+		}
+	}
+	device.podInterface, err = driver.NewPodInterface(config.PodInterfaceConfig)
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// UnprepareDevice releases the addresses of the claim, the VLAN interfaces are
+// deleted when the pod sandbox stops.
+func (d *vlanDriver) UnprepareDevice(ctx context.Context, claim kubeletplugin.NamespacedObject) error {
+	klog.Infof("Unpreparing resources for claim %s", claim.Name)
+	d.mu.Lock()
+	d.releaseVlans(claim.UID)
+	d.mu.Unlock()
+	return d.ipam.Release(claim.UID)
+}
+
+// ConfigureDeviceForPod creates the VLAN interface inside the pod's network namespace,
+// it is deleted if it can not be configured so the pod sandbox can be created again.
+func (d *vlanDriver) ConfigureDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
+	if vlanid.IsFabricPool(device.PoolName) {
+		return nil
+	}
+	vlan, err := getPreparedDevice(device, preparedData)
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("Creating VLAN %d interface %q on parent %q in pod %s/%s network namespace %s",
+		vlan.vlan.ID, vlan.linkAttrs.Name, vlan.parent, podSandbox.Namespace, podSandbox.Name, networkNamespace)
+	_, err = kndnet.NsAddVlan(vlan.parent, networkNamespace, vlan.linkAttrs, vlan.vlan, vlan.ipam.IPNets())
+	if err != nil {
+		if vlan.vlan.OuterID != 0 {
+			if err := kndnet.DelServiceVlan(vlan.parent, vlan.vlan.OuterID); err != nil {
+				klog.Errorf("failed to delete the 802.1ad interface of VLAN %d on %s: %v", vlan.vlan.OuterID, vlan.parent, err)
+			}
+		}
+		return err
+	}
+	if err := vlan.podInterface.Configure(networkNamespace, vlan.linkAttrs.Name, vlan.ipam, d.interfaceDefaults.Load().Announce); err != nil {
+		if cleanupErr := d.CleanupDeviceForPod(device, networkNamespace, podSandbox, preparedData); cleanupErr != nil {
+			klog.Errorf("failed to delete VLAN interface %s: %v", vlan.linkAttrs.Name, cleanupErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// CleanupDeviceForPod deletes the VLAN interface from the pod's network namespace.
+func (d *vlanDriver) CleanupDeviceForPod(device driver.AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
+	if vlanid.IsFabricPool(device.PoolName) {
+		return nil
+	}
+	vlan, err := getPreparedDevice(device, preparedData)
+	if err != nil {
+		return err
+	}
+
+	vlan.podInterface.Cleanup(networkNamespace, vlan.linkAttrs.Name)
+	klog.Infof("Deleting VLAN interface %q from pod %s/%s", vlan.linkAttrs.Name, podSandbox.Namespace, podSandbox.Name)
+	if err := kndnet.NsDelLink(networkNamespace, vlan.linkAttrs.Name); err != nil {
+		return err
+	}
+	// the 802.1ad interface of QinQ is shared with the other pods of the service VLAN
+	if vlan.vlan.OuterID != 0 {
+		if err := kndnet.DelServiceVlan(vlan.parent, vlan.vlan.OuterID); err != nil {
+			klog.Errorf("failed to delete the 802.1ad interface of VLAN %d on %s: %v", vlan.vlan.OuterID, vlan.parent, err)
+		}
+	}
+	return nil
+}
+
+func getPreparedDevice(device driver.AllocatedDevice, preparedData interface{}) (*preparedDevice, error) {
+	prepared, ok := preparedData.(preparedClaim)
+	if !ok {
+		return nil, fmt.Errorf("invalid prepared data type: expected preparedClaim, got %T", preparedData)
+	}
+	vlan, ok := prepared[deviceKey(device.Request, device.Name, device.ShareID)]
+	if !ok {
+		return nil, fmt.Errorf("device %s for request %s was not prepared", device.Name, device.Request)
+	}
+	return vlan, nil
+}
+
+// HandleError logs background errors from the driver framework.
+func (d *vlanDriver) HandleError(ctx context.Context, err error, msg string) {
+	klog.Errorf("Background error in driver framework: %s: %v", msg, err)
+}
+
+// parseParents parses the parent interfaces and their allowed VLANs in the
+// format <interface>:<vlan ranges>[;<interface>:<vlan ranges>...].
+func parseParents(s string) (map[string][]kndnet.VlanRange, error) {
+	parents := map[string][]kndnet.VlanRange{}
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, vlans, found := strings.Cut(entry, ":")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid parent %q, expected <interface>:<vlan ranges>", entry)
+		}
+		ranges, err := kndnet.ParseVlanRanges(vlans)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent %q: %w", entry, err)
+		}
+		if len(ranges) == 0 {
+			return nil, fmt.Errorf("invalid parent %q: no VLANs allowed", entry)
+		}
+		parents[name] = append(parents[name], ranges...)
+	}
+	return parents, nil
+}
+
+//================================================================
+// Main Entrypoint
+//================================================================
+
+const (
+	driverName = "vlan.k8s.io"
+)
+
+var (
+	hostnameOverride string
+	kubeconfig       string
+	bindAddress      string
+	parentVlans      string
+	configFile       string
+	ipamStateFile    string
+	ready            atomic.Bool
+)
+
+func init() {
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "Absolute path to the kubeconfig file.")
+	flag.StringVar(&bindAddress, "bind-address", ":9179", "The IP address and port for the metrics and healthz server.")
+	flag.StringVar(&hostnameOverride, "hostname-override", "", "Node name to use. Defaults to the node's hostname.")
+	flag.StringVar(&parentVlans, "parent-vlans", "", "Semicolon separated list of parent interfaces with their allowed VLANs, for example \"eth1:100-199,300;eth2:10-20\".")
+	flag.StringVar(&configFile, "config", "", "Path to the YAML or JSON node configuration file, it is reloaded when it changes.")
+	flag.StringVar(&ipamStateFile, "ipam-state-file", "/var/lib/knd/"+driverName+"/ipam.json", "Path to the file that persists the addresses allocated to the claims.")
+	klog.InitFlags(nil)
+}
+
+func main() {
+	flag.Parse()
+	printVersion()
+
+	parents, err := parseParents(parentVlans)
+	if err != nil {
+		klog.Fatalf("Invalid --parent-vlans flag: %v", err)
+	}
+
+	// Create a context that is cancelled on interruption signals.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, unix.SIGTERM)
+	defer cancel()
+
+	// Set up healthz and metrics endpoints.
+	setupHTTPServer()
+
+	// Set up Kubernetes client.
+	clientset, err := newClientset()
+	if err != nil {
+		klog.Fatalf("Failed to create Kubernetes client: %v", err)
+	}
+
+	nodeName, err := nodeutil.GetHostname(hostnameOverride)
+	if err != nil {
+		klog.Fatalf("Cannot get node name: %v", err)
+	}
+
+	nodeConfig, err := kndconfig.Load(configFile)
+	if err != nil {
+		klog.Fatalf("Invalid node configuration: %v", err)
+	}
+
+	allocator, err := ipam.NewAllocator(ipamStateFile)
+	if err != nil {
+		klog.Fatalf("Failed to load IPAM state: %v", err)
+	}
+
+	// 1. Create an instance of the VLAN driver.
+	vlanDriver := NewDriver(parents, allocator)
+	vlanDriver.SetInterfaceDefaults(nodeConfig.Interface)
+
+	// 2. Create the plugin framework, passing in the driver.
+	plugin := driver.NewPlugin(vlanDriver, driverName, nodeName, clientset, driver.WithNodeConfig(nodeConfig))
+
+	// 3. Start the plugin framework.
+	if err := plugin.Start(ctx); err != nil {
+		klog.Fatalf("Driver failed to start: %v", err)
+	}
+	defer plugin.Stop()
+
+	if configFile != "" {
+		err := kndconfig.Watch(ctx, configFile, nodeConfig, func(c *kndconfig.NodeConfig) {
+			vlanDriver.SetInterfaceDefaults(c.Interface)
+			plugin.UpdateNodeConfig(c)
+		})
+		if err != nil {
+			klog.Fatalf("Failed to watch node configuration: %v", err)
+		}
+	}
+
+	ready.Store(true)
+	klog.Infof("Driver started successfully on node %s", nodeName)
+
+	// Wait for the context to be cancelled.
+	<-ctx.Done()
+	klog.Info("Driver shutting down.")
+}
+
+func setupHTTPServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if ready.Load() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: bindAddress, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			klog.Fatalf("Failed to listen and serve: %v", err)
+		}
+	}()
+}
+
+func newClientset() (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create client-go config: %w", err)
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+func printVersion() {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		klog.Infof("Version: %s, Go version: %s", info.Main.Version, info.GoVersion)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/controller"
+	"github.com/aojea/kubernetes-network-drivers/pkg/vlanid"
+)
+
+const (
+	controllerName = "vlanid-controller"
+)
+
+var (
+	kubeconfig    string
+	driverName    string
+	fabrics       string
+	deviceClasses string
+	workers       int
+	resyncPeriod  time.Duration
+
+	bindAddress    string
+	leaderElection *controller.LeaderElectionFlags
+)
+
+func init() {
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "absolute path to the kubeconfig file")
+	flag.StringVar(&driverName, "driver-name", "vlan.k8s.io", "Name of the VLAN driver of the nodes, the VLAN IDs are published as its devices.")
+	flag.StringVar(&fabrics, "fabrics", "", "Semicolon separated list of fabrics with their VLAN IDs and the labels of their nodes, for example \"storage:100-199:fabric=storage;backend:10-20\".")
+	flag.StringVar(&deviceClasses, "device-classes", "vlan-id", "Comma separated list of the DeviceClasses of the VLAN IDs, whose claims are tracked.")
+	flag.IntVar(&workers, "workers", 2, "Number of claims reconciled in parallel.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute, "Period of the reconciliation of all the claims and the VLAN IDs.")
+	leaderElection = controller.AddLeaderElectionFlags(flag.CommandLine, controllerName)
+	flag.StringVar(&bindAddress, "bind-address", ":9182", "The IP address and port for the metrics, healthz and readyz server.")
+	klog.InitFlags(nil)
+	flag.Parse()
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, unix.SIGTERM)
+	defer cancel()
+
+	fabricList, err := vlanid.ParseFabrics(fabrics)
+	if err != nil {
+		klog.Fatalf("Invalid fabrics %q: %v", fabrics, err)
+	}
+	if len(fabricList) == 0 {
+		klog.Fatalf("No fabrics configured, use --fabrics")
+	}
+
+	var config *rest.Config
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		klog.Fatalf("Failed to create Kubernetes client config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Failed to create Kubernetes client: %v", err)
+	}
+
+	var classes []string
+	for _, class := range strings.Split(deviceClasses, ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			classes = append(classes, class)
+		}
+	}
+
+	// 1. Create the reconciler that publishes the VLAN IDs of the fabrics
+	reconciler := vlanid.NewReconciler(driverName, fabricList, classes...)
+
+	// 2. Create and run the controller, with leader election when several replicas run
+	opts := []controller.Option{
+		controller.WithDrivers(driverName),
+		controller.WithWorkers(workers),
+		controller.WithResyncPeriod(resyncPeriod),
+	}
+	if config, ok := leaderElection.LeaderElection(); ok {
+		opts = append(opts, controller.WithLeaderElection(config))
+	}
+	ctrl := controller.NewController(clientset, reconciler, controllerName, opts...)
+	controller.StartHTTPServer(bindAddress, ctrl.Readyz)
+	ctrl.Run(ctx)
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/discovery"
+)
+
+const (
+	// APIVersion is the version of the configuration understood by this package.
+	APIVersion = "knd.x-k8s.io/v1alpha1"
+	// Kind of the node configuration.
+	Kind = "NodeConfig"
+
+	defaultPublishInterval     = 5 * time.Second
+	defaultNRIPluginIndex      = "10"
+	defaultNRIRestartDelay     = 5 * time.Second
+	defaultNRIMaxRestarts      = 10
+	defaultRegistrationTimeout = 30 * time.Second
+	defaultAnnounceCount       = 3
+	defaultAnnounceInterval    = time.Second
+
+	minPublishInterval = time.Second
+	minMTU             = 68
+	maxMTU             = 65535
+	maxAnnounceCount   = 100
+)
+
+// nriPluginIndexRegexp matches the two digits index that orders the NRI plugins.
+var nriPluginIndexRegexp = regexp.MustCompile(`^[0-9]{2}$`)
+
+// NodeConfig is the configuration of the node agents of the network drivers.
+type NodeConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// DevicePolicy selects the host interfaces published by the drivers that
+	// discover them, it defaults to discovery.DefaultPolicy.
+	DevicePolicy *discovery.Policy `json:"devicePolicy,omitempty"`
+	// PublishInterval is the period between two publications of the ResourceSlices.
+	PublishInterval metav1.Duration `json:"publishInterval,omitempty"`
+	// Interface are the default settings of the interfaces created inside the pods,
+	// the claim configuration overrides them.
+	Interface InterfaceConfig `json:"interface,omitempty"`
+	// NRI is the configuration of the NRI plugin.
+	NRI NRIConfig `json:"nri,omitempty"`
+	// Timeouts of the interactions with the kubelet.
+	Timeouts TimeoutsConfig `json:"timeouts,omitempty"`
+
+	// data is the content the configuration was parsed from.
+	data []byte
+}
+
+// InterfaceConfig are the default settings of the pod interfaces.
+type InterfaceConfig struct {
+	// MTU of the interface inside the pod, 0 uses the one of the driver.
+	MTU int `json:"mtu,omitempty"`
+	// Announce configures the announcements of the addresses assigned to the interfaces.
+	Announce AnnounceConfig `json:"announce,omitempty"`
+}
+
+// AnnounceConfig configures the gratuitous ARPs and unsolicited IPv6 neighbor
+// advertisements sent for the addresses assigned to the pod interfaces, so the
+// switches and peers do not keep sending the traffic to the previous owner.
+type AnnounceConfig struct {
+	// Count of announcements of every address, 0 disables them.
+	Count *int `json:"count,omitempty"`
+	// Interval between the announcements of an address.
+	Interval metav1.Duration `json:"interval,omitempty"`
+}
+
+// Enabled returns true if the addresses are announced.
+func (a AnnounceConfig) Enabled() bool {
+	return a.Count != nil && *a.Count > 0
+}
+
+// NRIConfig is the configuration of the NRI plugin.
+type NRIConfig struct {
+	// PluginIndex orders the NRI plugins, it is two digits string.
+	PluginIndex string `json:"pluginIndex,omitempty"`
+	// RestartDelay is the time to wait before reconnecting to the runtime.
+	RestartDelay metav1.Duration `json:"restartDelay,omitempty"`
+	// MaxRestarts is the number of consecutive reconnections before exiting.
+	MaxRestarts int `json:"maxRestarts,omitempty"`
+}
+
+// TimeoutsConfig are the timeouts of the interactions with the kubelet.
+type TimeoutsConfig struct {
+	// Registration is the time to wait for the kubelet to register the DRA plugin.
+	Registration metav1.Duration `json:"registration,omitempty"`
+}
+
+// Default returns the configuration used when there is no configuration file.
+func Default() *NodeConfig {
+	c := &NodeConfig{}
+	c.APIVersion = APIVersion
+	c.Kind = Kind
+	c.SetDefaults()
+	return c
+}
+
+// SetDefaults sets the default value of the fields that are not set, the
+// apiVersion and kind are required and never defaulted.
+func (c *NodeConfig) SetDefaults() {
+	if c.DevicePolicy == nil {
+		c.DevicePolicy = discovery.DefaultPolicy()
+	}
+	if c.PublishInterval.Duration == 0 {
+		c.PublishInterval.Duration = defaultPublishInterval
+	}
+	if c.NRI.PluginIndex == "" {
+		c.NRI.PluginIndex = defaultNRIPluginIndex
+	}
+	if c.NRI.RestartDelay.Duration == 0 {
+		c.NRI.RestartDelay.Duration = defaultNRIRestartDelay
+	}
+	if c.NRI.MaxRestarts == 0 {
+		c.NRI.MaxRestarts = defaultNRIMaxRestarts
+	}
+	if c.Timeouts.Registration.Duration == 0 {
+		c.Timeouts.Registration.Duration = defaultRegistrationTimeout
+	}
+	if c.Interface.Announce.Count == nil {
+		c.Interface.Announce.Count = ptr.To(defaultAnnounceCount)
+	}
+	if c.Interface.Announce.Interval.Duration == 0 {
+		c.Interface.Announce.Interval.Duration = defaultAnnounceInterval
+	}
+}
+
+// Validate checks the configuration, it is expected to be defaulted.
+func (c *NodeConfig) Validate() error {
+	if c.APIVersion != APIVersion {
+		return fmt.Errorf("unsupported apiVersion %q, expected %q", c.APIVersion, APIVersion)
+	}
+	if c.Kind != Kind {
+		return fmt.Errorf("unsupported kind %q, expected %q", c.Kind, Kind)
+	}
+	if c.DevicePolicy != nil {
+		if err := c.DevicePolicy.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.PublishInterval.Duration < minPublishInterval {
+		return fmt.Errorf("publishInterval %v is lower than %v", c.PublishInterval.Duration, minPublishInterval)
+	}
+	if c.Interface.MTU != 0 && (c.Interface.MTU < minMTU || c.Interface.MTU > maxMTU) {
+		return fmt.Errorf("interface mtu %d out of range %d-%d", c.Interface.MTU, minMTU, maxMTU)
+	}
+	if count := c.Interface.Announce.Count; count != nil && (*count < 0 || *count > maxAnnounceCount) {
+		return fmt.Errorf("interface announce count %d out of range 0-%d", *count, maxAnnounceCount)
+	}
+	if c.Interface.Announce.Interval.Duration < 0 {
+		return fmt.Errorf("interface announce interval %v must be positive", c.Interface.Announce.Interval.Duration)
+	}
+	if !nriPluginIndexRegexp.MatchString(c.NRI.PluginIndex) {
+		return fmt.Errorf("nri pluginIndex %q must be two digits", c.NRI.PluginIndex)
+	}
+	if c.NRI.RestartDelay.Duration < 0 {
+		return fmt.Errorf("nri restartDelay %v must be positive", c.NRI.RestartDelay.Duration)
+	}
+	if c.NRI.MaxRestarts < 0 {
+		return fmt.Errorf("nri maxRestarts %d must be positive", c.NRI.MaxRestarts)
+	}
+	if c.Timeouts.Registration.Duration < 0 {
+		return fmt.Errorf("registration timeout %v must be positive", c.Timeouts.Registration.Duration)
+	}
+	return nil
+}
+
+// Parse decodes a YAML or JSON configuration, defaults and validates it.
+func Parse(data []byte) (*NodeConfig, error) {
+	c := &NodeConfig{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("failed to decode node configuration: %w", err)
+	}
+	c.SetDefaults()
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid node configuration: %w", err)
+	}
+	c.data = data
+	return c, nil
+}
+
+// Load reads the configuration from the file at path, it returns the default
+// configuration if path is empty.
+func Load(path string) (*NodeConfig, error) {
+	if path == "" {
+		return Default(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node configuration %s: %w", path, err)
+	}
+	return Parse(data)
+}
+
+// Watch calls onChange with the new configuration every time the content of the
+// file at path changes from the one of current, returned by Load, until the context
+// is cancelled. Invalid configurations are logged and ignored so the last valid one
+// stays in use.
+//
+// The directory of the file is watched instead of the file itself, the files of
+// the ConfigMap volumes are symlinks that are atomically replaced on update.
+func Watch(ctx context.Context, path string, current *NodeConfig, onChange func(*NodeConfig)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher for %s: %w", path, err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %w", path, err)
+	}
+
+	// the file may have changed since it was loaded
+	last := current.data
+	reload := func() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			klog.V(2).Infof("failed to read node configuration %s: %v", path, err)
+			return
+		}
+		if bytes.Equal(data, last) {
+			return
+		}
+		c, err := Parse(data)
+		if err != nil {
+			klog.Errorf("ignoring node configuration update: %v", err)
+			return
+		}
+		last = data
+		klog.Infof("node configuration %s reloaded", path)
+		onChange(c)
+	}
+
+	go func() {
+		defer watcher.Close()
+		reload()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				klog.Errorf("error watching node configuration %s: %v", path, err)
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				reload()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`
+apiVersion: knd.x-k8s.io/v1alpha1
+kind: NodeConfig
+publishInterval: 30s
+interface:
+  mtu: 9000
+  announce:
+    count: 0
+devicePolicy:
+  include:
+  - driver: mlx5_core
+nri:
+  pluginIndex: "20"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.PublishInterval.Duration != 30*time.Second {
+		t.Errorf("expected publish interval 30s, got %v", c.PublishInterval.Duration)
+	}
+	if c.Interface.MTU != 9000 {
+		t.Errorf("expected MTU 9000, got %d", c.Interface.MTU)
+	}
+	if c.Interface.Announce.Enabled() || c.Interface.Announce.Interval.Duration != defaultAnnounceInterval {
+		t.Errorf("expected disabled announcements, got %+v", c.Interface.Announce)
+	}
+	if c.NRI.PluginIndex != "20" || c.NRI.MaxRestarts != defaultNRIMaxRestarts || c.NRI.RestartDelay.Duration != defaultNRIRestartDelay {
+		t.Errorf("unexpected NRI configuration %+v", c.NRI)
+	}
+	if c.Timeouts.Registration.Duration != defaultRegistrationTimeout {
+		t.Errorf("expected default registration timeout, got %v", c.Timeouts.Registration.Duration)
+	}
+	if c.DevicePolicy == nil || len(c.DevicePolicy.Include) != 1 {
+		t.Errorf("unexpected device policy %+v", c.DevicePolicy)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"missing version":   `kind: NodeConfig`,
+		"wrong version":     "apiVersion: knd.x-k8s.io/v2\nkind: NodeConfig",
+		"unknown field":     "apiVersion: knd.x-k8s.io/v1alpha1\nkind: NodeConfig\nfoo: bar",
+		"short interval":    "apiVersion: knd.x-k8s.io/v1alpha1\nkind: NodeConfig\npublishInterval: 100ms",
+		"invalid mtu":       "apiVersion: knd.x-k8s.io/v1alpha1\nkind: NodeConfig\ninterface:\n  mtu: 10",
+		"invalid announce":  "apiVersion: knd.x-k8s.io/v1alpha1\nkind: NodeConfig\ninterface:\n  announce:\n    count: -1",
+		"invalid nri index": "apiVersion: knd.x-k8s.io/v1alpha1\nkind: NodeConfig\nnri:\n  pluginIndex: \"100\"",
+		"invalid policy":    "apiVersion: knd.x-k8s.io/v1alpha1\nkind: NodeConfig\ndevicePolicy:\n  exclude:\n  - name: \"eth[\"",
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLoadDefault(t *testing.T) {
+	c, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("default configuration is not valid: %v", err)
+	}
+	if !c.Interface.Announce.Enabled() {
+		t.Errorf("expected the addresses to be announced by default")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("apiVersion: knd.x-k8s.io/v1alpha1\nkind: NodeConfig\n")
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the changes after the configuration was loaded are not missed
+	write("apiVersion: knd.x-k8s.io/v1alpha1\nkind: NodeConfig\npublishInterval: 2m\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan *NodeConfig, 10)
+	if err := Watch(ctx, path, loaded, func(c *NodeConfig) { changes <- c }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case c := <-changes:
+		if c.PublishInterval.Duration != 2*time.Minute {
+			t.Errorf("expected publish interval 2m, got %v", c.PublishInterval.Duration)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the configuration reload")
+	}
+
+	// invalid configurations are ignored
+	write("apiVersion: knd.x-k8s.io/v1alpha1\nkind: NodeConfig\npublishInterval: 1ms\n")
+	write("apiVersion: knd.x-k8s.io/v1alpha1\nkind: NodeConfig\npublishInterval: 1m\n")
+	select {
+	case c := <-changes:
+		if c.PublishInterval.Duration != time.Minute {
+			t.Errorf("expected publish interval 1m, got %v", c.PublishInterval.Duration)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the configuration reload")
+	}
+}
@@ -2,119 +2,463 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	resourcelisters "k8s.io/client-go/listers/resource/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
 	resourcev1 "k8s.io/api/resource/v1"
 )
 
+const (
+	// ClaimUIDLabel is the label of the ResourceSlices created for a claim, its
+	// value is the UID of the claim.
+	ClaimUIDLabel = "controller.knd.x-k8s.io/claim-uid"
+	// ControllerLabel is the label of the ResourceSlices created by a controller, its
+	// value is the controller name. The slices of the pools published by a
+	// PoolReconciler only have this label.
+	ControllerLabel = "controller.knd.x-k8s.io/controller"
+
+	defaultWorkers      = 2
+	defaultResyncPeriod = 10 * time.Minute
+	defaultGCInterval   = 10 * time.Minute
+
+	// deviceClassIndex indexes the claims by the DeviceClasses of their requests.
+	deviceClassIndex = "deviceClass"
+	// uidIndex indexes the claims by UID.
+	uidIndex = "uid"
+	// claimUIDIndex indexes the ResourceSlices by the UID of their claim.
+	claimUIDIndex = "claimUID"
+	// controllerIndex indexes the ResourceSlices of the pools by controller.
+	controllerIndex = "controller"
+)
+
 // Reconciler is the interface that a specific controller implementation must satisfy.
 type Reconciler interface {
 	// IsDeviceClassRelevant checks if this controller is responsible for the given DeviceClass.
 	IsDeviceClassRelevant(deviceClass *resourcev1.DeviceClass) bool
 
-	// Reconcile is called when a ResourceClaim is added or updated. It should create
-	// the necessary ResourceSlice.
-	Reconcile(ctx context.Context, claim *resourcev1.ResourceClaim) (*resourcev1.ResourceSlice, error)
+	// Reconcile is called when a ResourceClaim is added or updated. It returns the
+	// desired ResourceSlices of the claim, the controller creates or updates them and
+	// deletes the ones that are not returned anymore. All the slices of a pool must
+	// be returned for the same claim, the controller sets the generation and the
+	// slice count of the pools. The claim is shared with the informer cache and must
+	// not be modified.
+	Reconcile(ctx context.Context, claim *resourcev1.ResourceClaim) ([]*resourcev1.ResourceSlice, error)
 
-	// Delete is called when a ResourceClaim is deleted. It should clean up any
-	// resources that were created for the claim, like the ResourceSlice.
+	// Delete is called when a ResourceClaim handled by the reconciler is being
+	// deleted, the finalizer of the controller is removed once it succeeds. It is
+	// also called when a claim without the finalizer is deleted, so it must be
+	// idempotent. It should clean up any resources that were created for the claim,
+	// the ResourceSlices are deleted by the controller.
 	Delete(ctx context.Context, claim *resourcev1.ResourceClaim) error
 }
 
-// Controller manages the lifecycle of the Kubernetes controller.
+// SliceOwner is implemented by the reconcilers that can identify the ResourceSlices
+// they created without the ControllerLabel, like the ones of older versions, so they
+// are garbage collected when their claim does not exist.
+type SliceOwner interface {
+	// ClaimOfSlice returns the UID of the claim the slice was created for, or false
+	// if the slice was not created by the reconciler.
+	ClaimOfSlice(slice *resourcev1.ResourceSlice) (types.UID, bool)
+}
+
+// ClaimFilter is implemented by the reconcilers that only handle some of the claims
+// of their DeviceClasses, like the ones with a configuration of the reconciler. The
+// controller does not add its finalizer nor update the status of the other claims.
+type ClaimFilter interface {
+	// IsClaimRelevant checks if the allocated claim is handled by the reconciler.
+	IsClaimRelevant(claim *resourcev1.ResourceClaim) bool
+}
+
+// Option configures the Controller.
+type Option func(*Controller)
+
+// WithWorkers sets the number of claims reconciled in parallel, it defaults to 2.
+func WithWorkers(workers int) Option {
+	return func(c *Controller) {
+		c.workers = workers
+	}
+}
+
+// WithResyncPeriod sets the period of the reconciliation of all the claims, that
+// corrects the drift of the resources created for them, it defaults to 10 minutes.
+func WithResyncPeriod(period time.Duration) Option {
+	return func(c *Controller) {
+		c.resyncPeriod = period
+	}
+}
+
+// WithDrivers restricts the controller to the claims with devices allocated by one
+// of the drivers, so several controllers can handle the claims of the same
+// DeviceClass. The devices of the other drivers are ignored.
+func WithDrivers(drivers ...string) Option {
+	return func(c *Controller) {
+		c.drivers = sets.New(drivers...)
+	}
+}
+
+// WithLeaderFunc runs the function with the workers, until the context is done. With
+// leader election it only runs in the leader, like the garbage collection of the
+// resources allocated by the reconciler.
+func WithLeaderFunc(run func(ctx context.Context)) Option {
+	return func(c *Controller) {
+		c.leaderFuncs = append(c.leaderFuncs, run)
+	}
+}
+
+// WithGarbageCollectionInterval sets the interval of the deletion of the ResourceSlices
+// of the claims that do not exist, it defaults to 10 minutes.
+func WithGarbageCollectionInterval(interval time.Duration) Option {
+	return func(c *Controller) {
+		c.gcInterval = interval
+	}
+}
+
+// Controller manages the lifecycle of the Kubernetes controller. The claims are
+// reconciled by a pool of workers from a rate limited queue, the failed ones are
+// retried with exponential backoff. The outcome of the last reconciliation is the
+// Reconciled condition of the devices in the claim status.
 type Controller struct {
 	controllerName string
 	kubeClient     kubernetes.Interface
-	informer       cache.SharedIndexInformer
 	reconciler     Reconciler
+	workers        int
+	resyncPeriod   time.Duration
+	gcInterval     time.Duration
+	leaderElection *LeaderElection
+	finalizer      string
+	// drivers are the drivers of the devices handled by the controller, all if empty.
+	drivers sets.Set[string]
+	// leaderFuncs run with the workers.
+	leaderFuncs []func(ctx context.Context)
+
+	// informerFactory is shared with the other controllers of a Manager, that starts
+	// and stops it.
+	informerFactory     informers.SharedInformerFactory
+	claimInformer       cache.SharedIndexInformer
+	claimLister         resourcelisters.ResourceClaimLister
+	deviceClassInformer cache.SharedIndexInformer
+	deviceClassLister   resourcelisters.DeviceClassLister
+	sliceInformer       cache.SharedIndexInformer
+	sliceLister         resourcelisters.ResourceSliceLister
+	// queue contains the namespace/name keys of the claims to reconcile.
+	queue workqueue.TypedRateLimitingInterface[string]
+
+	// synced is true while the caches are synced, in the leader and in the standby
+	// replicas.
+	synced atomic.Bool
+	// leading is true while the workers reconcile the claims.
+	leading atomic.Bool
+
+	mu sync.Mutex
+	// deletedClaims keeps the last state of the deleted claims, indexed by key, until
+	// the reconciler cleans up their resources.
+	deletedClaims map[string]*resourcev1.ResourceClaim
+	// cleanedUp are the UIDs of the claims whose resources were cleaned up before
+	// removing the finalizer, until their delete event is handled.
+	cleanedUp sets.Set[types.UID]
+	// allocations are the allocations of the claims reconciled by a PoolReconciler,
+	// indexed by claim UID, its pools are synced again when they change.
+	allocations map[types.UID]*resourcev1.AllocationResult
 }
 
 // NewController creates a new controller framework instance.
-func NewController(kubeClient kubernetes.Interface, reconciler Reconciler, controllerName string) *Controller {
-	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
-	informer := informerFactory.Resource().V1().ResourceClaims().Informer()
+func NewController(kubeClient kubernetes.Interface, reconciler Reconciler, controllerName string, opts ...Option) *Controller {
+	return newController(kubeClient, nil, reconciler, controllerName, opts...)
+}
 
+// newController creates a controller with the informers of the factory, or with its
+// own informers if the factory is nil.
+func newController(kubeClient kubernetes.Interface, informerFactory informers.SharedInformerFactory, reconciler Reconciler, controllerName string, opts ...Option) *Controller {
 	c := &Controller{
 		controllerName: controllerName,
 		kubeClient:     kubeClient,
-		informer:       informer,
 		reconciler:     reconciler,
+		workers:        defaultWorkers,
+		resyncPeriod:   defaultResyncPeriod,
+		gcInterval:     defaultGCInterval,
+		deletedClaims:  map[string]*resourcev1.ResourceClaim{},
+		cleanedUp:      sets.New[types.UID](),
+		allocations:    map[types.UID]*resourcev1.AllocationResult{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.finalizer == "" {
+		c.finalizer = "knd.x-k8s.io/" + controllerName
 	}
 
-	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    c.addOrUpdate,
-		UpdateFunc: func(old, new interface{}) { c.addOrUpdate(new) },
-		DeleteFunc: c.delete,
+	c.queue = workqueue.NewTypedRateLimitingQueueWithConfig(
+		workqueue.DefaultTypedControllerRateLimiter[string](),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: controllerName, MetricsProvider: queueMetricsProvider{}},
+	)
+	c.informerFactory = informerFactory
+	if c.informerFactory == nil {
+		c.informerFactory = informers.NewSharedInformerFactory(kubeClient, c.resyncPeriod)
+	}
+	c.claimInformer = c.informerFactory.Resource().V1().ResourceClaims().Informer()
+	c.claimLister = c.informerFactory.Resource().V1().ResourceClaims().Lister()
+	c.deviceClassInformer = c.informerFactory.Resource().V1().DeviceClasses().Informer()
+	c.deviceClassLister = c.informerFactory.Resource().V1().DeviceClasses().Lister()
+	c.sliceInformer = c.informerFactory.Resource().V1().ResourceSlices().Informer()
+	c.sliceLister = c.informerFactory.Resource().V1().ResourceSlices().Lister()
+
+	// the indexes of shared informers are added by the first controller
+	err := addIndexers(c.claimInformer, cache.Indexers{
+		deviceClassIndex: func(obj interface{}) ([]string, error) {
+			claim, ok := obj.(*resourcev1.ResourceClaim)
+			if !ok {
+				return nil, nil
+			}
+			return claimDeviceClasses(claim), nil
+		},
+		uidIndex: func(obj interface{}) ([]string, error) {
+			claim, ok := obj.(*resourcev1.ResourceClaim)
+			if !ok {
+				return nil, nil
+			}
+			return []string{string(claim.UID)}, nil
+		},
 	})
+	if err != nil {
+		// it only fails if the informer was started
+		panic(err)
+	}
+	err = addIndexers(c.sliceInformer, cache.Indexers{
+		claimUIDIndex: func(obj interface{}) ([]string, error) {
+			slice, ok := obj.(*resourcev1.ResourceSlice)
+			if !ok || slice.Labels[ClaimUIDLabel] == "" {
+				return nil, nil
+			}
+			return []string{slice.Labels[ClaimUIDLabel]}, nil
+		},
+		controllerIndex: func(obj interface{}) ([]string, error) {
+			slice, ok := obj.(*resourcev1.ResourceSlice)
+			if !ok || slice.Labels[ControllerLabel] == "" || slice.Labels[ClaimUIDLabel] != "" {
+				return nil, nil
+			}
+			return []string{slice.Labels[ControllerLabel]}, nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
 
+	// the resyncs are delivered as updates, so all the claims are reconciled again.
+	// The writes of the status of the devices are ignored, or every update of the
+	// Reconciled condition would retry the failed claims without backoff.
+	_, _ = c.claimInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(old, new interface{}) {
+			if !devicesStatusUpdate(old, new) {
+				c.enqueue(new)
+			}
+		},
+		DeleteFunc: c.delete,
+	})
+	// a claim is relevant or not depending on its DeviceClasses
+	_, _ = c.deviceClassInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueDeviceClassClaims,
+		UpdateFunc: func(old, new interface{}) {
+			if old.(metav1.Object).GetResourceVersion() != new.(metav1.Object).GetResourceVersion() {
+				c.enqueueDeviceClassClaims(new)
+			}
+		},
+		DeleteFunc: c.enqueueDeviceClassClaims,
+	})
+	// the slices modified or deleted by others are restored by their claims
+	_, _ = c.sliceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.countSlice(obj, 1)
+			// the pools are not reconciled again with the claims, the slices missing
+			// from an outdated cache are seen once they are added
+			if labels := obj.(metav1.Object).GetLabels(); labels[ControllerLabel] == c.controllerName && labels[ClaimUIDLabel] == "" {
+				c.enqueuePools()
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			if old.(metav1.Object).GetResourceVersion() != new.(metav1.Object).GetResourceVersion() {
+				c.enqueueSliceClaim(new)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			c.countSlice(obj, -1)
+			c.enqueueSliceClaim(obj)
+		},
+	})
 	return c
 }
 
-// Run starts the controller's reconciliation loop.
+// Run starts the controller's reconciliation loop, it blocks until the context is done.
+// With leader election the caches are synced in all the replicas, so they are ready
+// to take over, and the claims are only reconciled while the replica is the leader.
 func (c *Controller) Run(ctx context.Context) {
+	defer c.queue.ShutDown()
+
 	klog.Infof("Starting controller: %s", c.controllerName)
-	go c.informer.Run(ctx.Done())
-	if !cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
-		klog.Fatalf("Failed to sync cache for controller: %s", c.controllerName)
+	c.informerFactory.Start(ctx.Done())
+	defer c.informerFactory.Shutdown()
+	if !cache.WaitForCacheSync(ctx.Done(), c.cacheSyncs()...) {
+		klog.Errorf("Failed to sync cache for controller: %s", c.controllerName)
+		return
+	}
+	c.synced.Store(true)
+	defer c.synced.Store(false)
+	// the replica is not ready anymore once it starts shutting down
+	stop := context.AfterFunc(ctx, func() { c.synced.Store(false) })
+	defer stop()
+
+	if c.leaderElection != nil {
+		runLeaderElection(ctx, c.kubeClient, c.controllerName, *c.leaderElection, c.runWorkers)
+		return
+	}
+	c.runWorkers(ctx)
+}
+
+// cacheSyncs returns the functions that check if the informers of the controller
+// are synced.
+func (c *Controller) cacheSyncs() []cache.InformerSynced {
+	return []cache.InformerSynced{c.claimInformer.HasSynced, c.deviceClassInformer.HasSynced, c.sliceInformer.HasSynced}
+}
+
+// runWorkers reconciles the claims with the synced informers until the context is
+// done.
+func (c *Controller) runWorkers(ctx context.Context) {
+	defer c.queue.ShutDown()
+	c.leading.Store(true)
+	leader.WithLabelValues(c.controllerName).Set(1)
+	defer func() {
+		c.leading.Store(false)
+		leader.WithLabelValues(c.controllerName).Set(0)
+	}()
+
+	var wg sync.WaitGroup
+	// the slices of the claims deleted while the controller was down are collected
+	// once the caches are synced
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wait.UntilWithContext(ctx, c.collectGarbage, c.gcInterval)
+	}()
+	// the pools are not resynced by the informers
+	if _, ok := c.reconciler.(PoolReconciler); ok {
+		c.restoreAllocations(ctx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.UntilWithContext(ctx, func(ctx context.Context) { c.enqueuePools() }, c.resyncPeriod)
+		}()
+	}
+	for _, run := range c.leaderFuncs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(ctx)
+		}()
+	}
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.UntilWithContext(ctx, c.runWorker, time.Second)
+		}()
 	}
 	<-ctx.Done()
 	klog.Infof("Shutting down controller: %s", c.controllerName)
+	c.queue.ShutDown()
+	wg.Wait()
 }
 
-// addOrUpdate handles the creation and update of ResourceSlices.
-func (c *Controller) addOrUpdate(obj interface{}) {
-	claim, ok := obj.(*resourcev1.ResourceClaim)
-	if !ok {
-		klog.Errorf("Invalid object type: %T", obj)
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		klog.Errorf("Failed to get key of object %#v: %v", obj, err)
 		return
 	}
+	c.queue.Add(key)
+}
 
-	if claim.Status.Allocation == nil {
+// enqueueDeviceClassClaims enqueues the claims with requests of the DeviceClass.
+func (c *Controller) enqueueDeviceClassClaims(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	deviceClass, ok := obj.(*resourcev1.DeviceClass)
+	if !ok {
+		klog.Errorf("Unexpected object %#v, expected a DeviceClass", obj)
 		return
 	}
-
-	ctx := context.Background()
-	deviceClass, err := c.kubeClient.ResourceV1().DeviceClasses().Get(ctx, claim.Spec.DeviceClassName, metav1.GetOptions{})
+	claims, err := c.claimInformer.GetIndexer().ByIndex(deviceClassIndex, deviceClass.Name)
 	if err != nil {
-		klog.Errorf("Failed to get DeviceClass %s for claim %s/%s: %v", claim.Spec.DeviceClassName, claim.Namespace, claim.Name, err)
+		klog.Errorf("Failed to get the claims of DeviceClass %s: %v", deviceClass.Name, err)
 		return
 	}
+	for _, claim := range claims {
+		c.enqueue(claim)
+	}
+}
 
-	if !c.reconciler.IsDeviceClassRelevant(deviceClass) {
+// countSlice updates the number of managed ResourceSlices if the slice was created
+// by the controller.
+func (c *Controller) countSlice(obj interface{}, delta float64) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	slice, ok := obj.(*resourcev1.ResourceSlice)
+	if !ok {
 		return
 	}
+	if c.ownsSlice(slice) {
+		managedSlices.WithLabelValues(c.controllerName).Add(delta)
+	}
+}
 
-	klog.Infof("Reconciling ResourceClaim %s/%s", claim.Namespace, claim.Name)
-
-	resourceSlice, err := c.reconciler.Reconcile(ctx, claim)
-	if err != nil {
-		klog.Errorf("Failed to reconcile ResourceClaim %s/%s: %v", claim.Namespace, claim.Name, err)
+// enqueueSliceClaim enqueues the claim that owns the ResourceSlice, or the pools
+// if the slice is one of them.
+func (c *Controller) enqueueSliceClaim(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	slice, ok := obj.(*resourcev1.ResourceSlice)
+	if !ok {
 		return
 	}
-
-	resourceSlice.SetOwnerReferences([]metav1.OwnerReference{
-		*metav1.NewControllerRef(claim, resourcev1.SchemeGroupVersion.WithKind("ResourceClaim")),
-	})
-
-	_, err = c.kubeClient.ResourceV1().ResourceSlices().Create(ctx, resourceSlice, metav1.CreateOptions{})
+	if !c.ownsSlice(slice) {
+		return
+	}
+	if slice.Labels[ClaimUIDLabel] == "" {
+		c.enqueuePools()
+		return
+	}
+	claims, err := c.claimInformer.GetIndexer().ByIndex(uidIndex, slice.Labels[ClaimUIDLabel])
 	if err != nil {
-		if errors.IsAlreadyExists(err) {
-			klog.Infof("ResourceSlice %s already exists", resourceSlice.Name)
-		} else {
-			klog.Errorf("Failed to create ResourceSlice %s: %v", resourceSlice.Name, err)
-		}
-	} else {
-		klog.Infof("Successfully created ResourceSlice %s", resourceSlice.Name)
+		klog.Errorf("Failed to get the claim of ResourceSlice %s: %v", slice.Name, err)
+		return
+	}
+	for _, claim := range claims {
+		c.enqueue(claim)
 	}
 }
 
-// delete handles the deletion of ResourceSlices.
+// delete records the last state of the deleted claim, the workers only have the
+// key and the claim is not in the informer cache anymore.
 func (c *Controller) delete(obj interface{}) {
 	claim, ok := obj.(*resourcev1.ResourceClaim)
 	if !ok {
@@ -131,8 +475,421 @@ func (c *Controller) delete(obj interface{}) {
 		}
 	}
 
-	klog.Infof("Deleting resources for ResourceClaim %s/%s", claim.Namespace, claim.Name)
-	if err := c.reconciler.Delete(context.Background(), claim); err != nil {
-		klog.Errorf("Failed to delete resources for claim %s/%s: %v", claim.Namespace, claim.Name, err)
+	key, err := cache.MetaNamespaceKeyFunc(claim)
+	if err != nil {
+		klog.Errorf("Failed to get key of claim %s/%s: %v", claim.Namespace, claim.Name, err)
+		return
+	}
+	c.mu.Lock()
+	c.deletedClaims[key] = claim
+	c.mu.Unlock()
+	c.queue.Add(key)
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+// processNextItem reconciles the next claim of the queue, it is requeued with
+// backoff if it fails. It returns false when the queue is shut down.
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	start := time.Now()
+	err := c.safeSync(ctx, key)
+	reconcileDuration.WithLabelValues(c.controllerName).Observe(time.Since(start).Seconds())
+	if err != nil {
+		reconcileErrors.WithLabelValues(c.controllerName).Inc()
+		klog.Errorf("Failed to sync ResourceClaim %s, retrying: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// safeSync syncs the key and returns the panics of the reconciler as errors, so a
+// failing reconciler does not stop the other controllers of the process.
+func (c *Controller) safeSync(ctx context.Context, key string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Errorf("Observed a panic of controller %s syncing %s: %v\n%s", c.controllerName, key, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.sync(ctx, key)
+}
+
+// sync reconciles the claim with the key, or cleans up its resources if it was deleted.
+func (c *Controller) sync(ctx context.Context, key string) error {
+	if key == poolsKey {
+		return c.syncPools(ctx)
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		// it never succeeds
+		klog.Errorf("Invalid ResourceClaim key %s: %v", key, err)
+		return nil
+	}
+
+	claim, err := c.claimLister.ResourceClaims(namespace).Get(name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	// a claim recreated with the same name is a different claim, the claims
+	// without the finalizer are cleaned up when they are deleted
+	c.mu.Lock()
+	deleted, ok := c.deletedClaims[key]
+	cleanedUp := ok && c.cleanedUp.Has(deleted.UID)
+	c.mu.Unlock()
+	if ok && (claim == nil || claim.UID != deleted.UID) {
+		if !cleanedUp {
+			klog.Infof("Deleting resources for ResourceClaim %s/%s", deleted.Namespace, deleted.Name)
+			if err := c.reconciler.Delete(ctx, deleted); err != nil {
+				return fmt.Errorf("failed to delete resources for claim %s: %w", key, err)
+			}
+		}
+		c.mu.Lock()
+		if c.deletedClaims[key] == deleted {
+			delete(c.deletedClaims, key)
+			c.cleanedUp.Delete(deleted.UID)
+		}
+		c.mu.Unlock()
+		c.syncAllocation(deleted.UID, nil)
+	}
+
+	if claim == nil {
+		return nil
+	}
+	return c.reconcile(ctx, claim)
+}
+
+// reconcile applies the ResourceSlices of the claim returned by the reconciler, or
+// cleans up its resources if it is being deleted.
+func (c *Controller) reconcile(ctx context.Context, claim *resourcev1.ResourceClaim) error {
+	// the claims are deallocated before their deletion completes
+	if claim.DeletionTimestamp != nil {
+		return c.finalize(ctx, claim)
+	}
+	// the slices of a deallocated claim are not wanted anymore
+	if claim.Status.Allocation == nil {
+		c.syncAllocation(claim.UID, nil)
+		return c.applySlices(ctx, claim, nil)
+	}
+
+	relevant, err := c.isClaimRelevant(claim)
+	if err != nil {
+		return fmt.Errorf("failed to get DeviceClasses: %w", err)
+	}
+	if !relevant {
+		return nil
+	}
+
+	klog.V(2).Infof("Reconciling ResourceClaim %s/%s", claim.Namespace, claim.Name)
+
+	claim, err = c.addFinalizer(ctx, claim)
+	if err != nil {
+		return err
+	}
+	slices, err := c.reconciler.Reconcile(ctx, claim)
+	if err == nil {
+		c.syncAllocation(claim.UID, claim.Status.Allocation)
+		err = c.applySlices(ctx, claim, slices)
+	}
+	// the outcome is visible in the status of the devices of the claim
+	if statusErr := c.updateStatus(ctx, claim, err); statusErr != nil {
+		klog.Errorf("Failed to update the status of ResourceClaim %s/%s: %v", claim.Namespace, claim.Name, statusErr)
+		if err == nil {
+			return statusErr
+		}
+	}
+	return err
+}
+
+// sliceOwner identifies the ResourceSlices of a claim, or the pools of the controller.
+type sliceOwner struct {
+	// label is set to value on the slices, and indexed by index.
+	label string
+	value string
+	index string
+	refs  []metav1.OwnerReference
+	// description is used in the logs.
+	description string
+}
+
+// applySlices creates or updates the desired ResourceSlices of the claim and deletes
+// the ones it does not want anymore.
+func (c *Controller) applySlices(ctx context.Context, claim *resourcev1.ResourceClaim, desired []*resourcev1.ResourceSlice) error {
+	return c.applyOwnedSlices(ctx, sliceOwner{
+		label: ClaimUIDLabel,
+		value: string(claim.UID),
+		index: claimUIDIndex,
+		refs: []metav1.OwnerReference{
+			*metav1.NewControllerRef(claim, resourcev1.SchemeGroupVersion.WithKind("ResourceClaim")),
+		},
+		description: fmt.Sprintf("claim %s/%s", claim.Namespace, claim.Name),
+	}, desired)
+}
+
+// applyOwnedSlices creates or updates the desired ResourceSlices of the owner and
+// deletes the ones it does not want anymore. The generation of a pool is increased
+// when any of its slices changes, so the consumers discard the old ones.
+func (c *Controller) applyOwnedSlices(ctx context.Context, owner sliceOwner, desired []*resourcev1.ResourceSlice) error {
+	// the cache may be behind, the writes of outdated slices fail and are retried
+	objs, err := c.sliceInformer.GetIndexer().ByIndex(owner.index, owner.value)
+	if err != nil {
+		return fmt.Errorf("failed to list ResourceSlices: %w", err)
+	}
+	existing := map[string]*resourcev1.ResourceSlice{}
+	for _, obj := range objs {
+		slice := obj.(*resourcev1.ResourceSlice)
+		// the other controllers can create slices for the same claim
+		if c.ownsSlice(slice) {
+			existing[slice.Name] = slice
+		}
+	}
+
+	pools := map[string][]*resourcev1.ResourceSlice{}
+	wanted := sets.New[string]()
+	for _, slice := range desired {
+		if slice == nil {
+			continue
+		}
+		pools[slice.Spec.Pool.Name] = append(pools[slice.Spec.Pool.Name], slice)
+		wanted.Insert(slice.Name)
+	}
+
+	// the pools that lose a slice change too
+	var stale []*resourcev1.ResourceSlice
+	changedPools := sets.New[string]()
+	for name, slice := range existing {
+		if !wanted.Has(name) {
+			stale = append(stale, slice)
+			changedPools.Insert(slice.Spec.Pool.Name)
+		}
+	}
+
+	var errs []error
+	for poolName, poolSlices := range pools {
+		generation := int64(0)
+		for _, slice := range poolSlices {
+			c.setNodeSelection(slice)
+			if slice.Labels == nil {
+				slice.Labels = map[string]string{}
+			}
+			slice.Labels[owner.label] = owner.value
+			slice.Labels[ControllerLabel] = c.controllerName
+			slice.SetOwnerReferences(owner.refs)
+			slice.Spec.Pool.ResourceSliceCount = int64(len(poolSlices))
+
+			old, ok := existing[slice.Name]
+			if !ok {
+				changedPools.Insert(poolName)
+				continue
+			}
+			generation = max(generation, old.Spec.Pool.Generation)
+			if !sliceEqual(old, slice) {
+				changedPools.Insert(poolName)
+			}
+		}
+		// a write that failed with an outdated cache can leave the pool with slices
+		// of different generations, that are not consistent
+		for _, slice := range poolSlices {
+			if old, ok := existing[slice.Name]; ok && old.Spec.Pool.Generation != generation {
+				changedPools.Insert(poolName)
+			}
+		}
+		if !changedPools.Has(poolName) {
+			continue
+		}
+
+		generation++
+		for _, slice := range poolSlices {
+			slice.Spec.Pool.Generation = generation
+			if err := c.createOrUpdateSlice(ctx, existing[slice.Name], slice); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	for _, slice := range stale {
+		err := c.kubeClient.ResourceV1().ResourceSlices().Delete(ctx, slice.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to delete ResourceSlice %s: %w", slice.Name, err))
+			continue
+		}
+		klog.Infof("Deleted ResourceSlice %s of %s", slice.Name, owner.description)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// createOrUpdateSlice creates the slice, or updates the existing one if it is not nil.
+func (c *Controller) createOrUpdateSlice(ctx context.Context, existing, slice *resourcev1.ResourceSlice) error {
+	if existing == nil {
+		_, err := c.kubeClient.ResourceV1().ResourceSlices().Create(ctx, slice, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to create ResourceSlice %s: %w", slice.Name, err)
+		}
+		klog.Infof("Created ResourceSlice %s", slice.Name)
+		return nil
+	}
+	// the update fails with a conflict if the slice changed since it was cached
+	slice.ResourceVersion = existing.ResourceVersion
+	_, err := c.kubeClient.ResourceV1().ResourceSlices().Update(ctx, slice, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update ResourceSlice %s: %w", slice.Name, err)
+	}
+	klog.Infof("Updated ResourceSlice %s to pool generation %d", slice.Name, slice.Spec.Pool.Generation)
+	return nil
+}
+
+// sliceEqual returns true if the existing slice has the content of the desired one,
+// the pool generation is ignored.
+func sliceEqual(existing, desired *resourcev1.ResourceSlice) bool {
+	for key, value := range desired.Labels {
+		if existing.Labels[key] != value {
+			return false
+		}
+	}
+	spec := existing.Spec.DeepCopy()
+	spec.Pool.Generation = desired.Spec.Pool.Generation
+	return apiequality.Semantic.DeepEqual(*spec, desired.Spec)
+}
+
+// isClaimRelevant checks if the DeviceClass of any request of the claim is relevant
+// for the reconciler, if it has devices of the drivers of the controller and if the
+// reconciler filters the claims, if it handles the claim. The
+// DeviceClasses that do not exist yet are not relevant, the
+// claims are reconciled again when they are created.
+func (c *Controller) isClaimRelevant(claim *resourcev1.ResourceClaim) (bool, error) {
+	if !c.isAllocatedByDrivers(claim) {
+		return false, nil
+	}
+	if filter, ok := c.reconciler.(ClaimFilter); ok && !filter.IsClaimRelevant(claim) {
+		return false, nil
+	}
+	for _, className := range claimDeviceClasses(claim) {
+		deviceClass, err := c.deviceClassLister.Get(className)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if c.reconciler.IsDeviceClassRelevant(deviceClass) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isAllocatedByDrivers returns true if the claim has devices of the drivers of the
+// controller, or if the controller handles the devices of all the drivers.
+func (c *Controller) isAllocatedByDrivers(claim *resourcev1.ResourceClaim) bool {
+	if c.drivers.Len() == 0 {
+		return true
+	}
+	if claim.Status.Allocation == nil {
+		return false
+	}
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if c.drivers.Has(result.Driver) {
+			return true
+		}
+	}
+	return false
+}
+
+// claimDeviceClasses returns the names of the DeviceClasses of the requests of the
+// claim, including the subrequests of the first available ones.
+func claimDeviceClasses(claim *resourcev1.ResourceClaim) []string {
+	classNames := sets.New[string]()
+	for _, request := range claim.Spec.Devices.Requests {
+		if request.Exactly != nil {
+			classNames.Insert(request.Exactly.DeviceClassName)
+		}
+		for _, subRequest := range request.FirstAvailable {
+			classNames.Insert(subRequest.DeviceClassName)
+		}
+	}
+	return sets.List(classNames)
+}
+
+// addIndexers adds the indexes that the informer does not have yet.
+func addIndexers(informer cache.SharedIndexInformer, indexers cache.Indexers) error {
+	existing := informer.GetIndexer().GetIndexers()
+	missing := cache.Indexers{}
+	for name, indexFunc := range indexers {
+		if _, ok := existing[name]; !ok {
+			missing[name] = indexFunc
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return informer.AddIndexers(missing)
+}
+
+// collectGarbage deletes the ResourceSlices created for claims that do not exist.
+// The owner references delete them too, but they are not set on the slices of older
+// versions and the garbage collector may not run.
+func (c *Controller) collectGarbage(ctx context.Context) {
+	claims := sets.New[types.UID]()
+	for _, obj := range c.claimInformer.GetIndexer().List() {
+		claims.Insert(obj.(*resourcev1.ResourceClaim).UID)
+	}
+
+	slices, err := c.sliceLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list ResourceSlices: %v", err)
+		return
+	}
+	for _, slice := range slices {
+		uid, ok := c.claimOfSlice(slice)
+		if !ok || claims.Has(uid) || !c.ownsSlice(slice) {
+			continue
+		}
+		// the slice may have been recreated since it was cached
+		err := c.kubeClient.ResourceV1().ResourceSlices().Delete(ctx, slice.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &slice.UID},
+		})
+		if err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+			klog.Errorf("Failed to delete orphaned ResourceSlice %s: %v", slice.Name, err)
+			continue
+		}
+		klog.Infof("Deleted orphaned ResourceSlice %s of claim %s", slice.Name, uid)
+	}
+}
+
+// ownsSlice returns true if the slice was created by the controller. The slices
+// without the ControllerLabel, of older versions, can be of any controller, they are
+// only owned if the reconciler identifies them as a SliceOwner.
+func (c *Controller) ownsSlice(slice *resourcev1.ResourceSlice) bool {
+	if controller, ok := slice.Labels[ControllerLabel]; ok {
+		return controller == c.controllerName
+	}
+	owner, ok := c.reconciler.(SliceOwner)
+	if !ok {
+		return false
+	}
+	_, ok = owner.ClaimOfSlice(slice)
+	return ok
+}
+
+// claimOfSlice returns the UID of the claim the slice was created for, or false if
+// it was not created by the controller.
+func (c *Controller) claimOfSlice(slice *resourcev1.ResourceSlice) (types.UID, bool) {
+	if uid, ok := slice.Labels[ClaimUIDLabel]; ok {
+		return types.UID(uid), true
+	}
+	if owner, ok := c.reconciler.(SliceOwner); ok {
+		return owner.ClaimOfSlice(slice)
 	}
+	return "", false
 }
@@ -0,0 +1,429 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	resourcev1 "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+// fakeReconciler fails the first reconciliations and deletions of every claim.
+type fakeReconciler struct {
+	mu             sync.Mutex
+	failures       int
+	attempts       map[string]int
+	deleteFailures int
+	deleteAttempts int
+	deleted        []string
+}
+
+func (r *fakeReconciler) IsDeviceClassRelevant(deviceClass *resourcev1.DeviceClass) bool {
+	return deviceClass.Name == "net"
+}
+
+func (r *fakeReconciler) Reconcile(ctx context.Context, claim *resourcev1.ResourceClaim) ([]*resourcev1.ResourceSlice, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts[claim.Name]++
+	if r.attempts[claim.Name] <= r.failures {
+		return nil, fmt.Errorf("transient error")
+	}
+	return []*resourcev1.ResourceSlice{testSlice("slice-"+claim.Name, claim.Name, "dev0")}, nil
+}
+
+// ClaimOfSlice identifies the slices of older versions by their name.
+func (r *fakeReconciler) ClaimOfSlice(slice *resourcev1.ResourceSlice) (types.UID, bool) {
+	uid, ok := strings.CutPrefix(slice.Name, "legacy-")
+	return types.UID(uid), ok
+}
+
+func (r *fakeReconciler) Delete(ctx context.Context, claim *resourcev1.ResourceClaim) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deleteAttempts++
+	if r.deleteAttempts <= r.deleteFailures {
+		return fmt.Errorf("transient error")
+	}
+	r.deleted = append(r.deleted, claim.Name)
+	return nil
+}
+
+func testSlice(name, pool string, devices ...string) *resourcev1.ResourceSlice {
+	slice := &resourcev1.ResourceSlice{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: resourcev1.ResourceSliceSpec{
+			Driver:   "net.example.com",
+			AllNodes: ptr.To(true),
+			Pool:     resourcev1.ResourcePool{Name: pool},
+		},
+	}
+	for _, device := range devices {
+		slice.Spec.Devices = append(slice.Spec.Devices, resourcev1.Device{Name: device})
+	}
+	return slice
+}
+
+func testClaim(name, className string) *resourcev1.ResourceClaim {
+	return &resourcev1.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec: resourcev1.ResourceClaimSpec{
+			Devices: resourcev1.DeviceClaim{
+				Requests: []resourcev1.DeviceRequest{{
+					Name:    "req",
+					Exactly: &resourcev1.ExactDeviceRequest{DeviceClassName: className},
+				}},
+			},
+		},
+		Status: resourcev1.ResourceClaimStatus{
+			Allocation: &resourcev1.AllocationResult{},
+		},
+	}
+}
+
+func TestControllerRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(
+		&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "net"}},
+		&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "gpu"}},
+		testClaim("claim1", "net"),
+		testClaim("claim2", "gpu"),
+	)
+	reconciler := &fakeReconciler{failures: 2, attempts: map[string]int{}}
+	c := NewController(client, reconciler, "test", WithWorkers(2))
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		_, err := client.ResourceV1().ResourceSlices().Get(ctx, "slice-claim1", metav1.GetOptions{})
+		return err == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("ResourceSlice not created after the retries: %v", err)
+	}
+	reconciler.mu.Lock()
+	if reconciler.attempts["claim1"] != 3 {
+		t.Errorf("expected 3 attempts, got %d", reconciler.attempts["claim1"])
+	}
+	if reconciler.attempts["claim2"] != 0 {
+		t.Errorf("the claim of an unrelated class was reconciled")
+	}
+	reconciler.mu.Unlock()
+
+	if err := client.ResourceV1().ResourceClaims("default").Delete(ctx, "claim1", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		reconciler.mu.Lock()
+		defer reconciler.mu.Unlock()
+		return len(reconciler.deleted) == 1 && reconciler.deleted[0] == "claim1", nil
+	})
+	if err != nil {
+		t.Fatalf("resources of the deleted claim not cleaned up: %v", err)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("controller did not stop")
+	}
+}
+
+// waitForSliceCache waits until the ResourceSlices in the cache are the ones stored.
+func waitForSliceCache(ctx context.Context, t *testing.T, c *Controller) {
+	t.Helper()
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		list, err := c.kubeClient.ResourceV1().ResourceSlices().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		cached := c.sliceInformer.GetIndexer().List()
+		if len(cached) != len(list.Items) {
+			return false, nil
+		}
+		for _, item := range list.Items {
+			slice, err := c.sliceLister.Get(item.Name)
+			if err != nil || slice.ResourceVersion != item.ResourceVersion {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("ResourceSlice cache not synced: %v", err)
+	}
+}
+
+func TestApplySlices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	claim := testClaim("claim1", "net")
+	client := fake.NewClientset()
+	c := NewController(client, &fakeReconciler{attempts: map[string]int{}}, "test")
+	c.informerFactory.Start(ctx.Done())
+	defer func() {
+		cancel()
+		c.informerFactory.Shutdown()
+	}()
+
+	getSlice := func(name string) *resourcev1.ResourceSlice {
+		t.Helper()
+		slice, err := client.ResourceV1().ResourceSlices().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get ResourceSlice %s: %v", name, err)
+		}
+		return slice
+	}
+
+	waitForSliceCache(ctx, t, c)
+	err := c.applySlices(ctx, claim, []*resourcev1.ResourceSlice{
+		testSlice("a", "pool", "dev0"),
+		testSlice("b", "pool", "dev1"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		slice := getSlice(name)
+		if slice.Spec.Pool.Generation != 1 || slice.Spec.Pool.ResourceSliceCount != 2 {
+			t.Errorf("unexpected pool of slice %s: %+v", name, slice.Spec.Pool)
+		}
+		if slice.Labels[ClaimUIDLabel] != string(claim.UID) || len(slice.OwnerReferences) != 1 {
+			t.Errorf("slice %s is not owned by the claim: %+v", name, slice.ObjectMeta)
+		}
+	}
+
+	// the same content does not change the pool
+	waitForSliceCache(ctx, t, c)
+	err = c.applySlices(ctx, claim, []*resourcev1.ResourceSlice{
+		testSlice("a", "pool", "dev0"),
+		testSlice("b", "pool", "dev1"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if generation := getSlice("a").Spec.Pool.Generation; generation != 1 {
+		t.Errorf("expected pool generation 1, got %d", generation)
+	}
+
+	// removing a slice bumps the generation of the remaining ones
+	waitForSliceCache(ctx, t, c)
+	err = c.applySlices(ctx, claim, []*resourcev1.ResourceSlice{
+		testSlice("a", "pool", "dev0"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	slice := getSlice("a")
+	if slice.Spec.Pool.Generation != 2 || slice.Spec.Pool.ResourceSliceCount != 1 {
+		t.Errorf("unexpected pool after removing a slice: %+v", slice.Spec.Pool)
+	}
+	if _, err := client.ResourceV1().ResourceSlices().Get(ctx, "b", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected the stale slice to be deleted")
+	}
+
+	// changing the devices bumps the generation
+	waitForSliceCache(ctx, t, c)
+	err = c.applySlices(ctx, claim, []*resourcev1.ResourceSlice{
+		testSlice("a", "pool", "dev0", "dev2"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	slice = getSlice("a")
+	if slice.Spec.Pool.Generation != 3 || len(slice.Spec.Devices) != 2 {
+		t.Errorf("unexpected slice after changing the devices: %+v", slice.Spec)
+	}
+}
+
+func TestControllerDeallocatedClaim(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(
+		&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "net"}},
+		testClaim("claim1", "net"),
+	)
+	c := NewController(client, &fakeReconciler{attempts: map[string]int{}}, "test")
+	go c.Run(ctx)
+
+	waitForSlice := func(exists bool) {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+			_, err := client.ResourceV1().ResourceSlices().Get(ctx, "slice-claim1", metav1.GetOptions{})
+			return (err == nil) == exists, nil
+		})
+		if err != nil {
+			t.Fatalf("expected ResourceSlice exists %v: %v", exists, err)
+		}
+	}
+	waitForSlice(true)
+
+	claim, err := client.ResourceV1().ResourceClaims("default").Get(ctx, "claim1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim.Status.Allocation = nil
+	if _, err := client.ResourceV1().ResourceClaims("default").UpdateStatus(ctx, claim, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForSlice(false)
+}
+
+func TestControllerDeviceClassEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	claim := testClaim("claim1", "")
+	claim.Spec.Devices.Requests[0].Exactly = nil
+	claim.Spec.Devices.Requests[0].FirstAvailable = []resourcev1.DeviceSubRequest{
+		{Name: "gpu", DeviceClassName: "gpu"},
+		{Name: "net", DeviceClassName: "net"},
+	}
+	client := fake.NewClientset(claim)
+	reconciler := &fakeReconciler{attempts: map[string]int{}}
+	c := NewController(client, reconciler, "test")
+	go c.Run(ctx)
+
+	waitForSlice := func() {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+			_, err := client.ResourceV1().ResourceSlices().Get(ctx, "slice-claim1", metav1.GetOptions{})
+			return err == nil, nil
+		})
+		if err != nil {
+			t.Fatalf("ResourceSlice not created: %v", err)
+		}
+	}
+
+	// the claim is reconciled when the DeviceClass of a subrequest is created
+	_, err := client.ResourceV1().DeviceClasses().Create(ctx, &resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "net"}}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSlice()
+
+	// the slices deleted by others are restored
+	if err := client.ResourceV1().ResourceSlices().Delete(ctx, "slice-claim1", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForSlice()
+}
+
+func TestClaimDeviceClasses(t *testing.T) {
+	claim := testClaim("claim1", "net")
+	claim.Spec.Devices.Requests = append(claim.Spec.Devices.Requests, resourcev1.DeviceRequest{
+		Name: "other",
+		FirstAvailable: []resourcev1.DeviceSubRequest{
+			{Name: "a", DeviceClassName: "gpu"},
+			{Name: "b", DeviceClassName: "net"},
+		},
+	})
+	got := claimDeviceClasses(claim)
+	if len(got) != 2 || got[0] != "gpu" || got[1] != "net" {
+		t.Errorf("unexpected DeviceClasses %v", got)
+	}
+}
+
+func TestControllerGarbageCollection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	labeled := func(name, uid, controller string) *resourcev1.ResourceSlice {
+		slice := testSlice(name, name, "dev0")
+		slice.Labels = map[string]string{ClaimUIDLabel: uid}
+		if controller != "" {
+			slice.Labels[ControllerLabel] = controller
+		}
+		return slice
+	}
+	// the slices of the claims without the ControllerLabel are only owned by the
+	// controller if its reconciler identifies them
+	client := fake.NewClientset(
+		&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "gpu"}},
+		testClaim("claim1", "gpu"),
+		labeled("live", "uid-claim1", "test"),
+		labeled("orphan", "uid-deleted", "test"),
+		labeled("other-controller", "uid-deleted", "other"),
+		labeled("unlabeled-controller", "uid-deleted", ""),
+		testSlice("legacy-uid-deleted", "legacy"),
+		testSlice("legacy-uid-claim1", "legacy"),
+		testSlice("unrelated", "unrelated"),
+	)
+	c := NewController(client, &fakeReconciler{attempts: map[string]int{}}, "test", WithGarbageCollectionInterval(time.Hour))
+	go c.Run(ctx)
+
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		list, err := client.ResourceV1().ResourceSlices().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		return len(list.Items) == 5, nil
+	})
+	if err != nil {
+		t.Fatalf("orphaned ResourceSlices not deleted: %v", err)
+	}
+	for _, name := range []string{"live", "other-controller", "unlabeled-controller", "legacy-uid-claim1", "unrelated"} {
+		if _, err := client.ResourceV1().ResourceSlices().Get(ctx, name, metav1.GetOptions{}); err != nil {
+			t.Errorf("ResourceSlice %s deleted: %v", name, err)
+		}
+	}
+}
+
+// fakeFilterReconciler only handles the claims with the handled prefix.
+type fakeFilterReconciler struct {
+	fakeReconciler
+}
+
+func (r *fakeFilterReconciler) IsClaimRelevant(claim *resourcev1.ResourceClaim) bool {
+	return strings.HasPrefix(claim.Name, "handled")
+}
+
+func TestControllerClaimFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(
+		&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "net"}},
+		testClaim("handled", "net"),
+		testClaim("ignored", "net"),
+	)
+	reconciler := &fakeFilterReconciler{fakeReconciler{attempts: map[string]int{}}}
+	c := NewController(client, reconciler, "test-filter")
+	go c.Run(ctx)
+
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		_, err := client.ResourceV1().ResourceSlices().Get(ctx, "slice-handled", metav1.GetOptions{})
+		return err == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("ResourceSlice of the handled claim not created: %v", err)
+	}
+	// the ignored claim is synced before the handled one is finished
+	time.Sleep(100 * time.Millisecond)
+	claim, err := client.ResourceV1().ResourceClaims("default").Get(ctx, "ignored", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claim.Finalizers) > 0 || len(claim.Status.Devices) > 0 {
+		t.Errorf("ignored claim modified: %+v", claim)
+	}
+	reconciler.mu.Lock()
+	defer reconciler.mu.Unlock()
+	if reconciler.attempts["ignored"] != 0 {
+		t.Errorf("ignored claim reconciled")
+	}
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	resourcev1 "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// WithFinalizer sets the finalizer added to the claims handled by the reconciler,
+// it defaults to knd.x-k8s.io/<controller name>.
+func WithFinalizer(finalizer string) Option {
+	return func(c *Controller) {
+		c.finalizer = finalizer
+	}
+}
+
+// addFinalizer adds the finalizer of the controller to the claim, so it is not
+// removed until the reconciler cleans up its resources. It returns the updated claim.
+func (c *Controller) addFinalizer(ctx context.Context, claim *resourcev1.ResourceClaim) (*resourcev1.ResourceClaim, error) {
+	if slices.Contains(claim.Finalizers, c.finalizer) {
+		return claim, nil
+	}
+	updated := claim.DeepCopy()
+	updated.Finalizers = append(updated.Finalizers, c.finalizer)
+	updated, err := c.kubeClient.ResourceV1().ResourceClaims(claim.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add finalizer to claim %s/%s: %w", claim.Namespace, claim.Name, err)
+	}
+	klog.V(2).Infof("Added finalizer %s to ResourceClaim %s/%s", c.finalizer, claim.Namespace, claim.Name)
+	return updated, nil
+}
+
+// finalize cleans up the resources of a claim being deleted and removes the
+// finalizer of the controller. The finalizer is kept if the reconciler fails, the
+// claim is retried with backoff.
+func (c *Controller) finalize(ctx context.Context, claim *resourcev1.ResourceClaim) error {
+	if !slices.Contains(claim.Finalizers, c.finalizer) {
+		return nil
+	}
+
+	// the cache may not have the claim without the finalizer yet
+	c.mu.Lock()
+	cleanedUp := c.cleanedUp.Has(claim.UID)
+	c.mu.Unlock()
+	if !cleanedUp {
+		klog.Infof("Deleting resources for ResourceClaim %s/%s", claim.Namespace, claim.Name)
+		start := time.Now()
+		err := c.reconciler.Delete(ctx, claim)
+		if err == nil {
+			c.syncAllocation(claim.UID, nil)
+			err = c.applySlices(ctx, claim, nil)
+		}
+		claimCleanupDuration.WithLabelValues(c.controllerName).Observe(time.Since(start).Seconds())
+		if err != nil {
+			claimCleanups.WithLabelValues(c.controllerName, "error").Inc()
+			return fmt.Errorf("failed to delete resources for claim %s/%s: %w", claim.Namespace, claim.Name, err)
+		}
+		claimCleanups.WithLabelValues(c.controllerName, "success").Inc()
+
+		// the claim is gone once the finalizer is removed, the delete event must not
+		// clean it up again
+		c.mu.Lock()
+		c.cleanedUp.Insert(claim.UID)
+		c.mu.Unlock()
+	}
+
+	updated := claim.DeepCopy()
+	updated.Finalizers = slices.DeleteFunc(updated.Finalizers, func(finalizer string) bool {
+		return finalizer == c.finalizer
+	})
+	_, err := c.kubeClient.ResourceV1().ResourceClaims(claim.Namespace).Update(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to remove finalizer from claim %s/%s: %w", claim.Namespace, claim.Name, err)
+	}
+	klog.V(2).Infof("Removed finalizer %s from ResourceClaim %s/%s", c.finalizer, claim.Namespace, claim.Name)
+	return nil
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	resourcev1 "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestControllerFinalizer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(
+		&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "net"}},
+		testClaim("claim1", "net"),
+	)
+	reconciler := &fakeReconciler{deleteFailures: 1, attempts: map[string]int{}}
+	failedCleanups := testutil.ToFloat64(claimCleanups.WithLabelValues("test-finalizer", "error"))
+	cleanups := testutil.ToFloat64(claimCleanups.WithLabelValues("test-finalizer", "success"))
+	c := NewController(client, reconciler, "test-finalizer")
+	go c.Run(ctx)
+
+	getClaim := func(ctx context.Context) (*resourcev1.ResourceClaim, error) {
+		return client.ResourceV1().ResourceClaims("default").Get(ctx, "claim1", metav1.GetOptions{})
+	}
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		claim, err := getClaim(ctx)
+		if err != nil {
+			return false, err
+		}
+		_, err = client.ResourceV1().ResourceSlices().Get(ctx, "slice-claim1", metav1.GetOptions{})
+		return err == nil && slices.Contains(claim.Finalizers, "knd.x-k8s.io/test-finalizer"), nil
+	})
+	if err != nil {
+		t.Fatalf("finalizer not added to the claim: %v", err)
+	}
+
+	// the API server only marks the claims with finalizers as deleted
+	claim, err := getClaim(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	if _, err := client.ResourceV1().ResourceClaims("default").Update(ctx, claim, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		claim, err := getClaim(ctx)
+		if err != nil {
+			return false, err
+		}
+		return !slices.Contains(claim.Finalizers, "knd.x-k8s.io/test-finalizer"), nil
+	})
+	if err != nil {
+		t.Fatalf("finalizer not removed from the claim: %v", err)
+	}
+	if _, err := client.ResourceV1().ResourceSlices().Get(ctx, "slice-claim1", metav1.GetOptions{}); err == nil {
+		t.Errorf("ResourceSlice of the claim not deleted")
+	}
+	if got := testutil.ToFloat64(claimCleanups.WithLabelValues("test-finalizer", "error")) - failedCleanups; got != 1 {
+		t.Errorf("expected 1 failed cleanup, got %v", got)
+	}
+	if got := testutil.ToFloat64(claimCleanups.WithLabelValues("test-finalizer", "success")) - cleanups; got != 1 {
+		t.Errorf("expected 1 successful cleanup, got %v", got)
+	}
+
+	// the delete event does not clean up the claim again
+	if err := client.ResourceV1().ResourceClaims("default").Delete(ctx, "claim1", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.deletedClaims) == 0 && c.cleanedUp.Len() == 0, nil
+	})
+	if err != nil {
+		t.Fatalf("delete event not handled: %v", err)
+	}
+	reconciler.mu.Lock()
+	defer reconciler.mu.Unlock()
+	if len(reconciler.deleted) != 1 || reconciler.deleteAttempts != 2 {
+		t.Errorf("expected one cleanup after one failure, got %d cleanups in %d attempts", len(reconciler.deleted), reconciler.deleteAttempts)
+	}
+}
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+)
+
+// LeaderElection configures the election of the replica that reconciles the claims,
+// through a Lease.
+type LeaderElection struct {
+	// Namespace and Name of the Lease.
+	Namespace string
+	Name      string
+	// Identity of the replica in the Lease, it defaults to the hostname.
+	Identity string
+	// LeaseDuration is the time the other replicas wait before taking over the Lease
+	// of a leader that does not renew it, it defaults to 15 seconds.
+	LeaseDuration time.Duration
+	// RenewDeadline is the time the leader retries renewing the Lease before giving
+	// up the leadership, it defaults to 10 seconds.
+	RenewDeadline time.Duration
+	// RetryPeriod is the interval of the attempts to acquire or renew the Lease, it
+	// defaults to 2 seconds.
+	RetryPeriod time.Duration
+}
+
+// WithLeaderElection runs the controller only while the replica holds the Lease, so
+// several replicas can run at the same time.
+func WithLeaderElection(config LeaderElection) Option {
+	return func(c *Controller) {
+		c.leaderElection = &config
+	}
+}
+
+// LeaderElectionFlags are the command line flags that configure the leader election
+// of the controllers.
+type LeaderElectionFlags struct {
+	enabled bool
+	config  LeaderElection
+}
+
+// AddLeaderElectionFlags registers the leader election flags in fs, the Lease is
+// named after name.
+func AddLeaderElectionFlags(fs *flag.FlagSet, name string) *LeaderElectionFlags {
+	f := &LeaderElectionFlags{config: LeaderElection{Name: name}}
+	fs.BoolVar(&f.enabled, "leader-elect", false, "Run only while holding the Lease, to run several replicas.")
+	fs.StringVar(&f.config.Namespace, "leader-elect-namespace", "kube-system", "Namespace of the leader election Lease.")
+	fs.StringVar(&f.config.Identity, "leader-elect-identity", "", "Identity of the replica in the leader election Lease, the hostname if empty.")
+	fs.DurationVar(&f.config.LeaseDuration, "leader-elect-lease-duration", defaultLeaseDuration, "Time the replicas wait before taking over a Lease that is not renewed.")
+	fs.DurationVar(&f.config.RenewDeadline, "leader-elect-renew-deadline", defaultRenewDeadline, "Time the leader retries renewing the Lease before giving up the leadership.")
+	fs.DurationVar(&f.config.RetryPeriod, "leader-elect-retry-period", defaultRetryPeriod, "Interval of the attempts to acquire or renew the Lease.")
+	return f
+}
+
+// LeaderElection returns the configuration of the leader election once the flags
+// are parsed, false if it is not enabled.
+func (f *LeaderElectionFlags) LeaderElection() (LeaderElection, bool) {
+	return f.config, f.enabled
+}
+
+// Ready returns true while the caches of the controller are synced, in the leader
+// and in the standby replicas, so the rollouts of the replicas do not wait for the
+// leadership.
+func (c *Controller) Ready() bool {
+	return c.synced.Load()
+}
+
+// Leading returns true while the controller reconciles the claims, it is the leader
+// or it runs without leader election. It is also exported as the leader metric.
+func (c *Controller) Leading() bool {
+	return c.leading.Load()
+}
+
+// Readyz is an HTTP handler that fails while the controller is not Ready.
+func (c *Controller) Readyz(w http.ResponseWriter, r *http.Request) {
+	if c.Ready() {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+// runLeaderElection runs the function while the replica is the leader. When the
+// context is done the Lease is released after the function returns, so other
+// replica takes over without waiting for it to expire. It exits the process if the
+// leadership is lost, the informers and the queues can not be started again.
+func runLeaderElection(ctx context.Context, kubeClient kubernetes.Interface, name string, config LeaderElection, run func(context.Context)) {
+	if config.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			klog.Fatalf("Failed to get the hostname for the leader election identity: %v", err)
+		}
+		config.Identity = hostname
+	}
+	if config.LeaseDuration == 0 {
+		config.LeaseDuration = defaultLeaseDuration
+	}
+	if config.RenewDeadline == 0 {
+		config.RenewDeadline = defaultRenewDeadline
+	}
+	if config.RetryPeriod == 0 {
+		config.RetryPeriod = defaultRetryPeriod
+	}
+
+	// the elector releases the Lease when its context is done, that happens once the
+	// leader stops or if it never becomes the leader
+	electorCtx, electorCancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer electorCancel()
+	var started atomic.Bool
+	stop := context.AfterFunc(ctx, func() {
+		if !started.Load() {
+			electorCancel()
+		}
+	})
+	defer stop()
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: config.Namespace, Name: config.Name},
+			Client:     kubeClient.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: config.Identity},
+		},
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				started.Store(true)
+				defer electorCancel()
+				runCtx, cancel := context.WithCancel(leaderCtx)
+				defer cancel()
+				stop := context.AfterFunc(ctx, cancel)
+				defer stop()
+				klog.Infof("Started leading Lease %s/%s as %s", config.Namespace, config.Name, config.Identity)
+				run(runCtx)
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() != nil {
+					klog.Infof("Released Lease %s/%s", config.Namespace, config.Name)
+					return
+				}
+				klog.Fatalf("Lost the leadership of Lease %s/%s", config.Namespace, config.Name)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != config.Identity {
+					klog.Infof("Lease %s/%s is held by %s", config.Namespace, config.Name, identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		klog.Fatalf("Failed to create the leader elector: %v", fmt.Errorf("invalid configuration: %w", err))
+	}
+	elector.Run(electorCtx)
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	resourcev1 "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestControllerLeaderElection(t *testing.T) {
+	client := fake.NewClientset(
+		&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "net"}},
+	)
+	// the leader functions run only in the leader
+	var running sync.Map
+	newReplica := func(identity string) (*Controller, context.CancelFunc, chan struct{}) {
+		ctx, cancel := context.WithCancel(context.Background())
+		c := NewController(client, &fakeReconciler{attempts: map[string]int{}}, "test",
+			WithLeaderFunc(func(ctx context.Context) {
+				running.Store(identity, true)
+				<-ctx.Done()
+				running.Delete(identity)
+			}),
+			WithLeaderElection(LeaderElection{
+				Namespace:     "kube-system",
+				Name:          "test",
+				Identity:      identity,
+				LeaseDuration: 2 * time.Second,
+				RenewDeadline: time.Second,
+				RetryPeriod:   100 * time.Millisecond,
+			}))
+		done := make(chan struct{})
+		go func() {
+			c.Run(ctx)
+			close(done)
+		}()
+		return c, cancel, done
+	}
+	waitFor := func(condition func() bool, message string) {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+			return condition(), nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", message, err)
+		}
+	}
+
+	first, cancelFirst, firstDone := newReplica("first")
+	waitFor(first.Leading, "first replica not leading")
+	second, cancelSecond, secondDone := newReplica("second")
+	defer func() {
+		cancelSecond()
+		<-secondDone
+	}()
+
+	// the standby replica syncs its caches and is ready, so the rollouts do not wait
+	// for the leadership
+	waitFor(second.Ready, "standby replica not ready")
+	time.Sleep(500 * time.Millisecond)
+	if second.Leading() {
+		t.Fatalf("both replicas are leading")
+	}
+	if _, ok := running.Load("second"); ok {
+		t.Errorf("leader function running in the standby replica")
+	}
+	recorder := httptest.NewRecorder()
+	second.Readyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected the standby replica ready, got status %d", recorder.Code)
+	}
+
+	// the Lease is released on shutdown, so the other replica does not wait for it
+	// to expire
+	cancelFirst()
+	select {
+	case <-firstDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("controller did not stop")
+	}
+	if first.Ready() || first.Leading() {
+		t.Errorf("stopped controller is ready or leading")
+	}
+	lease, err := client.CoordinationV1().Leases("kube-system").Get(context.Background(), "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity == "first" {
+		t.Errorf("Lease not released by the stopped leader")
+	}
+	waitFor(second.Leading, "standby replica did not take over")
+	waitFor(func() bool {
+		_, ok := running.Load("second")
+		return ok
+	}, "leader function not running in the new leader")
+	if got := testutil.ToFloat64(leader.WithLabelValues("test")); got != 1 {
+		t.Errorf("expected the leader metric 1, got %v", got)
+	}
+}
+
+func TestLeaderElectionFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := AddLeaderElectionFlags(fs, "test")
+	if _, ok := flags.LeaderElection(); ok {
+		t.Errorf("expected the leader election disabled by default")
+	}
+	if err := fs.Parse([]string{"--leader-elect", "--leader-elect-namespace=test-ns", "--leader-elect-lease-duration=30s"}); err != nil {
+		t.Fatal(err)
+	}
+	config, ok := flags.LeaderElection()
+	if !ok {
+		t.Fatalf("expected the leader election enabled")
+	}
+	want := LeaderElection{
+		Namespace:     "test-ns",
+		Name:          "test",
+		LeaseDuration: 30 * time.Second,
+		RenewDeadline: defaultRenewDeadline,
+		RetryPeriod:   defaultRetryPeriod,
+	}
+	if config != want {
+		t.Errorf("expected %+v, got %+v", want, config)
+	}
+}
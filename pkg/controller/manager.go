@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// ManagerOption configures the Manager.
+type ManagerOption func(*Manager)
+
+// WithManagerResyncPeriod sets the resync period of the shared informers, it
+// defaults to 10 minutes. The controllers resync their pools with their own period.
+func WithManagerResyncPeriod(period time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.resyncPeriod = period
+	}
+}
+
+// WithManagerLeaderElection runs all the controllers of the manager only while the
+// replica holds the Lease.
+func WithManagerLeaderElection(config LeaderElection) ManagerOption {
+	return func(m *Manager) {
+		m.leaderElection = &config
+	}
+}
+
+// Manager runs the controllers of several reconcilers in one process, with a single
+// informer factory shared by all of them. Every controller has its own queue,
+// workers, finalizer and metrics, and only handles the claims of its DeviceClasses
+// and drivers, so a reconciler that fails or is slow does not delay the claims of
+// the others.
+type Manager struct {
+	name            string
+	kubeClient      kubernetes.Interface
+	resyncPeriod    time.Duration
+	leaderElection  *LeaderElection
+	informerFactory informers.SharedInformerFactory
+
+	mu          sync.Mutex
+	started     bool
+	controllers []*Controller
+}
+
+// NewManager creates a manager without controllers, the name identifies it in the
+// logs and in the leader election.
+func NewManager(kubeClient kubernetes.Interface, name string, opts ...ManagerOption) *Manager {
+	m := &Manager{
+		name:         name,
+		kubeClient:   kubeClient,
+		resyncPeriod: defaultResyncPeriod,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.informerFactory = informers.NewSharedInformerFactory(kubeClient, m.resyncPeriod)
+	return m
+}
+
+// Register adds a controller for the reconciler, it must be called before Run. The
+// controller names must be unique, they identify the slices, the finalizer and the
+// metrics of each controller. The leader election is configured in the manager, and
+// the returned controller is run by the manager.
+func (m *Manager) Register(reconciler Reconciler, controllerName string, opts ...Option) (*Controller, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.started {
+		return nil, fmt.Errorf("failed to register controller %s: manager %s is running", controllerName, m.name)
+	}
+	for _, c := range m.controllers {
+		if c.controllerName == controllerName {
+			return nil, fmt.Errorf("failed to register controller %s: already registered", controllerName)
+		}
+	}
+	c := newController(m.kubeClient, m.informerFactory, reconciler, controllerName, opts...)
+	if c.leaderElection != nil {
+		return nil, fmt.Errorf("failed to register controller %s: the leader election is configured in the manager", controllerName)
+	}
+	m.controllers = append(m.controllers, c)
+	return c, nil
+}
+
+// Run starts the controllers, it blocks until the context is done. With leader
+// election the caches are synced in all the replicas and the controllers only
+// reconcile the claims while the replica is the leader.
+func (m *Manager) Run(ctx context.Context) {
+	m.mu.Lock()
+	m.started = true
+	m.mu.Unlock()
+
+	klog.Infof("Starting manager %s with %d controllers", m.name, len(m.controllers))
+	m.informerFactory.Start(ctx.Done())
+	defer m.informerFactory.Shutdown()
+
+	var synced []cache.InformerSynced
+	for _, c := range m.controllers {
+		synced = append(synced, c.cacheSyncs()...)
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		klog.Errorf("Failed to sync cache for manager: %s", m.name)
+		for _, c := range m.controllers {
+			c.queue.ShutDown()
+		}
+		return
+	}
+	setSynced := func(synced bool) {
+		for _, c := range m.controllers {
+			c.synced.Store(synced)
+		}
+	}
+	setSynced(true)
+	defer setSynced(false)
+	stop := context.AfterFunc(ctx, func() { setSynced(false) })
+	defer stop()
+
+	if m.leaderElection != nil {
+		runLeaderElection(ctx, m.kubeClient, m.name, *m.leaderElection, m.run)
+		return
+	}
+	m.run(ctx)
+}
+
+// run runs the workers of every controller until the context is done.
+func (m *Manager) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, c := range m.controllers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			klog.Infof("Starting controller: %s", c.controllerName)
+			c.runWorkers(ctx)
+		}()
+	}
+	wg.Wait()
+	klog.Infof("Shutting down manager: %s", m.name)
+}
+
+// Ready returns true if the caches of all the controllers are synced.
+func (m *Manager) Ready() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.controllers) == 0 {
+		return false
+	}
+	for _, c := range m.controllers {
+		if !c.Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// Readyz is an HTTP handler that fails while the manager is not Ready.
+func (m *Manager) Readyz(w http.ResponseWriter, r *http.Request) {
+	if m.Ready() {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	resourcev1 "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeDriverReconciler publishes a slice of its driver for every claim, or panics.
+type fakeDriverReconciler struct {
+	driver string
+	panics bool
+}
+
+func (r *fakeDriverReconciler) IsDeviceClassRelevant(deviceClass *resourcev1.DeviceClass) bool {
+	return deviceClass.Name == "net"
+}
+
+func (r *fakeDriverReconciler) Reconcile(ctx context.Context, claim *resourcev1.ResourceClaim) ([]*resourcev1.ResourceSlice, error) {
+	if r.panics {
+		panic("reconciler bug")
+	}
+	slice := testSlice(r.driver+"-"+claim.Name, r.driver+"-"+claim.Name, "dev0")
+	slice.Spec.Driver = r.driver
+	return []*resourcev1.ResourceSlice{slice}, nil
+}
+
+func (r *fakeDriverReconciler) Delete(ctx context.Context, claim *resourcev1.ResourceClaim) error {
+	return nil
+}
+
+// testDriversClaim returns a claim with a device of every driver.
+func testDriversClaim(name string, drivers ...string) *resourcev1.ResourceClaim {
+	claim := testClaim(name, "net")
+	for _, driver := range drivers {
+		claim.Status.Allocation.Devices.Results = append(claim.Status.Allocation.Devices.Results,
+			resourcev1.DeviceRequestAllocationResult{Request: "req", Driver: driver, Pool: "pool", Device: "dev0"})
+	}
+	return claim
+}
+
+func TestManager(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(
+		&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "net"}},
+		testDriversClaim("claim1", "a.example.com", "b.example.com"),
+		testDriversClaim("claim2", "a.example.com"),
+		testDriversClaim("claim3", "c.example.com"),
+	)
+	m := NewManager(client, "test-manager")
+	for _, driver := range []string{"a.example.com", "b.example.com"} {
+		if _, err := m.Register(&fakeDriverReconciler{driver: driver}, "test-"+driver, WithDrivers(driver)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Register(&fakeDriverReconciler{driver: "c.example.com", panics: true}, "test-c.example.com", WithDrivers("c.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Register(&fakeDriverReconciler{driver: "a.example.com"}, "test-a.example.com"); err == nil {
+		t.Errorf("expected an error registering a duplicate controller")
+	}
+	if _, err := m.Register(&fakeDriverReconciler{driver: "d.example.com"}, "test-d.example.com", WithLeaderElection(LeaderElection{Name: "test"})); err == nil {
+		t.Errorf("expected an error registering a controller with leader election")
+	}
+	panics := testutil.ToFloat64(reconcileErrors.WithLabelValues("test-c.example.com"))
+
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		return m.Ready(), nil
+	})
+	if err != nil {
+		t.Fatalf("manager not ready: %v", err)
+	}
+	recorder := httptest.NewRecorder()
+	m.Readyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected the manager ready, got status %d", recorder.Code)
+	}
+	if _, err := m.Register(&fakeDriverReconciler{driver: "d.example.com"}, "test-d.example.com"); err == nil {
+		t.Errorf("expected an error registering a controller in a running manager")
+	}
+
+	// the claims are routed by driver, the slices of the other controllers of a
+	// claim are not deleted
+	want := map[string]string{
+		"a.example.com-claim1": "test-a.example.com",
+		"b.example.com-claim1": "test-b.example.com",
+		"a.example.com-claim2": "test-a.example.com",
+	}
+	var list *resourcev1.ResourceSliceList
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		list, err = client.ResourceV1().ResourceSlices().List(ctx, metav1.ListOptions{})
+		if err != nil || len(list.Items) != len(want) {
+			return false, nil
+		}
+		for _, slice := range list.Items {
+			if want[slice.Name] != slice.Labels[ControllerLabel] {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("expected slices %v, got %+v: %v", want, list, err)
+	}
+
+	// the panics of a reconciler are retried as errors without stopping the others
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		return testutil.ToFloat64(reconcileErrors.WithLabelValues("test-c.example.com"))-panics >= 2, nil
+	})
+	if err != nil {
+		t.Errorf("expected the panics of the reconciler retried: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	list, err = client.ResourceV1().ResourceSlices().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != len(want) {
+		t.Errorf("expected %d slices, got %d", len(want), len(list.Items))
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("manager did not stop")
+	}
+	if m.Ready() {
+		t.Errorf("stopped manager is ready")
+	}
+}
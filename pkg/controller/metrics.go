@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const metricsNamespace = "knd_controller"
+
+var (
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of the reconciliations of the claims.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"controller"})
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Number of failed reconciliations of the claims.",
+	}, []string{"controller"})
+	leader = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "leader",
+		Help:      "1 while the controller reconciles the claims as the leader, 0 otherwise.",
+	}, []string{"controller"})
+	managedSlices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "resource_slices",
+		Help:      "Number of ResourceSlices managed by the controller.",
+	}, []string{"controller"})
+	claimCleanups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "claim_cleanups_total",
+		Help:      "Number of cleanups of the resources of deleted claims, by result.",
+	}, []string{"controller", "result"})
+	claimCleanupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "claim_cleanup_duration_seconds",
+		Help:      "Duration of the cleanups of the resources of deleted claims.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"controller"})
+
+	// the metrics of the queues are labeled with the controller name
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_depth",
+		Help:      "Number of claims waiting in the queue.",
+	}, []string{"controller"})
+	queueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_adds_total",
+		Help:      "Number of claims added to the queue.",
+	}, []string{"controller"})
+	queueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_latency_seconds",
+		Help:      "Time the claims wait in the queue before they are reconciled.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"controller"})
+	queueWorkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_work_duration_seconds",
+		Help:      "Time the claims take to be processed from the queue.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"controller"})
+	queueUnfinishedWork = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_unfinished_work_seconds",
+		Help:      "Time the claims being processed have been in progress.",
+	}, []string{"controller"})
+	queueLongestRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_longest_running_processor_seconds",
+		Help:      "Time the longest running claim has been in progress.",
+	}, []string{"controller"})
+	queueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_retries_total",
+		Help:      "Number of claims requeued after a failure.",
+	}, []string{"controller"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		reconcileDuration,
+		reconcileErrors,
+		leader,
+		managedSlices,
+		claimCleanups,
+		claimCleanupDuration,
+		queueDepth,
+		queueAdds,
+		queueLatency,
+		queueWorkDuration,
+		queueUnfinishedWork,
+		queueLongestRunning,
+		queueRetries,
+	)
+}
+
+// queueMetricsProvider implements workqueue.MetricsProvider, the name of the queues
+// is the controller name.
+type queueMetricsProvider struct{}
+
+var _ workqueue.MetricsProvider = queueMetricsProvider{}
+
+func (queueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return queueDepth.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return queueAdds.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return queueLatency.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return queueWorkDuration.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return queueUnfinishedWork.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return queueLongestRunning.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return queueRetries.WithLabelValues(name)
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	resourcev1 "k8s.io/api/resource/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// poolsKey is the queue key of the pools of a PoolReconciler, the claims keys
+// always have a namespace.
+const poolsKey = "pools"
+
+// PoolReconciler is implemented by the reconcilers that publish devices that are
+// not created for a claim, like the VLAN IDs of a fabric, that the scheduler
+// allocates to the claims.
+type PoolReconciler interface {
+	Reconciler
+
+	// Pools returns the desired ResourceSlices of the controller. The controller
+	// creates or updates them and deletes the ones that are not returned anymore, on
+	// every resync, after the allocation of a claim changes or the claim is deleted,
+	// and when the nodes change if the reconciler is network attached. The allocated
+	// claims in the cache are reconciled before the first sync, so the pools include
+	// the devices allocated while the controller was not running.
+	Pools(ctx context.Context) ([]*resourcev1.ResourceSlice, error)
+}
+
+// restoreAllocations reconciles the allocated claims in the cache before the
+// workers start, a PoolReconciler records their devices in memory. The slices of
+// the claims are applied by the workers, and the claims that fail are retried by
+// them.
+func (c *Controller) restoreAllocations(ctx context.Context) {
+	claims, err := c.claimLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list ResourceClaims: %v", err)
+		return
+	}
+	for _, claim := range claims {
+		if claim.Status.Allocation == nil {
+			continue
+		}
+		relevant, err := c.isClaimRelevant(claim)
+		if err != nil || !relevant {
+			continue
+		}
+		if _, err := c.reconciler.Reconcile(ctx, claim); err != nil {
+			klog.Errorf("Failed to restore the allocation of ResourceClaim %s/%s: %v", claim.Namespace, claim.Name, err)
+			continue
+		}
+		c.syncAllocation(claim.UID, claim.Status.Allocation)
+	}
+}
+
+// syncPools applies the ResourceSlices of the pools of the reconciler.
+func (c *Controller) syncPools(ctx context.Context) error {
+	reconciler, ok := c.reconciler.(PoolReconciler)
+	if !ok {
+		return nil
+	}
+	slices, err := reconciler.Pools(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the pools: %w", err)
+	}
+	return c.applyOwnedSlices(ctx, sliceOwner{
+		label:       ControllerLabel,
+		value:       c.controllerName,
+		index:       controllerIndex,
+		description: fmt.Sprintf("controller %s", c.controllerName),
+	}, slices)
+}
+
+// syncAllocation enqueues the pools if the allocation of the claim recorded by the
+// reconciler changed, the pools can depend on the devices allocated to the claims.
+// A nil allocation forgets the claim.
+func (c *Controller) syncAllocation(uid types.UID, allocation *resourcev1.AllocationResult) {
+	if _, ok := c.reconciler.(PoolReconciler); !ok {
+		return
+	}
+	c.mu.Lock()
+	old := c.allocations[uid]
+	if allocation == nil {
+		delete(c.allocations, uid)
+	} else {
+		c.allocations[uid] = allocation
+	}
+	c.mu.Unlock()
+	if !apiequality.Semantic.DeepEqual(old, allocation) {
+		c.enqueuePools()
+	}
+}
+
+// enqueuePools enqueues the pools of the reconciler, if it publishes any.
+func (c *Controller) enqueuePools() {
+	if _, ok := c.reconciler.(PoolReconciler); ok {
+		c.queue.Add(poolsKey)
+	}
+}
@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	resourcev1 "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// fakePoolReconciler publishes the slices of a pool that is not owned by a claim.
+type fakePoolReconciler struct {
+	fakeReconciler
+	poolsMu sync.Mutex
+	pools   []*resourcev1.ResourceSlice
+}
+
+func (r *fakePoolReconciler) Pools(ctx context.Context) ([]*resourcev1.ResourceSlice, error) {
+	r.poolsMu.Lock()
+	defer r.poolsMu.Unlock()
+	var slices []*resourcev1.ResourceSlice
+	for _, slice := range r.pools {
+		slices = append(slices, slice.DeepCopy())
+	}
+	return slices, nil
+}
+
+func (r *fakePoolReconciler) setPools(slices ...*resourcev1.ResourceSlice) {
+	r.poolsMu.Lock()
+	defer r.poolsMu.Unlock()
+	r.pools = slices
+}
+
+func TestControllerPools(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewClientset(
+		&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "net"}},
+		testClaim("claim1", "net"),
+	)
+	reconciler := &fakePoolReconciler{fakeReconciler: fakeReconciler{attempts: map[string]int{}}}
+	reconciler.setPools(testSlice("ids-0", "ids", "id0"), testSlice("ids-1", "ids", "id1"))
+	c := NewController(client, reconciler, "test")
+	go c.Run(ctx)
+
+	// the fake client does not detect the conflicts of the writes with an outdated
+	// cache, only the consistency of the pool is checked
+	waitForPool := func(names ...string) {
+		t.Helper()
+		var list *resourcev1.ResourceSliceList
+		err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+			var err error
+			list, err = client.ResourceV1().ResourceSlices().List(ctx, metav1.ListOptions{LabelSelector: ControllerLabel + "=test,!" + ClaimUIDLabel})
+			if err != nil || len(list.Items) != len(names) {
+				return false, nil
+			}
+			for i, slice := range list.Items {
+				if slice.Name != names[i] || slice.Spec.Pool.Generation == 0 ||
+					slice.Spec.Pool.Generation != list.Items[0].Spec.Pool.Generation ||
+					slice.Spec.Pool.ResourceSliceCount != int64(len(names)) {
+					return false, nil
+				}
+			}
+			return true, nil
+		})
+		if err != nil {
+			t.Fatalf("expected slices %v in a pool, got %+v: %v", names, list, err)
+		}
+	}
+
+	waitForPool("ids-0", "ids-1")
+	// the slices of the claims are not part of the pools
+	if _, err := client.ResourceV1().ResourceSlices().Get(ctx, "slice-claim1", metav1.GetOptions{}); err != nil {
+		t.Errorf("slice of the claim not created: %v", err)
+	}
+
+	reconciler.setPools(testSlice("ids-0", "ids", "id0"))
+	c.enqueuePools()
+	waitForPool("ids-0")
+
+	// the pools are restored if they are deleted
+	if err := client.ResourceV1().ResourceSlices().Delete(ctx, "ids-0", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForPool("ids-0")
+}
+
+func TestSyncAllocation(t *testing.T) {
+	client := fake.NewClientset()
+	c := NewController(client, &fakePoolReconciler{fakeReconciler: fakeReconciler{attempts: map[string]int{}}}, "test")
+	defer c.queue.ShutDown()
+
+	claim := testDriversClaim("claim1", "a.example.com")
+	reallocated := testDriversClaim("claim1", "b.example.com")
+	for _, step := range []struct {
+		description string
+		allocation  *resourcev1.AllocationResult
+		enqueued    bool
+	}{
+		{description: "allocated", allocation: claim.Status.Allocation, enqueued: true},
+		{description: "unchanged", allocation: claim.Status.Allocation.DeepCopy(), enqueued: false},
+		{description: "reallocated", allocation: reallocated.Status.Allocation, enqueued: true},
+		{description: "deleted", allocation: nil, enqueued: true},
+		{description: "deleted again", allocation: nil, enqueued: false},
+	} {
+		c.syncAllocation(claim.UID, step.allocation)
+		if enqueued := c.queue.Len() == 1; enqueued != step.enqueued {
+			t.Errorf("%s: expected the pools enqueued %v, got %v", step.description, step.enqueued, enqueued)
+		}
+		if c.queue.Len() > 0 {
+			key, _ := c.queue.Get()
+			c.queue.Done(key)
+		}
+	}
+
+	// the claims of the reconcilers without pools are not tracked
+	c = NewController(client, &fakeReconciler{attempts: map[string]int{}}, "test")
+	defer c.queue.ShutDown()
+	c.syncAllocation(claim.UID, claim.Status.Allocation)
+	if c.queue.Len() != 0 || len(c.allocations) != 0 {
+		t.Errorf("unexpected allocations %v", c.allocations)
+	}
+}
+
+func TestControllerRestoreAllocations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	unallocated := testClaim("claim3", "net")
+	unallocated.Status.Allocation = nil
+	client := fake.NewClientset(
+		&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "net"}},
+		testClaim("claim1", "net"),
+		testClaim("claim2", "gpu"),
+		unallocated,
+	)
+	reconciler := &fakePoolReconciler{fakeReconciler: fakeReconciler{attempts: map[string]int{}}}
+	c := NewController(client, reconciler, "test")
+	c.informerFactory.Start(ctx.Done())
+	defer func() {
+		cancel()
+		c.informerFactory.Shutdown()
+		c.queue.ShutDown()
+	}()
+	if !cache.WaitForCacheSync(ctx.Done(), c.cacheSyncs()...) {
+		t.Fatal("cache not synced")
+	}
+
+	// the allocated claims are reconciled before the first sync of the pools
+	c.restoreAllocations(ctx)
+	reconciler.mu.Lock()
+	defer reconciler.mu.Unlock()
+	for name, attempts := range map[string]int{"claim1": 1, "claim2": 0, "claim3": 0} {
+		if reconciler.attempts[name] != attempts {
+			t.Errorf("expected %d attempts for %s, got %d", attempts, name, reconciler.attempts[name])
+		}
+	}
+	if _, ok := c.allocations["uid-claim1"]; !ok || len(c.allocations) != 1 {
+		t.Errorf("unexpected allocations %v", c.allocations)
+	}
+}
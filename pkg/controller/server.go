@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+// StartHTTPServer serves the metrics, the health of the process and the readiness
+// of the controllers at address in the background. The readyz handler is the one
+// of the Controller or the Manager, the standby replicas are ready once their
+// caches are synced. It exits the process if the server fails.
+func StartHTTPServer(address string, readyz http.HandlerFunc) {
+	server := &http.Server{Addr: address, Handler: newHTTPHandler(readyz), ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			klog.Fatalf("Failed to listen and serve: %v", err)
+		}
+	}()
+}
+
+// newHTTPHandler returns the handler of the healthz, readyz and metrics endpoints.
+func newHTTPHandler(readyz http.HandlerFunc) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", readyz)
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	ready := false
+	handler := newHTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		if ready {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	for _, tt := range []struct {
+		path  string
+		ready bool
+		want  int
+	}{
+		{path: "/healthz", want: http.StatusOK},
+		{path: "/readyz", want: http.StatusServiceUnavailable},
+		{path: "/readyz", ready: true, want: http.StatusOK},
+		{path: "/metrics", want: http.StatusOK},
+		{path: "/unknown", want: http.StatusNotFound},
+	} {
+		ready = tt.ready
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if recorder.Code != tt.want {
+			t.Errorf("expected status %d for %s with ready %v, got %d", tt.want, tt.path, tt.ready, recorder.Code)
+		}
+	}
+}
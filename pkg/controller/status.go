@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	resourcev1 "k8s.io/api/resource/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// ReconciledCondition is the condition of the devices of the claims handled by the
+	// controller, it is false if the last reconciliation failed.
+	ReconciledCondition = "Reconciled"
+
+	reasonReconciled      = "Reconciled"
+	reasonReconcileFailed = "ReconcileFailed"
+
+	// maxConditionMessage bounds the size of the errors in the claim status.
+	maxConditionMessage = 1024
+)
+
+// updateStatus sets the Reconciled condition of the devices of the claim allocated
+// for the requests handled by the reconciler. The other conditions and the data of
+// the devices, set by the node drivers, are kept.
+func (c *Controller) updateStatus(ctx context.Context, claim *resourcev1.ResourceClaim, reconcileErr error) error {
+	condition := metav1.Condition{
+		Type:    ReconciledCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  reasonReconciled,
+		Message: fmt.Sprintf("Reconciled by %s", c.controllerName),
+	}
+	if reconcileErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonReconcileFailed
+		condition.Message = reconcileErr.Error()
+		if len(condition.Message) > maxConditionMessage {
+			condition.Message = condition.Message[:maxConditionMessage]
+		}
+	}
+
+	// the update is built from the cached claim, it is only read from the apiserver
+	// again if the cache is outdated
+	latest := claim
+	conflict := false
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if conflict {
+			var err error
+			latest, err = c.kubeClient.ResourceV1().ResourceClaims(claim.Namespace).Get(ctx, claim.Name, metav1.GetOptions{})
+			if err != nil {
+				if errors.IsNotFound(err) {
+					return nil
+				}
+				return err
+			}
+			if latest.UID != claim.UID || latest.Status.Allocation == nil {
+				return nil
+			}
+		}
+
+		updated := latest.DeepCopy()
+		condition.ObservedGeneration = updated.Generation
+		changed := false
+		for _, result := range c.relevantResults(updated) {
+			i := deviceStatusIndex(updated.Status.Devices, result)
+			if i < 0 {
+				updated.Status.Devices = append(updated.Status.Devices, resourcev1.AllocatedDeviceStatus{
+					Driver:  result.Driver,
+					Pool:    result.Pool,
+					Device:  result.Device,
+					ShareID: shareID(result.ShareID),
+				})
+				i = len(updated.Status.Devices) - 1
+			}
+			if meta.SetStatusCondition(&updated.Status.Devices[i].Conditions, condition) {
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		_, err := c.kubeClient.ResourceV1().ResourceClaims(updated.Namespace).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+		if err != nil {
+			conflict = errors.IsConflict(err)
+			return err
+		}
+		klog.V(2).Infof("Updated the %s condition of ResourceClaim %s/%s to %s", ReconciledCondition, claim.Namespace, claim.Name, condition.Status)
+		return nil
+	})
+}
+
+// devicesStatusUpdate returns true if the update of the claim only changed the
+// status of its devices, written by the controllers and the node drivers. The
+// resyncs, without changes, are not status updates.
+func devicesStatusUpdate(old, new interface{}) bool {
+	oldClaim, ok := old.(*resourcev1.ResourceClaim)
+	if !ok {
+		return false
+	}
+	newClaim, ok := new.(*resourcev1.ResourceClaim)
+	if !ok || oldClaim.ResourceVersion == newClaim.ResourceVersion {
+		return false
+	}
+	oldClaim, newClaim = oldClaim.DeepCopy(), newClaim.DeepCopy()
+	for _, claim := range []*resourcev1.ResourceClaim{oldClaim, newClaim} {
+		claim.ResourceVersion = ""
+		claim.ManagedFields = nil
+		claim.Status.Devices = nil
+	}
+	return apiequality.Semantic.DeepEqual(oldClaim, newClaim)
+}
+
+// relevantResults returns the allocated devices of the requests of the claim whose
+// DeviceClass is relevant for the reconciler, of the drivers of the controller.
+func (c *Controller) relevantResults(claim *resourcev1.ResourceClaim) []resourcev1.DeviceRequestAllocationResult {
+	var results []resourcev1.DeviceRequestAllocationResult
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if c.drivers.Len() > 0 && !c.drivers.Has(result.Driver) {
+			continue
+		}
+		className := requestDeviceClass(claim, result.Request)
+		if className == "" {
+			continue
+		}
+		deviceClass, err := c.deviceClassLister.Get(className)
+		if err != nil {
+			continue
+		}
+		if c.reconciler.IsDeviceClassRelevant(deviceClass) {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// requestDeviceClass returns the DeviceClass of the request of an allocation result,
+// the requests of the first available subrequests are named <request>/<subrequest>.
+func requestDeviceClass(claim *resourcev1.ResourceClaim, requestName string) string {
+	name, subRequestName, _ := strings.Cut(requestName, "/")
+	for _, request := range claim.Spec.Devices.Requests {
+		if request.Name != name {
+			continue
+		}
+		if subRequestName == "" {
+			if request.Exactly != nil {
+				return request.Exactly.DeviceClassName
+			}
+			return ""
+		}
+		for _, subRequest := range request.FirstAvailable {
+			if subRequest.Name == subRequestName {
+				return subRequest.DeviceClassName
+			}
+		}
+	}
+	return ""
+}
+
+// deviceStatusIndex returns the index of the status of the allocated device, or -1.
+func deviceStatusIndex(devices []resourcev1.AllocatedDeviceStatus, result resourcev1.DeviceRequestAllocationResult) int {
+	for i, device := range devices {
+		if device.Driver == result.Driver && device.Pool == result.Pool && device.Device == result.Device &&
+			ptr.Equal(device.ShareID, shareID(result.ShareID)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// shareID converts the share ID of an allocation result to the one of the status.
+func shareID(uid *types.UID) *string {
+	if uid == nil {
+		return nil
+	}
+	return ptr.To(string(*uid))
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	resourcev1 "k8s.io/api/resource/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestUpdateStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	claim := testClaim("claim1", "net")
+	claim.Spec.Devices.Requests = append(claim.Spec.Devices.Requests, resourcev1.DeviceRequest{
+		Name: "other",
+		FirstAvailable: []resourcev1.DeviceSubRequest{
+			{Name: "gpu", DeviceClassName: "gpu"},
+		},
+	})
+	claim.Status.Allocation.Devices.Results = []resourcev1.DeviceRequestAllocationResult{
+		{Request: "req", Driver: "net.example.com", Pool: "pool", Device: "dev0"},
+		{Request: "other/gpu", Driver: "gpu.example.com", Pool: "pool", Device: "dev1"},
+	}
+	// the data set by the node driver is kept
+	claim.Status.Devices = []resourcev1.AllocatedDeviceStatus{{
+		Driver:      "net.example.com",
+		Pool:        "pool",
+		Device:      "dev0",
+		NetworkData: &resourcev1.NetworkDeviceData{InterfaceName: "net1"},
+	}}
+	client := fake.NewClientset(
+		&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "net"}},
+		&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "gpu"}},
+		claim,
+	)
+	c := NewController(client, &fakeReconciler{attempts: map[string]int{}}, "test")
+	c.informerFactory.Start(ctx.Done())
+	defer func() {
+		cancel()
+		c.informerFactory.Shutdown()
+	}()
+	if !cache.WaitForCacheSync(ctx.Done(), c.deviceClassInformer.HasSynced) {
+		t.Fatal("cache not synced")
+	}
+
+	checkCondition := func(status metav1.ConditionStatus, reason string) {
+		t.Helper()
+		updated, err := client.ResourceV1().ResourceClaims("default").Get(ctx, "claim1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(updated.Status.Devices) != 1 {
+			t.Fatalf("expected the status of one device, got %+v", updated.Status.Devices)
+		}
+		device := updated.Status.Devices[0]
+		if device.NetworkData == nil || device.NetworkData.InterfaceName != "net1" {
+			t.Errorf("network data of the device not kept: %+v", device)
+		}
+		condition := meta.FindStatusCondition(device.Conditions, ReconciledCondition)
+		if condition == nil || condition.Status != status || condition.Reason != reason {
+			t.Errorf("expected condition %s with reason %s, got %+v", status, reason, condition)
+		}
+	}
+
+	if err := c.updateStatus(ctx, claim, errors.New("no addresses available")); err != nil {
+		t.Fatal(err)
+	}
+	checkCondition(metav1.ConditionFalse, reasonReconcileFailed)
+	if err := c.updateStatus(ctx, claim, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkCondition(metav1.ConditionTrue, reasonReconciled)
+
+	// the claim is read again after a conflict with an outdated cache
+	conflicts := 0
+	client.PrependReactor("update", "resourceclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "status" || conflicts > 0 {
+			return false, nil, nil
+		}
+		conflicts++
+		return true, nil, apierrors.NewConflict(resourcev1.Resource("resourceclaims"), "claim1", errors.New("outdated"))
+	})
+	if err := c.updateStatus(ctx, claim, errors.New("no addresses available")); err != nil {
+		t.Fatal(err)
+	}
+	if conflicts != 1 {
+		t.Errorf("expected one conflict, got %d", conflicts)
+	}
+	checkCondition(metav1.ConditionFalse, reasonReconcileFailed)
+}
+
+func TestDevicesStatusUpdate(t *testing.T) {
+	claim := testClaim("claim1", "net")
+	claim.ResourceVersion = "1"
+
+	withStatus := claim.DeepCopy()
+	withStatus.ResourceVersion = "2"
+	withStatus.Status.Devices = []resourcev1.AllocatedDeviceStatus{{Driver: "net.example.com", Pool: "pool", Device: "dev0"}}
+	if !devicesStatusUpdate(claim, withStatus) {
+		t.Errorf("expected an update of the status of the devices")
+	}
+	if devicesStatusUpdate(claim, claim) {
+		t.Errorf("expected the resync of the claim not to be a status update")
+	}
+
+	deallocated := withStatus.DeepCopy()
+	deallocated.ResourceVersion = "3"
+	deallocated.Status.Allocation = nil
+	if devicesStatusUpdate(withStatus, deallocated) {
+		t.Errorf("expected the deallocation of the claim not to be a status update")
+	}
+	deleted := withStatus.DeepCopy()
+	deleted.ResourceVersion = "3"
+	deleted.DeletionTimestamp = &metav1.Time{}
+	if devicesStatusUpdate(withStatus, deleted) {
+		t.Errorf("expected the deletion of the claim not to be a status update")
+	}
+}
+
+func TestRequestDeviceClass(t *testing.T) {
+	claim := testClaim("claim1", "net")
+	claim.Spec.Devices.Requests = append(claim.Spec.Devices.Requests, resourcev1.DeviceRequest{
+		Name: "other",
+		FirstAvailable: []resourcev1.DeviceSubRequest{
+			{Name: "a", DeviceClassName: "gpu"},
+			{Name: "b", DeviceClassName: "fpga"},
+		},
+	})
+	for request, want := range map[string]string{
+		"req":       "net",
+		"other/b":   "fpga",
+		"other":     "",
+		"missing":   "",
+		"req/other": "",
+	} {
+		if got := requestDeviceClass(claim, request); got != want {
+			t.Errorf("request %s: expected DeviceClass %q, got %q", request, want, got)
+		}
+	}
+}
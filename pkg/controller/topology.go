@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"maps"
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1"
+	"k8s.io/utils/ptr"
+)
+
+// NetworkAttachedReconciler is implemented by the reconcilers of devices attached to
+// a network reachable from a set of nodes, like the VLANs of a fabric, instead of a
+// single node.
+type NetworkAttachedReconciler interface {
+	Reconciler
+
+	// NodeLabels returns the labels of the nodes the devices of the slice are
+	// reachable from, or false if the slice is local to a node. The controller sets
+	// the NodeSelector of the slice, or AllNodes if there are no labels, so the nodes
+	// that join or leave the cluster, or change their labels, are selected by the
+	// scheduler without updating the slice.
+	NodeLabels(slice *resourcev1.ResourceSlice) (map[string]string, bool)
+}
+
+// setNodeSelection sets the nodes the devices of the slice are reachable from, if
+// the slice is network attached.
+func (c *Controller) setNodeSelection(slice *resourcev1.ResourceSlice) {
+	reconciler, ok := c.reconciler.(NetworkAttachedReconciler)
+	if !ok {
+		return
+	}
+	nodeLabels, ok := reconciler.NodeLabels(slice)
+	if !ok {
+		return
+	}
+
+	slice.Spec.NodeName = nil
+	slice.Spec.PerDeviceNodeSelection = nil
+	if len(nodeLabels) == 0 {
+		slice.Spec.AllNodes = ptr.To(true)
+		slice.Spec.NodeSelector = nil
+		return
+	}
+
+	term := v1.NodeSelectorTerm{}
+	for _, key := range slices.Sorted(maps.Keys(nodeLabels)) {
+		term.MatchExpressions = append(term.MatchExpressions, v1.NodeSelectorRequirement{
+			Key:      key,
+			Operator: v1.NodeSelectorOpIn,
+			Values:   []string{nodeLabels[key]},
+		})
+	}
+	slice.Spec.AllNodes = nil
+	slice.Spec.NodeSelector = &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{term}}
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+// fakeNetworkReconciler publishes the devices for the nodes with its labels.
+type fakeNetworkReconciler struct {
+	fakeReconciler
+	nodeLabels map[string]string
+}
+
+func (r *fakeNetworkReconciler) NodeLabels(slice *resourcev1.ResourceSlice) (map[string]string, bool) {
+	return r.nodeLabels, true
+}
+
+func testNode(name string, labels map[string]string) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestControllerNetworkAttachedSlices(t *testing.T) {
+	tests := []struct {
+		name       string
+		nodeLabels map[string]string
+		allNodes   bool
+	}{
+		{
+			name:       "nodes of the fabric",
+			nodeLabels: map[string]string{"fabric": "storage"},
+		},
+		{
+			name:     "all the nodes",
+			allNodes: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// all the nodes are on the fabric, the slice still selects them by
+			// their labels, so the nodes that join later are not selected
+			client := fake.NewClientset(
+				&resourcev1.DeviceClass{ObjectMeta: metav1.ObjectMeta{Name: "net"}},
+				testClaim("claim1", "net"),
+				testNode("node1", map[string]string{"fabric": "storage"}),
+			)
+			reconciler := &fakeNetworkReconciler{fakeReconciler: fakeReconciler{attempts: map[string]int{}}, nodeLabels: tt.nodeLabels}
+			c := NewController(client, reconciler, "test")
+			go c.Run(ctx)
+
+			var slice *resourcev1.ResourceSlice
+			err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+				var err error
+				slice, err = client.ResourceV1().ResourceSlices().Get(ctx, "slice-claim1", metav1.GetOptions{})
+				return err == nil, nil
+			})
+			if err != nil {
+				t.Fatalf("ResourceSlice not created: %v", err)
+			}
+			if slice.Spec.NodeName != nil {
+				t.Errorf("network attached slice with node name %s", *slice.Spec.NodeName)
+			}
+			if got := ptr.Deref(slice.Spec.AllNodes, false); got != tt.allNodes {
+				t.Errorf("expected AllNodes %v, got %v", tt.allNodes, got)
+			}
+			if tt.allNodes {
+				if slice.Spec.NodeSelector != nil {
+					t.Errorf("unexpected node selector with all nodes")
+				}
+				return
+			}
+			if slice.Spec.NodeSelector == nil || len(slice.Spec.NodeSelector.NodeSelectorTerms) != 1 {
+				t.Fatalf("unexpected node selector %+v", slice.Spec.NodeSelector)
+			}
+			requirement := slice.Spec.NodeSelector.NodeSelectorTerms[0].MatchExpressions[0]
+			if requirement.Key != "fabric" || requirement.Operator != v1.NodeSelectorOpIn || requirement.Values[0] != "storage" {
+				t.Errorf("unexpected node selector requirement %+v", requirement)
+			}
+		})
+	}
+}
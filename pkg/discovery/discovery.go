@@ -0,0 +1,254 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/dynamic-resource-allocation/deviceattribute"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+var (
+	// sysfsRoot is the mount point of sysfs, tests replace it with a fake tree.
+	sysfsRoot = "/sys"
+	// getPCIeRoot resolves the PCIe root complex of a PCI device, tests replace it.
+	getPCIeRoot = deviceattribute.GetPCIeRootAttributeByPCIBusID
+)
+
+// Interface is a network interface of the host with the properties discovered
+// from netlink and sysfs.
+type Interface struct {
+	// Name of the network interface.
+	Name string
+	// HardwareAddr of the network interface.
+	HardwareAddr string
+	// MTU of the network interface.
+	MTU int
+	// Type is the netlink link type, for example device, bond or vlan.
+	Type string
+	// Up is true if the interface is administratively up.
+	Up bool
+	// Loopback is true for loopback interfaces.
+	Loopback bool
+	// Virtual is true if the interface is not backed by a hardware device.
+	Virtual bool
+	// PCIAddress of the backing device, empty if it is not a PCI device.
+	PCIAddress string
+	// VendorID and DeviceID of the PCI device in hexadecimal, for example 15b3.
+	VendorID string
+	DeviceID string
+	// Driver is the kernel driver bound to the backing device.
+	Driver string
+	// Speed is the link speed in Mbps, 0 if it is unknown or the link is down.
+	Speed int64
+	// NUMANode of the backing device, -1 if it is unknown.
+	NUMANode int
+	// PCIeRoot is the PCIe root complex of the PCI device, for example pci0000:00.
+	PCIeRoot string
+	// SriovTotalVFs is the maximum number of VFs of an SR-IOV capable device, 0 otherwise.
+	SriovTotalVFs int
+	// SriovVF is true if the interface is an SR-IOV virtual function.
+	SriovVF bool
+	// RdmaDevice is the name of the associated RDMA device, if any.
+	RdmaDevice string
+	// Addresses are the IP addresses configured on the interface.
+	Addresses []net.IP
+	// DefaultRoute is true if a default route goes through the interface.
+	DefaultRoute bool
+}
+
+// Discover returns the network interfaces of the host namespace.
+func Discover() ([]Interface, error) {
+	links, err := netlink.LinkList()
+	// recover same behavior on vishvananda/netlink@1.2.1 and do not fail when the kernel returns NLM_F_DUMP_INTR.
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return nil, err
+	}
+
+	defaultRouteLinks, err := defaultRouteLinkIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := make([]Interface, 0, len(links))
+	for _, link := range links {
+		iface := newInterface(link)
+		iface.DefaultRoute = defaultRouteLinks.Has(link.Attrs().Index)
+		addresses, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+			return nil, fmt.Errorf("failed to list addresses of interface %s: %w", iface.Name, err)
+		}
+		for _, address := range addresses {
+			iface.Addresses = append(iface.Addresses, address.IP)
+		}
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces, nil
+}
+
+// defaultRouteLinkIndexes returns the indexes of the links used by the IPv4 and
+// IPv6 default routes of the main routing table.
+func defaultRouteLinkIndexes() (sets.Set[int], error) {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+	indexes := sets.New[int]()
+	for _, route := range routes {
+		if route.Dst != nil {
+			if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+		if route.LinkIndex > 0 {
+			indexes.Insert(route.LinkIndex)
+		}
+		for _, nh := range route.MultiPath {
+			indexes.Insert(nh.LinkIndex)
+		}
+	}
+	return indexes, nil
+}
+
+func newInterface(link netlink.Link) Interface {
+	attrs := link.Attrs()
+	iface := Interface{
+		Name:         attrs.Name,
+		HardwareAddr: attrs.HardwareAddr.String(),
+		MTU:          attrs.MTU,
+		Type:         link.Type(),
+		Up:           attrs.Flags&net.FlagUp != 0,
+		Loopback:     attrs.Flags&net.FlagLoopback != 0,
+		NUMANode:     -1,
+	}
+
+	netPath := filepath.Join(sysfsRoot, "class", "net", attrs.Name)
+	if target, err := filepath.EvalSymlinks(netPath); err == nil {
+		iface.Virtual = strings.Contains(target, "/devices/virtual/")
+	}
+
+	iface.Speed = LinkSpeed(attrs.Name)
+
+	devicePath := filepath.Join(netPath, "device")
+	if _, err := os.Stat(devicePath); err != nil {
+		// there is no backing device
+		return iface
+	}
+
+	iface.Driver = linkBase(filepath.Join(devicePath, "driver"))
+	if node, err := readInt(filepath.Join(devicePath, "numa_node")); err == nil {
+		iface.NUMANode = node
+	}
+	if totalVFs, err := readInt(filepath.Join(devicePath, "sriov_totalvfs")); err == nil {
+		iface.SriovTotalVFs = totalVFs
+	}
+	if _, err := os.Stat(filepath.Join(devicePath, "physfn")); err == nil {
+		iface.SriovVF = true
+	}
+	if entries, err := os.ReadDir(filepath.Join(devicePath, "infiniband")); err == nil && len(entries) > 0 {
+		iface.RdmaDevice = entries[0].Name()
+	}
+
+	if linkBase(filepath.Join(devicePath, "subsystem")) == "pci" {
+		iface.PCIAddress = linkBase(devicePath)
+		iface.VendorID = readHex(filepath.Join(devicePath, "vendor"))
+		iface.DeviceID = readHex(filepath.Join(devicePath, "device"))
+		if attr, err := getPCIeRoot(iface.PCIAddress); err == nil && attr.Value.StringValue != nil {
+			iface.PCIeRoot = *attr.Value.StringValue
+		} else if err != nil {
+			klog.V(4).Infof("Failed to get PCIe root for %s: %v", iface.PCIAddress, err)
+		}
+	}
+	return iface
+}
+
+// Device returns the DRA device representation of the interface.
+func (i Interface) Device() resourceapi.Device {
+	attributes := map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
+		"interface-name": {StringValue: ptr.To(i.Name)},
+		"mac-address":    {StringValue: ptr.To(i.HardwareAddr)},
+		"mtu":            {IntValue: ptr.To(int64(i.MTU))},
+		"virtual":        {BoolValue: ptr.To(i.Virtual)},
+		"sriov-capable":  {BoolValue: ptr.To(i.SriovTotalVFs > 0)},
+		"sriov-vf":       {BoolValue: ptr.To(i.SriovVF)},
+		"rdma-capable":   {BoolValue: ptr.To(i.RdmaDevice != "")},
+	}
+	if i.PCIAddress != "" {
+		attributes["pci-address"] = resourceapi.DeviceAttribute{StringValue: ptr.To(i.PCIAddress)}
+	}
+	if i.VendorID != "" {
+		attributes["vendor-id"] = resourceapi.DeviceAttribute{StringValue: ptr.To(i.VendorID)}
+	}
+	if i.DeviceID != "" {
+		attributes["device-id"] = resourceapi.DeviceAttribute{StringValue: ptr.To(i.DeviceID)}
+	}
+	if i.Driver != "" {
+		attributes["kernel-driver"] = resourceapi.DeviceAttribute{StringValue: ptr.To(i.Driver)}
+	}
+	if i.Speed > 0 {
+		attributes["link-speed"] = resourceapi.DeviceAttribute{IntValue: ptr.To(i.Speed)}
+	}
+	if i.NUMANode >= 0 {
+		attributes["numa-node"] = resourceapi.DeviceAttribute{IntValue: ptr.To(int64(i.NUMANode))}
+	}
+	if i.PCIeRoot != "" {
+		attributes[deviceattribute.StandardDeviceAttributePCIeRoot] = resourceapi.DeviceAttribute{StringValue: ptr.To(i.PCIeRoot)}
+	}
+	if i.SriovTotalVFs > 0 {
+		attributes["sriov-total-vfs"] = resourceapi.DeviceAttribute{IntValue: ptr.To(int64(i.SriovTotalVFs))}
+	}
+	if i.RdmaDevice != "" {
+		attributes["rdma-device"] = resourceapi.DeviceAttribute{StringValue: ptr.To(i.RdmaDevice)}
+	}
+	return resourceapi.Device{
+		Name:       i.Name,
+		Attributes: attributes,
+	}
+}
+
+// LinkSpeed returns the speed of the network interface in Mbps, 0 if it is unknown
+// or the link is down.
+func LinkSpeed(name string) int64 {
+	speed, err := readInt(filepath.Join(sysfsRoot, "class", "net", name, "speed"))
+	if err != nil || speed <= 0 {
+		return 0
+	}
+	return int64(speed)
+}
+
+func readInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// readHex reads a sysfs hexadecimal value like 0x15b3 and returns it without prefix.
+func readHex(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(data)), "0x")
+}
+
+// linkBase returns the last element of the path the symlink points to, or an
+// empty string if it can not be resolved.
+func linkBase(path string) string {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
@@ -0,0 +1,150 @@
+package discovery
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/dynamic-resource-allocation/deviceattribute"
+	"k8s.io/utils/ptr"
+)
+
+// fakeSysfs creates a sysfs tree with an SR-IOV and RDMA capable PCI interface
+// eth0 at 0000:01:00.0 and a virtual interface dummy0.
+func fakeSysfs(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+
+	mkdir := func(path string) {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path, content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlink := func(target, link string) {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mkdir(filepath.Join(root, "bus", "pci", "drivers", "mlx5_core"))
+	mkdir(filepath.Join(root, "class", "net"))
+
+	nic := filepath.Join(root, "devices", "pci0000:00", "0000:00:01.0", "0000:01:00.0")
+	mkdir(filepath.Join(nic, "net", "eth0"))
+	mkdir(filepath.Join(nic, "infiniband", "mlx5_0"))
+	write(filepath.Join(nic, "vendor"), "0x15b3\n")
+	write(filepath.Join(nic, "device"), "0x101d\n")
+	write(filepath.Join(nic, "numa_node"), "1\n")
+	write(filepath.Join(nic, "sriov_totalvfs"), "8\n")
+	write(filepath.Join(nic, "net", "eth0", "speed"), "100000\n")
+	symlink(filepath.Join(root, "bus", "pci", "drivers", "mlx5_core"), filepath.Join(nic, "driver"))
+	symlink(filepath.Join(root, "bus", "pci"), filepath.Join(nic, "subsystem"))
+	symlink(filepath.Join(nic, "net", "eth0"), filepath.Join(root, "class", "net", "eth0"))
+	symlink(nic, filepath.Join(nic, "net", "eth0", "device"))
+
+	virtual := filepath.Join(root, "devices", "virtual", "net", "dummy0")
+	mkdir(virtual)
+	write(filepath.Join(virtual, "speed"), "-1\n")
+	symlink(virtual, filepath.Join(root, "class", "net", "dummy0"))
+
+	return root
+}
+
+func TestNewInterface(t *testing.T) {
+	oldRoot, oldPCIeRoot := sysfsRoot, getPCIeRoot
+	sysfsRoot = fakeSysfs(t)
+	getPCIeRoot = func(pciBusID string) (deviceattribute.DeviceAttribute, error) {
+		return deviceattribute.DeviceAttribute{
+			Name:  deviceattribute.StandardDeviceAttributePCIeRoot,
+			Value: resourceapi.DeviceAttribute{StringValue: ptr.To("pci0000:00")},
+		}, nil
+	}
+	t.Cleanup(func() { sysfsRoot, getPCIeRoot = oldRoot, oldPCIeRoot })
+
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	tests := []struct {
+		name string
+		link netlink.Link
+		want Interface
+	}{
+		{
+			name: "pci device",
+			link: &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", MTU: 9000, HardwareAddr: mac, Flags: net.FlagUp}},
+			want: Interface{
+				Name:          "eth0",
+				HardwareAddr:  "02:00:00:00:00:01",
+				MTU:           9000,
+				Type:          "device",
+				Up:            true,
+				PCIAddress:    "0000:01:00.0",
+				VendorID:      "15b3",
+				DeviceID:      "101d",
+				Driver:        "mlx5_core",
+				Speed:         100000,
+				NUMANode:      1,
+				PCIeRoot:      "pci0000:00",
+				SriovTotalVFs: 8,
+				RdmaDevice:    "mlx5_0",
+			},
+		},
+		{
+			name: "virtual device",
+			link: &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "dummy0", MTU: 1500}},
+			want: Interface{
+				Name:     "dummy0",
+				MTU:      1500,
+				Type:     "dummy",
+				Virtual:  true,
+				NUMANode: -1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newInterface(tt.link)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newInterface() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterfaceDevice(t *testing.T) {
+	iface := Interface{
+		Name:          "eth0",
+		HardwareAddr:  "02:00:00:00:00:01",
+		MTU:           1500,
+		PCIAddress:    "0000:01:00.0",
+		Driver:        "ice",
+		NUMANode:      -1,
+		PCIeRoot:      "pci0000:00",
+		SriovTotalVFs: 4,
+	}
+	device := iface.Device()
+	if device.Name != "eth0" {
+		t.Errorf("expected device name eth0, got %s", device.Name)
+	}
+	for _, name := range []resourceapi.QualifiedName{"pci-address", "kernel-driver", "resource.kubernetes.io/pcieRoot", "sriov-total-vfs"} {
+		if _, ok := device.Attributes[name]; !ok {
+			t.Errorf("expected attribute %s", name)
+		}
+	}
+	for _, name := range []resourceapi.QualifiedName{"numa-node", "link-speed", "rdma-device", "vendor-id"} {
+		if _, ok := device.Attributes[name]; ok {
+			t.Errorf("unexpected attribute %s", name)
+		}
+	}
+	if v := device.Attributes["sriov-capable"].BoolValue; v == nil || !*v {
+		t.Errorf("expected sriov-capable to be true")
+	}
+}
@@ -0,0 +1,174 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Rule matches network interfaces, all the fields set must match for the rule to match.
+type Rule struct {
+	// Name is a regular expression matched against the interface name.
+	Name string `json:"name,omitempty"`
+	// Driver is the kernel driver bound to the backing device.
+	Driver string `json:"driver,omitempty"`
+	// PCIAddress of the backing device, for example 0000:01:00.0.
+	PCIAddress string `json:"pciAddress,omitempty"`
+	// HardwareAddr is the MAC address of the interface.
+	HardwareAddr string `json:"macAddress,omitempty"`
+	// DefaultRoute matches the interfaces that carry, or do not carry, a default route.
+	DefaultRoute *bool `json:"defaultRoute,omitempty"`
+
+	nameRegexp *regexp.Regexp
+}
+
+// Policy decides which network interfaces are published as devices.
+type Policy struct {
+	// Include rules, if any, select the interfaces that can be published.
+	Include []Rule `json:"include,omitempty"`
+	// Exclude rules remove interfaces from the ones selected by the Include rules.
+	Exclude []Rule `json:"exclude,omitempty"`
+	// IncludeDown publishes interfaces that are administratively down.
+	IncludeDown bool `json:"includeDown,omitempty"`
+}
+
+// DefaultPolicy excludes the interfaces created by the container runtimes and the CNI plugins.
+func DefaultPolicy() *Policy {
+	p := &Policy{
+		Exclude: []Rule{{Name: "^(veth|docker|cni)"}},
+	}
+	_ = p.Validate()
+	return p
+}
+
+// LoadPolicy reads a YAML or JSON policy from the file at path.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device policy %s: %w", path, err)
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy decodes and validates a YAML or JSON policy.
+func ParsePolicy(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("failed to decode device policy: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks the rules of the policy and compiles their regular expressions.
+func (p *Policy) Validate() error {
+	for _, rules := range [][]Rule{p.Include, p.Exclude} {
+		for i := range rules {
+			if err := rules[i].compile(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Rule) compile() error {
+	if r.Name == "" && r.Driver == "" && r.PCIAddress == "" && r.HardwareAddr == "" && r.DefaultRoute == nil {
+		return fmt.Errorf("device policy rule does not have any field set")
+	}
+	if r.HardwareAddr != "" {
+		mac, err := net.ParseMAC(r.HardwareAddr)
+		if err != nil {
+			return fmt.Errorf("invalid MAC address %q in device policy rule: %w", r.HardwareAddr, err)
+		}
+		r.HardwareAddr = mac.String()
+	}
+	if r.Name != "" {
+		re, err := regexp.Compile(r.Name)
+		if err != nil {
+			return fmt.Errorf("invalid name expression %q in device policy rule: %w", r.Name, err)
+		}
+		r.nameRegexp = re
+	}
+	return nil
+}
+
+func (r *Rule) matches(iface Interface) bool {
+	if r.nameRegexp != nil && !r.nameRegexp.MatchString(iface.Name) {
+		return false
+	}
+	if r.Driver != "" && r.Driver != iface.Driver {
+		return false
+	}
+	if r.PCIAddress != "" && !strings.EqualFold(r.PCIAddress, iface.PCIAddress) {
+		return false
+	}
+	if r.HardwareAddr != "" && r.HardwareAddr != iface.HardwareAddr {
+		return false
+	}
+	if r.DefaultRoute != nil && *r.DefaultRoute != iface.DefaultRoute {
+		return false
+	}
+	return true
+}
+
+// Allowed returns true if the interface can be published according to the policy.
+// Loopback interfaces and the interfaces used by the node to reach the cluster,
+// the ones holding a node IP or carrying the default route, are never allowed.
+func (p *Policy) Allowed(iface Interface, nodeIPs []net.IP) bool {
+	if iface.Loopback || Protected(iface, nodeIPs) {
+		return false
+	}
+	if !iface.Up && !p.IncludeDown {
+		return false
+	}
+	if len(p.Include) > 0 && !matchAny(p.Include, iface) {
+		return false
+	}
+	return !matchAny(p.Exclude, iface)
+}
+
+// Protected returns true if the interface holds one of the node IPs or carries the default route.
+func Protected(iface Interface, nodeIPs []net.IP) bool {
+	if iface.DefaultRoute {
+		return true
+	}
+	for _, address := range iface.Addresses {
+		for _, ip := range nodeIPs {
+			if address.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchAny(rules []Rule, iface Interface) bool {
+	for i := range rules {
+		if rules[i].matches(iface) {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeIPs returns the internal and external IPs reported on the node status.
+func NodeIPs(node *corev1.Node) []net.IP {
+	var ips []net.IP
+	for _, address := range node.Status.Addresses {
+		if address.Type != corev1.NodeInternalIP && address.Type != corev1.NodeExternalIP {
+			continue
+		}
+		if ip := net.ParseIP(address.Address); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
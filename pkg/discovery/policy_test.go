@@ -0,0 +1,99 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPolicyAllowed(t *testing.T) {
+	nodeIPs := []net.IP{net.ParseIP("192.168.1.10")}
+	eth0 := Interface{Name: "eth0", Up: true, Addresses: []net.IP{net.ParseIP("192.168.1.10")}}
+	eth1 := Interface{Name: "eth1", Up: true, Driver: "mlx5_core", PCIAddress: "0000:01:00.0", HardwareAddr: "02:00:00:00:00:01"}
+	eth2 := Interface{Name: "eth2", Up: true, Driver: "ice", DefaultRoute: true}
+	eth3 := Interface{Name: "eth3", Driver: "ice"}
+	veth := Interface{Name: "veth1234", Up: true}
+	lo := Interface{Name: "lo", Up: true, Loopback: true}
+
+	tests := []struct {
+		name   string
+		policy string
+		want   map[string]bool
+	}{
+		{
+			name:   "default policy",
+			policy: "",
+			want:   map[string]bool{"eth0": false, "eth1": true, "eth2": false, "eth3": false, "veth1234": false, "lo": false},
+		},
+		{
+			name: "include by driver",
+			policy: `
+include:
+- driver: ice
+includeDown: true
+`,
+			want: map[string]bool{"eth0": false, "eth1": false, "eth2": false, "eth3": true, "veth1234": false, "lo": false},
+		},
+		{
+			name: "exclude by PCI address and MAC",
+			policy: `
+exclude:
+- pciAddress: 0000:01:00.0
+  macAddress: 02:00:00:00:00:01
+`,
+			want: map[string]bool{"eth0": false, "eth1": false, "eth2": false, "eth3": false, "veth1234": true, "lo": false},
+		},
+		{
+			name:   "include default route is still protected",
+			policy: `{"include": [{"defaultRoute": true}, {"name": "^eth0$"}]}`,
+			want:   map[string]bool{"eth0": false, "eth1": false, "eth2": false, "eth3": false, "veth1234": false, "lo": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := DefaultPolicy()
+			if tt.policy != "" {
+				var err error
+				policy, err = ParsePolicy([]byte(tt.policy))
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			for _, iface := range []Interface{eth0, eth1, eth2, eth3, veth, lo} {
+				if got := policy.Allowed(iface, nodeIPs); got != tt.want[iface.Name] {
+					t.Errorf("Allowed(%s) = %v, want %v", iface.Name, got, tt.want[iface.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	for _, policy := range []string{
+		`include: [{}]`,
+		`exclude: [{name: "eth["}]`,
+		`exclude: [{macAddress: "not-a-mac"}]`,
+		`unknown: true`,
+	} {
+		if _, err := ParsePolicy([]byte(policy)); err == nil {
+			t.Errorf("expected error for policy %q", policy)
+		}
+	}
+}
+
+func TestNodeIPs(t *testing.T) {
+	node := &corev1.Node{
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeHostName, Address: "node0"},
+				{Type: corev1.NodeInternalIP, Address: "192.168.1.10"},
+				{Type: corev1.NodeInternalIP, Address: "fd00::10"},
+			},
+		},
+	}
+	ips := NodeIPs(node)
+	if len(ips) != 2 || !ips[0].Equal(net.ParseIP("192.168.1.10")) || !ips[1].Equal(net.ParseIP("fd00::10")) {
+		t.Errorf("unexpected node IPs %v", ips)
+	}
+}
@@ -0,0 +1,57 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	resourceapi "k8s.io/api/resource/v1"
+)
+
+// DecodeDeviceConfig decodes the opaque configuration that applies to the given
+// request of the claim into out. Configurations coming from the DeviceClass are
+// applied first and the ones from the claim afterwards, so the claim can override
+// the class defaults field by field. The request can be a subrequest in the form
+// <request>/<subrequest>, configuration for the parent request applies to it too.
+func DecodeDeviceConfig(claim *resourceapi.ResourceClaim, driverName, request string, out interface{}) error {
+	if claim.Status.Allocation == nil {
+		return nil
+	}
+
+	var fromClass, fromClaim []resourceapi.DeviceAllocationConfiguration
+	for _, config := range claim.Status.Allocation.Devices.Config {
+		if config.Opaque == nil || config.Opaque.Driver != driverName {
+			continue
+		}
+		if !configAppliesTo(config.Requests, request) {
+			continue
+		}
+		if config.Source == resourceapi.AllocationConfigSourceClass {
+			fromClass = append(fromClass, config)
+		} else {
+			fromClaim = append(fromClaim, config)
+		}
+	}
+
+	for _, config := range append(fromClass, fromClaim...) {
+		if len(config.Opaque.Parameters.Raw) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(config.Opaque.Parameters.Raw, out); err != nil {
+			return fmt.Errorf("failed to decode configuration for request %s of claim %s/%s: %w", request, claim.Namespace, claim.Name, err)
+		}
+	}
+	return nil
+}
+
+func configAppliesTo(requests []string, request string) bool {
+	if len(requests) == 0 {
+		return true
+	}
+	if slices.Contains(requests, request) {
+		return true
+	}
+	parent, _, found := strings.Cut(request, "/")
+	return found && slices.Contains(requests, parent)
+}
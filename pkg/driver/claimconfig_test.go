@@ -0,0 +1,76 @@
+package driver
+
+import (
+	"testing"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type testConfig struct {
+	Mode string `json:"mode,omitempty"`
+	MTU  int    `json:"mtu,omitempty"`
+}
+
+func opaqueConfig(source resourceapi.AllocationConfigSource, driverName string, requests []string, raw string) resourceapi.DeviceAllocationConfiguration {
+	return resourceapi.DeviceAllocationConfiguration{
+		Source:   source,
+		Requests: requests,
+		DeviceConfiguration: resourceapi.DeviceConfiguration{
+			Opaque: &resourceapi.OpaqueDeviceConfiguration{
+				Driver:     driverName,
+				Parameters: runtime.RawExtension{Raw: []byte(raw)},
+			},
+		},
+	}
+}
+
+func TestDecodeDeviceConfig(t *testing.T) {
+	claim := &resourceapi.ResourceClaim{
+		Status: resourceapi.ResourceClaimStatus{
+			Allocation: &resourceapi.AllocationResult{
+				Devices: resourceapi.DeviceAllocationResult{
+					Config: []resourceapi.DeviceAllocationConfiguration{
+						opaqueConfig(resourceapi.AllocationConfigSourceClaim, "test.k8s.io", nil, `{"mtu": 9000}`),
+						opaqueConfig(resourceapi.AllocationConfigSourceClass, "test.k8s.io", nil, `{"mode": "macvlan", "mtu": 1500}`),
+						opaqueConfig(resourceapi.AllocationConfigSourceClaim, "other.k8s.io", nil, `{"mode": "other"}`),
+						opaqueConfig(resourceapi.AllocationConfigSourceClaim, "test.k8s.io", []string{"net2"}, `{"mode": "ipvlan"}`),
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		request string
+		want    testConfig
+	}{
+		{
+			name:    "class defaults overridden by claim",
+			request: "net1",
+			want:    testConfig{Mode: "macvlan", MTU: 9000},
+		},
+		{
+			name:    "request specific configuration",
+			request: "net2",
+			want:    testConfig{Mode: "ipvlan", MTU: 9000},
+		},
+		{
+			name:    "subrequest inherits the parent request configuration",
+			request: "net2/fast",
+			want:    testConfig{Mode: "ipvlan", MTU: 9000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := testConfig{}
+			if err := DecodeDeviceConfig(claim, "test.k8s.io", tt.request, &got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DecodeDeviceConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
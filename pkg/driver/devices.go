@@ -0,0 +1,37 @@
+package driver
+
+import (
+	"fmt"
+
+	"github.com/containerd/nri/pkg/api"
+	"golang.org/x/sys/unix"
+)
+
+// LinuxDeviceFromPath returns the NRI representation of the device node at path,
+// so it can be returned by a ContainerDeviceProvider.
+func LinuxDeviceFromPath(path string) (*api.LinuxDevice, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return nil, fmt.Errorf("failed to stat device %s: %w", path, err)
+	}
+
+	var deviceType string
+	switch stat.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		deviceType = "c"
+	case unix.S_IFBLK:
+		deviceType = "b"
+	default:
+		return nil, fmt.Errorf("%s is not a device node", path)
+	}
+
+	return &api.LinuxDevice{
+		Path:     path,
+		Type:     deviceType,
+		Major:    int64(unix.Major(stat.Rdev)),
+		Minor:    int64(unix.Minor(stat.Rdev)),
+		FileMode: api.FileMode(stat.Mode &^ unix.S_IFMT),
+		Uid:      api.UInt32(stat.Uid),
+		Gid:      api.UInt32(stat.Gid),
+	}, nil
+}
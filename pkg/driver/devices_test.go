@@ -0,0 +1,20 @@
+package driver
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLinuxDeviceFromPath(t *testing.T) {
+	device, err := LinuxDeviceFromPath("/dev/null")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if device.Type != "c" || device.Major != 1 || device.Minor != 3 {
+		t.Errorf("unexpected device for /dev/null: %+v", device)
+	}
+
+	if _, err := LinuxDeviceFromPath(os.TempDir()); err == nil {
+		t.Errorf("expected error for a directory")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/containerd/nri/pkg/api"
 	resourceapi "k8s.io/api/resource/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
 )
 
@@ -27,7 +28,8 @@ type Driver interface {
 
 	// ConfigureDeviceForPod is called by the framework during the RunPodSandbox NRI hook.
 	// It should configure the device for use by the pod. The `preparedData` is the
-	// information that was returned by PrepareDevice.
+	// information that was returned by PrepareDevice. It is called without the lock of
+	// the shared state, so it can block, and it can run concurrently for several pods.
 	ConfigureDeviceForPod(device AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error
 
 	// CleanupDeviceForPod is called by the framework during the StopPodSandbox NRI hook.
@@ -39,19 +41,45 @@ type Driver interface {
 	HandleError(ctx context.Context, err error, msg string)
 }
 
+// ContainerDeviceProvider is an optional interface a Driver can implement to expose
+// host device nodes, like the char devices of an RDMA device, to the containers of
+// the pods using the allocated devices. It is called during the CreateContainer NRI hook.
+type ContainerDeviceProvider interface {
+	// ContainerDevices returns the device nodes to add to the containers using the
+	// device. The `preparedData` is the information that was returned by PrepareDevice.
+	ContainerDevices(device AllocatedDevice, preparedData interface{}) ([]*api.LinuxDevice, error)
+}
+
 // AllocatedDevice represents a network device that has been allocated to a pod.
 type AllocatedDevice struct {
 	Name       string
 	Attributes map[string]string
 	PoolName   string
 	Request    string
+	// ClaimUID is the UID of the ResourceClaim the device was allocated through.
+	ClaimUID types.UID
+	// ShareID identifies the allocation when the device allows multiple
+	// allocations, it is empty otherwise.
+	ShareID string
 }
 
 // SharedState is the data that is shared between the DRA and NRI hooks.
 // It is managed by the Plugin framework and passed to the driver's hooks.
 type SharedState struct {
-	// PodDeviceConfig maps a pod's UID to the devices that have been allocated to it.
-	PodDeviceConfig map[types.UID][]AllocatedDevice
-	// PreparedData maps a pod's UID to the data that was returned by the PrepareDevice hook.
-	PreparedData map[types.UID]interface{}
+	// PreparedClaims maps a claim's UID to the claim prepared on the node.
+	PreparedClaims map[types.UID]*PreparedClaim
+}
+
+// PreparedClaim is a claim prepared on the node. The devices of a pod are the ones of
+// the prepared claims reserved for it, they are looked up when the pod sandbox runs.
+type PreparedClaim struct {
+	Namespace string
+	Name      string
+	// Devices are the devices of the driver allocated to the claim, they are replaced
+	// when the claim is prepared again.
+	Devices []AllocatedDevice
+	// Data is the data that was returned by the PrepareDevice hook.
+	Data interface{}
+	// ReservedFor are the UIDs of the pods the claim is reserved for.
+	ReservedFor sets.Set[types.UID]
 }
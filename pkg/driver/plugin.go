@@ -3,26 +3,37 @@ package driver
 import (
 	"context"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/containerd/nri/pkg/api"
 	"github.com/containerd/nri/pkg/stub"
 
 	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+	"k8s.io/dynamic-resource-allocation/resourceclaim"
 	"k8s.io/dynamic-resource-allocation/resourceslice"
 	"k8s.io/klog/v2"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/config"
 )
 
 const (
-	maxAttempts        = 10
 	stabilityThreshold = 5 * time.Minute
 )
 
@@ -36,22 +47,68 @@ type Plugin struct {
 	nriPlugin  stub.Stub
 	driver     Driver
 
+	// nodeConfig is replaced on configuration reloads.
+	nodeConfig atomic.Pointer[config.NodeConfig]
+	// republish triggers a publication of the devices.
+	republish chan struct{}
+
+	// the pods of the node are watched to find the prepared claims they use.
+	informerFactory informers.SharedInformerFactory
+	podLister       corelisters.PodLister
+	podsSynced      cache.InformerSynced
+
 	mu          sync.Mutex
 	sharedState *SharedState
 }
 
+// Option configures the Plugin.
+type Option func(*Plugin)
+
+// WithNodeConfig sets the node configuration, it defaults to config.Default.
+func WithNodeConfig(c *config.NodeConfig) Option {
+	return func(p *Plugin) {
+		p.nodeConfig.Store(c)
+	}
+}
+
 // NewPlugin creates a new Plugin instance.
-func NewPlugin(driver Driver, driverName, nodeName string, kubeClient kubernetes.Interface) *Plugin {
-	return &Plugin{
+func NewPlugin(driver Driver, driverName, nodeName string, kubeClient kubernetes.Interface, opts ...Option) *Plugin {
+	p := &Plugin{
 		driverName: driverName,
 		nodeName:   nodeName,
 		kubeClient: kubeClient,
 		driver:     driver,
+		republish:  make(chan struct{}, 1),
 		sharedState: &SharedState{
-			PodDeviceConfig: make(map[types.UID][]AllocatedDevice),
-			PreparedData:    make(map[types.UID]interface{}),
+			PreparedClaims: make(map[types.UID]*PreparedClaim),
 		},
 	}
+	p.nodeConfig.Store(config.Default())
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.informerFactory = informers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		}))
+	p.podLister = p.informerFactory.Core().V1().Pods().Lister()
+	p.podsSynced = p.informerFactory.Core().V1().Pods().Informer().HasSynced
+	return p
+}
+
+// UpdateNodeConfig replaces the node configuration and republishes the devices.
+// The NRI plugin index and the timeouts are only used on Start.
+func (p *Plugin) UpdateNodeConfig(c *config.NodeConfig) {
+	p.nodeConfig.Store(c)
+	p.Republish()
+}
+
+// Republish publishes the devices without waiting for the next publish interval.
+func (p *Plugin) Republish() {
+	select {
+	case p.republish <- struct{}{}:
+	default:
+	}
 }
 
 // Start initializes and runs the DRA and NRI plugins.
@@ -72,16 +129,22 @@ func (p *Plugin) Start(ctx context.Context) error {
 	}
 	p.draPlugin = draHelper
 
-	if err := wait.PollUntilContextTimeout(ctx, 1*time.Second, 30*time.Second, true, func(context.Context) (bool, error) {
+	nodeConfig := p.nodeConfig.Load()
+	if err := wait.PollUntilContextTimeout(ctx, 1*time.Second, nodeConfig.Timeouts.Registration.Duration, true, func(context.Context) (bool, error) {
 		status := p.draPlugin.RegistrationStatus()
 		return status != nil && status.PluginRegistered, nil
 	}); err != nil {
 		return err
 	}
 
+	p.informerFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), p.podsSynced) {
+		return fmt.Errorf("failed to sync the pods of node %s", p.nodeName)
+	}
+
 	nriOptions := []stub.Option{
 		stub.WithPluginName(p.driverName),
-		stub.WithPluginIdx("10"),
+		stub.WithPluginIdx(nodeConfig.NRI.PluginIndex),
 		stub.WithOnClose(func() { klog.Infof("%s NRI plugin closed", p.driverName) }),
 	}
 	nriStub, err := stub.New(p, nriOptions...)
@@ -118,8 +181,15 @@ func (p *Plugin) PrepareResourceClaims(ctx context.Context, claims []*resourceap
 			results[claim.UID] = kubeletplugin.PrepareResult{Err: err}
 			continue
 		}
+		// kubelet can prepare the same claim again, the prepared claim is replaced
 		p.mu.Lock()
-		p.sharedState.PreparedData[claim.UID] = preparedData
+		p.sharedState.PreparedClaims[claim.UID] = &PreparedClaim{
+			Namespace:   claim.Namespace,
+			Name:        claim.Name,
+			Devices:     p.allocatedDevices(claim),
+			Data:        preparedData,
+			ReservedFor: reservedPods(claim),
+		}
 		p.mu.Unlock()
 		results[claim.UID] = kubeletplugin.PrepareResult{}
 	}
@@ -134,7 +204,7 @@ func (p *Plugin) UnprepareResourceClaims(ctx context.Context, claims []kubeletpl
 			errors[claim.UID] = err
 		}
 		p.mu.Lock()
-		delete(p.sharedState.PreparedData, claim.UID)
+		delete(p.sharedState.PreparedClaims, claim.UID)
 		p.mu.Unlock()
 	}
 	return errors, nil
@@ -159,14 +229,16 @@ func (p *Plugin) RunPodSandbox(ctx context.Context, pod *api.PodSandbox) error {
 		return fmt.Errorf("pod %s/%s has no network namespace", pod.Namespace, pod.Name)
 	}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.reservePodClaims(pod)
 
-	devices := p.sharedState.PodDeviceConfig[podUID]
-	preparedData := p.sharedState.PreparedData[podUID]
+	// the devices are configured without the lock, the configuration can block on
+	// the network, like the first DHCP exchange, and must not stall the other pods
+	p.mu.Lock()
+	devices := p.podDevices(podUID)
+	p.mu.Unlock()
 
 	for _, device := range devices {
-		if err := p.driver.ConfigureDeviceForPod(device, networkNamespace, pod, preparedData); err != nil {
+		if err := p.driver.ConfigureDeviceForPod(device.AllocatedDevice, networkNamespace, pod, device.data); err != nil {
 			return err
 		}
 	}
@@ -179,13 +251,11 @@ func (p *Plugin) StopPodSandbox(ctx context.Context, pod *api.PodSandbox) error
 	networkNamespace := getNetworkNamespace(pod)
 
 	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	devices := p.sharedState.PodDeviceConfig[podUID]
-	preparedData := p.sharedState.PreparedData[podUID]
+	devices := p.podDevices(podUID)
+	p.mu.Unlock()
 
 	for _, device := range devices {
-		if err := p.driver.CleanupDeviceForPod(device, networkNamespace, pod, preparedData); err != nil {
+		if err := p.driver.CleanupDeviceForPod(device.AllocatedDevice, networkNamespace, pod, device.data); err != nil {
 			klog.Errorf("failed to cleanup device %s for pod %s: %v", device.Name, pod.Name, err)
 		}
 	}
@@ -197,15 +267,17 @@ func (p *Plugin) RemovePodSandbox(ctx context.Context, pod *api.PodSandbox) erro
 	podUID := types.UID(pod.Uid)
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	delete(p.sharedState.PodDeviceConfig, podUID)
-	delete(p.sharedState.PreparedData, podUID)
+	for _, claim := range p.sharedState.PreparedClaims {
+		claim.ReservedFor.Delete(podUID)
+	}
 	return nil
 }
 
 // Helper functions
 func (p *Plugin) runNRIPlugin(ctx context.Context) {
 	attempt := 0
-	for attempt < maxAttempts {
+	nriConfig := p.nodeConfig.Load().NRI
+	for attempt < nriConfig.MaxRestarts {
 		startTime := time.Now()
 		if err := p.nriPlugin.Run(ctx); err != nil {
 			klog.Errorf("NRI plugin failed: %v", err)
@@ -221,38 +293,146 @@ func (p *Plugin) runNRIPlugin(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(5 * time.Second):
-			klog.Infof("Restarting NRI plugin (attempt %d/%d)", attempt, maxAttempts)
+		case <-time.After(nriConfig.RestartDelay.Duration):
+			klog.Infof("Restarting NRI plugin (attempt %d/%d)", attempt, nriConfig.MaxRestarts)
 		}
 	}
-	klog.Fatalf("NRI plugin failed to restart after %d attempts", maxAttempts)
+	klog.Fatalf("NRI plugin failed to restart after %d attempts", nriConfig.MaxRestarts)
 }
 
 func (p *Plugin) publishResources(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
+	interval := p.nodeConfig.Load().PublishInterval.Duration
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			devices, err := p.driver.GetDevices()
-			if err != nil {
-				klog.Errorf("failed to get devices: %v", err)
-				continue
-			}
-			resources := resourceslice.DriverResources{
-				Pools: map[string]resourceslice.Pool{
-					p.nodeName: {Slices: []resourceslice.Slice{{Devices: devices}}},
-				},
-			}
-			if err := p.draPlugin.PublishResources(ctx, resources); err != nil {
-				klog.Errorf("failed to publish resources: %v", err)
+		case <-p.republish:
+			// the publish interval may have changed with the configuration
+			if newInterval := p.nodeConfig.Load().PublishInterval.Duration; newInterval != interval {
+				interval = newInterval
+				ticker.Reset(interval)
 			}
 		}
+		devices, err := p.driver.GetDevices()
+		if err != nil {
+			klog.Errorf("failed to get devices: %v", err)
+			continue
+		}
+		resources := resourceslice.DriverResources{
+			Pools: map[string]resourceslice.Pool{
+				p.nodeName: {Slices: []resourceslice.Slice{{Devices: devices}}},
+			},
+		}
+		if err := p.draPlugin.PublishResources(ctx, resources); err != nil {
+			klog.Errorf("failed to publish resources: %v", err)
+		}
+	}
+}
+
+// allocatedDevices returns the devices allocated to the claim that belong to this driver.
+func (p *Plugin) allocatedDevices(claim *resourceapi.ResourceClaim) []AllocatedDevice {
+	if claim.Status.Allocation == nil {
+		return nil
+	}
+	var devices []AllocatedDevice
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if result.Driver != p.driverName {
+			continue
+		}
+		device := AllocatedDevice{
+			Name:     result.Device,
+			PoolName: result.Pool,
+			Request:  result.Request,
+			ClaimUID: claim.UID,
+		}
+		if result.ShareID != nil {
+			device.ShareID = string(*result.ShareID)
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+// reservedPods returns the UIDs of the pods the claim is reserved for.
+func reservedPods(claim *resourceapi.ResourceClaim) sets.Set[types.UID] {
+	pods := sets.New[types.UID]()
+	for _, consumer := range claim.Status.ReservedFor {
+		if consumer.Resource == "pods" && consumer.APIGroup == "" {
+			pods.Insert(consumer.UID)
+		}
+	}
+	return pods
+}
+
+// reservePodClaims reserves for the pod the prepared claims of its namespace that
+// it uses and were not reserved for it when they were prepared. kubelet does not
+// prepare a claim again for the pods added to it later, like the pods sharing a
+// claim. The claims of the pod are read from the cache of the pods of the node, and
+// only if there are prepared claims not reserved for it.
+func (p *Plugin) reservePodClaims(pod *api.PodSandbox) {
+	podUID := types.UID(pod.Uid)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending := false
+	for _, claim := range p.sharedState.PreparedClaims {
+		if claim.Namespace == pod.Namespace && !claim.ReservedFor.Has(podUID) {
+			pending = true
+			break
+		}
+	}
+	if !pending {
+		return
+	}
+
+	k8sPod, err := p.podLister.Pods(pod.Namespace).Get(pod.Name)
+	if err != nil {
+		klog.Errorf("Failed to get pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return
+	}
+	if k8sPod.UID != podUID {
+		return
+	}
+	claimNames := sets.New[string]()
+	for i := range k8sPod.Spec.ResourceClaims {
+		name, _, err := resourceclaim.Name(k8sPod, &k8sPod.Spec.ResourceClaims[i])
+		if err == nil && name != nil {
+			claimNames.Insert(*name)
+		}
+	}
+	for _, claim := range p.sharedState.PreparedClaims {
+		if claim.Namespace == pod.Namespace && claimNames.Has(claim.Name) {
+			claim.ReservedFor.Insert(podUID)
+		}
 	}
 }
 
+// podDevice is a device of a pod with the data prepared for its claim.
+type podDevice struct {
+	AllocatedDevice
+	data interface{}
+}
+
+// podDevices returns the devices of the prepared claims reserved for the pod, it
+// must be called with the lock held. The driver hooks are called with the returned
+// devices once the lock is released.
+func (p *Plugin) podDevices(podUID types.UID) []podDevice {
+	var devices []podDevice
+	for _, uid := range slices.Sorted(maps.Keys(p.sharedState.PreparedClaims)) {
+		claim := p.sharedState.PreparedClaims[uid]
+		if !claim.ReservedFor.Has(podUID) {
+			continue
+		}
+		for _, device := range claim.Devices {
+			devices = append(devices, podDevice{AllocatedDevice: device, data: claim.Data})
+		}
+	}
+	return devices
+}
+
 func getNetworkNamespace(pod *api.PodSandbox) string {
 	for _, ns := range pod.Linux.GetNamespaces() {
 		if ns.Type == "network" {
@@ -262,10 +442,31 @@ func getNetworkNamespace(pod *api.PodSandbox) string {
 	return ""
 }
 
-// Dummy implementations for NRI hooks that are not used by this framework.
-func (p *Plugin) CreateContainer(context.Context, *api.PodSandbox, *api.Container) (*api.ContainerAdjustment, []*api.ContainerUpdate, error) {
-	return nil, nil, nil
+func (p *Plugin) CreateContainer(ctx context.Context, pod *api.PodSandbox, ctr *api.Container) (*api.ContainerAdjustment, []*api.ContainerUpdate, error) {
+	provider, ok := p.driver.(ContainerDeviceProvider)
+	if !ok {
+		return nil, nil, nil
+	}
+	klog.V(2).Infof("CreateContainer called for container %s in pod %s/%s", ctr.Name, pod.Namespace, pod.Name)
+
+	p.mu.Lock()
+	devices := p.podDevices(types.UID(pod.Uid))
+	p.mu.Unlock()
+
+	adjust := &api.ContainerAdjustment{}
+	for _, device := range devices {
+		linuxDevices, err := provider.ContainerDevices(device.AllocatedDevice, device.data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get container devices for device %s: %w", device.Name, err)
+		}
+		for _, linuxDevice := range linuxDevices {
+			adjust.AddDevice(linuxDevice)
+		}
+	}
+	return adjust, nil, nil
 }
+
+// Dummy implementations for NRI hooks that are not used by this framework.
 func (p *Plugin) StartContainer(context.Context, *api.PodSandbox, *api.Container) error { return nil }
 func (p *Plugin) StopContainer(context.Context, *api.PodSandbox, *api.Container) ([]*api.ContainerUpdate, error) {
 	return nil, nil
@@ -0,0 +1,140 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/containerd/nri/pkg/api"
+	v1 "k8s.io/api/core/v1"
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/dynamic-resource-allocation/kubeletplugin"
+)
+
+// fakeDriver records the devices configured for the pods.
+type fakeDriver struct {
+	mu         sync.Mutex
+	configured map[string][]string
+}
+
+func (d *fakeDriver) GetDevices() ([]resourceapi.Device, error) { return nil, nil }
+
+func (d *fakeDriver) PrepareDevice(ctx context.Context, claim *resourceapi.ResourceClaim) (interface{}, error) {
+	return claim.Name, nil
+}
+
+func (d *fakeDriver) UnprepareDevice(ctx context.Context, claim kubeletplugin.NamespacedObject) error {
+	return nil
+}
+
+func (d *fakeDriver) ConfigureDeviceForPod(device AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.configured[podSandbox.Name] = append(d.configured[podSandbox.Name], device.Name)
+	return nil
+}
+
+func (d *fakeDriver) CleanupDeviceForPod(device AllocatedDevice, networkNamespace string, podSandbox *api.PodSandbox, preparedData interface{}) error {
+	return nil
+}
+
+func (d *fakeDriver) HandleError(ctx context.Context, err error, msg string) {}
+
+func testPreparedClaim(name string, pods ...string) *resourceapi.ResourceClaim {
+	claim := &resourceapi.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("uid-" + name)},
+		Status: resourceapi.ResourceClaimStatus{
+			Allocation: &resourceapi.AllocationResult{
+				Devices: resourceapi.DeviceAllocationResult{
+					Results: []resourceapi.DeviceRequestAllocationResult{
+						{Request: "req", Driver: "net.example.com", Pool: "node1", Device: "eth-" + name},
+						{Request: "req", Driver: "other.example.com", Pool: "node1", Device: "other"},
+					},
+				},
+			},
+		},
+	}
+	for _, pod := range pods {
+		claim.Status.ReservedFor = append(claim.Status.ReservedFor, resourceapi.ResourceClaimConsumerReference{
+			Resource: "pods", Name: pod, UID: types.UID("uid-" + pod),
+		})
+	}
+	return claim
+}
+
+func testPodSandbox(name string) *api.PodSandbox {
+	return &api.PodSandbox{
+		Name:      name,
+		Namespace: "default",
+		Uid:       "uid-" + name,
+		Linux: &api.LinuxPodSandbox{
+			Namespaces: []*api.LinuxNamespace{{Type: "network", Path: "/var/run/netns/" + name}},
+		},
+	}
+}
+
+// testPod returns a pod of the node using the claims.
+func testPod(name string, claims ...string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("uid-" + name)},
+		Spec:       v1.PodSpec{NodeName: "node1"},
+	}
+	for _, claim := range claims {
+		pod.Spec.ResourceClaims = append(pod.Spec.ResourceClaims, v1.PodResourceClaim{Name: claim, ResourceClaimName: &claim})
+	}
+	return pod
+}
+
+func TestPluginPodDevices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	claim := testPreparedClaim("claim1", "pod1")
+	// pod2 shares the claim, it is not reserved for it when the claim is prepared
+	client := fake.NewClientset(testPod("pod1", "claim1"), testPod("pod2", "claim1"), testPod("pod3"))
+	d := &fakeDriver{configured: map[string][]string{}}
+	p := NewPlugin(d, "net.example.com", "node1", client)
+	p.informerFactory.Start(ctx.Done())
+	p.informerFactory.WaitForCacheSync(ctx.Done())
+
+	// kubelet can prepare the same claim more than once
+	for i := 0; i < 2; i++ {
+		results, err := p.PrepareResourceClaims(ctx, []*resourceapi.ResourceClaim{claim})
+		if err != nil || results[claim.UID].Err != nil {
+			t.Fatalf("unexpected error: %v %v", err, results[claim.UID].Err)
+		}
+	}
+	if err := p.RunPodSandbox(ctx, testPodSandbox("pod1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.configured["pod1"]; len(got) != 1 || got[0] != "eth-claim1" {
+		t.Errorf("expected the device configured once, got %v", got)
+	}
+
+	// a pod added to the claim after it was prepared gets its devices
+	if err := p.RunPodSandbox(ctx, testPodSandbox("pod2")); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.configured["pod2"]; len(got) != 1 || got[0] != "eth-claim1" {
+		t.Errorf("expected the device configured for the new pod, got %v", got)
+	}
+
+	// the pods of other claims get no devices
+	if err := p.RunPodSandbox(ctx, testPodSandbox("pod3")); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.configured["pod3"]; len(got) != 0 {
+		t.Errorf("unexpected devices %v", got)
+	}
+
+	if _, err := p.UnprepareResourceClaims(ctx, []kubeletplugin.NamespacedObject{{UID: claim.UID}}); err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if devices := p.podDevices("uid-pod1"); len(devices) != 0 {
+		t.Errorf("unexpected devices after unprepare %v", devices)
+	}
+}
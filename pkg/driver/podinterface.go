@@ -0,0 +1,97 @@
+package driver
+
+import (
+	"fmt"
+
+	"k8s.io/klog/v2"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/config"
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+	kndnet "github.com/aojea/kubernetes-network-drivers/pkg/net"
+)
+
+// PodInterfaceConfig is the configuration of the interface inside the pod shared by
+// the drivers, it is embedded in their opaque configuration.
+type PodInterfaceConfig struct {
+	// Bandwidth limits the rates of the interface inside the pod with traffic control.
+	Bandwidth *kndnet.Bandwidth `json:"bandwidth,omitempty"`
+	// VRF enslaves the interface inside the pod to a VRF, so its routes are
+	// installed in the table of the VRF.
+	VRF *kndnet.VRF `json:"vrf,omitempty"`
+}
+
+// PodInterface is the validated configuration of the interface inside the pod.
+type PodInterface struct {
+	// bandwidth are the rate limits of the interface, nil if it is not limited.
+	bandwidth *kndnet.Bandwidth
+	// vrf is the VRF of the interface, nil if it uses the main routing table.
+	vrf *kndnet.VRF
+}
+
+// NewPodInterface validates the configuration of the interface inside the pod.
+func NewPodInterface(config PodInterfaceConfig) (PodInterface, error) {
+	var iface PodInterface
+	if !config.Bandwidth.Empty() {
+		if err := config.Bandwidth.Validate(); err != nil {
+			return PodInterface{}, fmt.Errorf("invalid bandwidth configuration: %w", err)
+		}
+		iface.bandwidth = config.Bandwidth
+	}
+	if config.VRF != nil {
+		if err := config.VRF.Validate(); err != nil {
+			return PodInterface{}, fmt.Errorf("invalid VRF configuration: %w", err)
+		}
+		iface.vrf = config.VRF
+	}
+	return iface, nil
+}
+
+// Configure sets up the interface ifName once it is inside the namespace at
+// networkNamespace: it is enslaved to its VRF before the routes of the addresses
+// are installed, so they go to the table of the VRF, and then its rates are
+// limited. The addresses are announced in the background if enabled, so the peers
+// do not keep sending the traffic to their previous owner.
+func (p PodInterface) Configure(networkNamespace, ifName string, addresses *ipam.Result, announce config.AnnounceConfig) error {
+	if p.vrf != nil {
+		if err := kndnet.NsSetVRF(networkNamespace, ifName, *p.vrf); err != nil {
+			return err
+		}
+	}
+	if addresses != nil {
+		if err := kndnet.NsAddRoutes(networkNamespace, ifName, addresses.Routes); err != nil {
+			return err
+		}
+	}
+	if p.bandwidth != nil {
+		applied, err := kndnet.NsSetBandwidth(networkNamespace, ifName, *p.bandwidth)
+		if err != nil {
+			return err
+		}
+		klog.Infof("Limited interface %q in network namespace %s: %s", ifName, networkNamespace, applied)
+	}
+	if addresses != nil && announce.Enabled() {
+		go func() {
+			if err := kndnet.NsAnnounceAddresses(networkNamespace, ifName, *announce.Count, announce.Interval.Duration); err != nil {
+				klog.Errorf("failed to announce the addresses of interface %s: %v", ifName, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// Cleanup removes the VRF and the rate limits of the interface ifName, before it
+// is deleted or moved back to the host. The IFB device shaping the ingress traffic
+// is not deleted with the interface, and the qdiscs would be moved with it. The
+// errors are logged, so the interface is released anyway.
+func (p PodInterface) Cleanup(networkNamespace, ifName string) {
+	if p.vrf != nil {
+		if err := kndnet.NsRemoveVRF(networkNamespace, ifName); err != nil {
+			klog.Errorf("failed to remove the VRF of interface %s: %v", ifName, err)
+		}
+	}
+	if p.bandwidth != nil {
+		if err := kndnet.NsRemoveBandwidth(networkNamespace, ifName); err != nil {
+			klog.Errorf("failed to remove the bandwidth limits of interface %s: %v", ifName, err)
+		}
+	}
+}
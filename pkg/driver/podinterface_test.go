@@ -0,0 +1,77 @@
+package driver
+
+import (
+	"testing"
+
+	resourceapi "k8s.io/api/resource/v1"
+)
+
+type testInterfaceConfig struct {
+	MTU int `json:"mtu,omitempty"`
+
+	PodInterfaceConfig `json:",inline"`
+}
+
+func TestNewPodInterface(t *testing.T) {
+	tests := []struct {
+		name          string
+		raw           string
+		wantVRF       bool
+		wantBandwidth bool
+		wantErr       bool
+	}{
+		{
+			name: "no configuration",
+			raw:  `{"mtu": 1500}`,
+		},
+		{
+			name:          "VRF and bandwidth",
+			raw:           `{"vrf": {"name": "vrf-blue", "table": 100}, "bandwidth": {"egressRate": "100M"}}`,
+			wantVRF:       true,
+			wantBandwidth: true,
+		},
+		{
+			name: "empty bandwidth",
+			raw:  `{"bandwidth": {}}`,
+		},
+		{
+			name:    "VRF in the main table",
+			raw:     `{"vrf": {"name": "vrf-blue", "table": 254}}`,
+			wantErr: true,
+		},
+		{
+			name:    "burst without rate",
+			raw:     `{"bandwidth": {"ingressBurst": "64Ki", "egressRate": "100M"}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claim := &resourceapi.ResourceClaim{
+				Status: resourceapi.ResourceClaimStatus{
+					Allocation: &resourceapi.AllocationResult{
+						Devices: resourceapi.DeviceAllocationResult{
+							Config: []resourceapi.DeviceAllocationConfiguration{
+								opaqueConfig(resourceapi.AllocationConfigSourceClaim, "test.k8s.io", nil, tt.raw),
+							},
+						},
+					},
+				},
+			}
+			config := testInterfaceConfig{}
+			if err := DecodeDeviceConfig(claim, "test.k8s.io", "net1", &config); err != nil {
+				t.Fatal(err)
+			}
+			iface, err := NewPodInterface(config.PodInterfaceConfig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewPodInterface() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (iface.vrf != nil) != tt.wantVRF {
+				t.Errorf("expected VRF %v, got %+v", tt.wantVRF, iface.vrf)
+			}
+			if (iface.bandwidth != nil) != tt.wantBandwidth {
+				t.Errorf("expected bandwidth %v, got %+v", tt.wantBandwidth, iface.bandwidth)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package ipam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// stateVersion is the version of the format of the state file.
+const stateVersion = 1
+
+// Result is the outcome of the IPAM of an interface.
+type Result struct {
+	// Addresses to configure on the interface.
+	Addresses []netip.Prefix `json:"addresses,omitempty"`
+	// Gateways of the addresses, at most one per IP family.
+	Gateways []netip.Addr `json:"gateways,omitempty"`
+	// Routes to add through the interface.
+	Routes []ResolvedRoute `json:"routes,omitempty"`
+}
+
+// ResolvedRoute is a route with its gateway resolved.
+type ResolvedRoute struct {
+	// Destination of the route.
+	Destination netip.Prefix `json:"destination"`
+	// Gateway of the route, it is not valid for directly connected destinations.
+	Gateway netip.Addr `json:"gateway"`
+}
+
+// IPNets returns the addresses in the format used by the netlink helpers.
+func (r *Result) IPNets() []*net.IPNet {
+	if r == nil {
+		return nil
+	}
+	ipnets := make([]*net.IPNet, 0, len(r.Addresses))
+	for _, prefix := range r.Addresses {
+		ipnets = append(ipnets, &net.IPNet{
+			IP:   prefix.Addr().AsSlice(),
+			Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+		})
+	}
+	return ipnets
+}
+
+// state is the content of the state file.
+type state struct {
+	Version int `json:"version"`
+	// Claims maps the claim UIDs to the results of their interfaces, indexed by a
+	// key unique inside the claim.
+	Claims map[types.UID]map[string]*Result `json:"claims"`
+}
+
+// Allocator assigns the addresses of the interfaces and keeps them in a state
+// file, so they survive restarts of the driver and are not handed out twice.
+type Allocator struct {
+	path string
+
+	mu    sync.Mutex
+	state state
+}
+
+// NewAllocator returns an Allocator that stores its state in the file at path,
+// the existing allocations are loaded from it.
+func NewAllocator(path string) (*Allocator, error) {
+	a := &Allocator{
+		path: path,
+		state: state{
+			Version: stateVersion,
+			Claims:  map[types.UID]map[string]*Result{},
+		},
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create IPAM state directory: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IPAM state %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &a.state); err != nil {
+		return nil, fmt.Errorf("failed to decode IPAM state %s: %w", path, err)
+	}
+	if a.state.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported IPAM state version %d", a.state.Version)
+	}
+	if a.state.Claims == nil {
+		a.state.Claims = map[types.UID]map[string]*Result{}
+	}
+	return a, nil
+}
+
+// Allocate returns the addresses and routes of the interface identified by key
+// of the claim. The allocation is idempotent, the result of a previous call for
+// the same claim and key is returned unchanged.
+func (a *Allocator) Allocate(claimUID types.UID, key string, config Config) (*Result, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if result, ok := a.state.Claims[claimUID][key]; ok {
+		return result, nil
+	}
+
+	if config.DHCP != nil {
+		return nil, fmt.Errorf("DHCP addresses are not allocated by the node")
+	}
+	if config.Pool != "" {
+		return nil, fmt.Errorf("addresses of pool %s are not allocated by the node", config.Pool)
+	}
+	parsed, err := config.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[netip.Addr]types.UID{}
+	for uid, results := range a.state.Claims {
+		for _, result := range results {
+			for _, prefix := range result.Addresses {
+				used[prefix.Addr()] = uid
+			}
+		}
+	}
+
+	result := &Result{Gateways: parsed.gateways}
+	for _, prefix := range parsed.addresses {
+		if owner, ok := used[prefix.Addr()]; ok {
+			return nil, fmt.Errorf("address %s is already allocated to claim %s", prefix.Addr(), owner)
+		}
+		used[prefix.Addr()] = claimUID
+		result.Addresses = append(result.Addresses, prefix)
+	}
+	for _, r := range parsed.ranges {
+		addr, err := allocateFromRange(r, func(addr netip.Addr) bool {
+			_, ok := used[addr]
+			return ok
+		})
+		if err != nil {
+			return nil, err
+		}
+		used[addr] = claimUID
+		result.Addresses = append(result.Addresses, netip.PrefixFrom(addr, r.subnet.Bits()))
+	}
+	for _, route := range parsed.routes {
+		dst := netip.MustParsePrefix(route.Destination)
+		resolved := ResolvedRoute{Destination: dst.Masked()}
+		if route.Gateway != "" {
+			resolved.Gateway = netip.MustParseAddr(route.Gateway)
+		} else {
+			resolved.Gateway = gatewayFor(parsed.gateways, dst.Addr().Is4())
+		}
+		result.Routes = append(result.Routes, resolved)
+	}
+
+	if a.state.Claims[claimUID] == nil {
+		a.state.Claims[claimUID] = map[string]*Result{}
+	}
+	a.state.Claims[claimUID][key] = result
+	if err := a.save(); err != nil {
+		delete(a.state.Claims[claimUID], key)
+		if len(a.state.Claims[claimUID]) == 0 {
+			delete(a.state.Claims, claimUID)
+		}
+		return nil, err
+	}
+	klog.V(2).Infof("IPAM allocated %v to %s of claim %s", result.Addresses, key, claimUID)
+	return result, nil
+}
+
+// Release frees all the addresses allocated to the claim, it does not fail if
+// the claim has no allocations.
+func (a *Allocator) Release(claimUID types.UID) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	results, ok := a.state.Claims[claimUID]
+	if !ok {
+		return nil
+	}
+	delete(a.state.Claims, claimUID)
+	if err := a.save(); err != nil {
+		a.state.Claims[claimUID] = results
+		return err
+	}
+	klog.V(2).Infof("IPAM released the addresses of claim %s", claimUID)
+	return nil
+}
+
+// allocateFromRange returns the first address of the range that is not used.
+func allocateFromRange(r parsedRange, inUse func(netip.Addr) bool) (netip.Addr, error) {
+	for addr := r.start; addr.IsValid() && r.contains(addr); addr = addr.Next() {
+		if addr == r.gateway {
+			continue
+		}
+		if !inUse(addr) {
+			return addr, nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("range %s is exhausted", r.subnet)
+}
+
+// save writes the state to a temporary file and renames it, so a crash never
+// leaves a partially written state file.
+func (a *Allocator) save() error {
+	data, err := json.Marshal(a.state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write IPAM state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write IPAM state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync IPAM state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write IPAM state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		return fmt.Errorf("failed to replace IPAM state: %w", err)
+	}
+	// persist the rename
+	if dir, err := os.Open(filepath.Dir(a.path)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package ipam
+
+import (
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestAllocate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam", "state.json")
+	a, err := NewAllocator(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := Config{
+		Ranges: []Range{
+			{Subnet: "10.0.0.0/29", Gateway: "10.0.0.1"},
+			{Subnet: "fd00::/120", RangeStart: "fd00::10"},
+		},
+		Routes: []Route{
+			{Destination: "192.168.0.0/16"},
+			{Destination: "fd01::/64", Gateway: "fd00::1"},
+		},
+	}
+	result, err := a.Allocate("claim1", "req/eth1", config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &Result{
+		Addresses: []netip.Prefix{netip.MustParsePrefix("10.0.0.2/29"), netip.MustParsePrefix("fd00::10/120")},
+		Gateways:  []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+		Routes: []ResolvedRoute{
+			{Destination: netip.MustParsePrefix("192.168.0.0/16"), Gateway: netip.MustParseAddr("10.0.0.1")},
+			{Destination: netip.MustParsePrefix("fd01::/64"), Gateway: netip.MustParseAddr("fd00::1")},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Allocate() = %+v, want %+v", result, expected)
+	}
+
+	// the allocation is idempotent
+	again, err := a.Allocate("claim1", "req/eth1", config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(again, expected) {
+		t.Errorf("Allocate() = %+v, want %+v", again, expected)
+	}
+
+	// the state survives a restart
+	a, err = NewAllocator(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := a.Allocate("claim2", "req/eth1", Config{Ranges: config.Ranges})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := second.Addresses; got[0] != netip.MustParsePrefix("10.0.0.3/29") || got[1] != netip.MustParsePrefix("fd00::11/120") {
+		t.Errorf("unexpected addresses %v", got)
+	}
+
+	// static addresses can not be allocated twice
+	if _, err := a.Allocate("claim3", "req/eth1", Config{Addresses: []string{"10.0.0.3/29"}}); err == nil {
+		t.Errorf("expected error allocating an address in use")
+	}
+
+	// 10.0.0.2-10.0.0.6 are usable, two are in use
+	for i, uid := range []types.UID{"claim4", "claim5", "claim6"} {
+		if _, err := a.Allocate(uid, "req/eth1", Config{Ranges: config.Ranges[:1]}); err != nil {
+			t.Fatalf("unexpected error allocating address %d: %v", i, err)
+		}
+	}
+	if _, err := a.Allocate("claim7", "req/eth1", Config{Ranges: config.Ranges[:1]}); err == nil {
+		t.Errorf("expected error on exhausted range")
+	}
+
+	// released addresses are reused
+	if err := a.Release("claim1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := a.Release("claim1"); err != nil {
+		t.Fatalf("unexpected error releasing twice: %v", err)
+	}
+	a, err = NewAllocator(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err = a.Allocate("claim7", "req/eth1", Config{Ranges: config.Ranges[:1]})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Addresses[0] != netip.MustParsePrefix("10.0.0.2/29") {
+		t.Errorf("expected released address 10.0.0.2/29, got %v", result.Addresses[0])
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for name, config := range map[string]Config{
+		"invalid address":      {Addresses: []string{"10.0.0.1"}},
+		"invalid subnet":       {Ranges: []Range{{Subnet: "10.0.0.0"}}},
+		"start outside subnet": {Ranges: []Range{{Subnet: "10.0.0.0/24", RangeStart: "10.0.1.1"}}},
+		"empty range":          {Ranges: []Range{{Subnet: "10.0.0.0/24", RangeStart: "10.0.0.10", RangeEnd: "10.0.0.5"}}},
+		"two gateways":         {Gateways: []string{"10.0.0.1", "10.0.1.1"}},
+		"mixed family route":   {Routes: []Route{{Destination: "10.0.0.0/8", Gateway: "fd00::1"}}},
+		"dhcp with address":    {Addresses: []string{"10.0.0.10/24"}, DHCP: &DHCP{}},
+		"pool with range":      {Pool: "pool", Ranges: []Range{{Subnet: "10.0.0.0/24"}}},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	valid := Config{
+		Addresses: []string{"10.0.0.10/24", "fd00::10/64"},
+		Gateways:  []string{"10.0.0.1", "fd00::1"},
+		Ranges:    []Range{{Subnet: "10.0.0.0/24", Gateway: "10.0.0.1"}},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	dhcp := Config{DHCP: &DHCP{}, Routes: []Route{{Destination: "10.0.0.0/8"}}}
+	if err := dhcp.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if dhcp.Empty() {
+		t.Errorf("DHCP configuration must not be empty")
+	}
+}
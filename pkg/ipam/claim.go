@@ -0,0 +1,73 @@
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+
+	resourceapi "k8s.io/api/resource/v1"
+)
+
+// ClaimAnnotation is the annotation of the ResourceClaims where the IP pool controller
+// records the addresses allocated from the cluster pools. Its value is a JSON object
+// that maps the ResultKey of the allocated devices to their Result.
+const ClaimAnnotation = "ipam.knd.x-k8s.io/allocations"
+
+// ResultKey returns the key of an allocated device in the ClaimAnnotation, in the
+// form <request>/<device>, followed by /<share ID> for the shares of a device.
+func ResultKey(result resourceapi.DeviceRequestAllocationResult) string {
+	key := result.Request + "/" + result.Device
+	if result.ShareID != nil {
+		key += "/" + string(*result.ShareID)
+	}
+	return key
+}
+
+// ClaimResults returns the results recorded in the claim by the IP pool controller.
+func ClaimResults(claim *resourceapi.ResourceClaim) (map[string]*Result, error) {
+	value, ok := claim.Annotations[ClaimAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	results := map[string]*Result{}
+	if err := json.Unmarshal([]byte(value), &results); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s on claim %s/%s: %w", ClaimAnnotation, claim.Namespace, claim.Name, err)
+	}
+	return results, nil
+}
+
+// PoolResult returns the addresses allocated by the IP pool controller to the allocated
+// device of the claim, with the routes of the configuration added. It fails if the
+// controller did not record them yet, so the preparation is retried.
+func PoolResult(claim *resourceapi.ResourceClaim, device resourceapi.DeviceRequestAllocationResult, config Config) (*Result, error) {
+	parsed, err := config.parse()
+	if err != nil {
+		return nil, err
+	}
+	results, err := ClaimResults(claim)
+	if err != nil {
+		return nil, err
+	}
+	key := ResultKey(device)
+	recorded, ok := results[key]
+	if !ok {
+		return nil, fmt.Errorf("addresses of pool %s for %s of claim %s/%s are not allocated yet", config.Pool, key, claim.Namespace, claim.Name)
+	}
+
+	result := &Result{
+		Addresses: recorded.Addresses,
+		Gateways:  recorded.Gateways,
+		Routes:    recorded.Routes,
+	}
+	for _, route := range parsed.routes {
+		dst := netip.MustParsePrefix(route.Destination)
+		resolved := ResolvedRoute{Destination: dst.Masked()}
+		if route.Gateway != "" {
+			resolved.Gateway = netip.MustParseAddr(route.Gateway)
+		} else {
+			resolved.Gateway = gatewayFor(result.Gateways, dst.Addr().Is4())
+		}
+		result.Routes = append(result.Routes, resolved)
+	}
+	return result, nil
+}
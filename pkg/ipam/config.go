@@ -0,0 +1,279 @@
+package ipam
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Config is the IPAM configuration of an interface, it is embedded in the opaque
+// configuration of the claims.
+type Config struct {
+	// Addresses are static addresses in CIDR notation, for example 192.168.1.10/24.
+	Addresses []string `json:"addresses,omitempty"`
+	// Ranges are node-local ranges, one address is allocated from each of them.
+	Ranges []Range `json:"ranges,omitempty"`
+	// Gateways are the gateways of the static addresses, at most one per IP family.
+	Gateways []string `json:"gateways,omitempty"`
+	// Routes are added inside the pod through the interface.
+	Routes []Route `json:"routes,omitempty"`
+	// DHCP obtains the address from a DHCP server of the attached network, it can
+	// not be combined with static addresses or ranges.
+	DHCP *DHCP `json:"dhcp,omitempty"`
+	// Pool is the name of a cluster IPPool, the addresses are allocated from it by
+	// the IP pool controller and recorded in the claim. It can not be combined with
+	// static addresses, ranges or DHCP.
+	Pool string `json:"pool,omitempty"`
+}
+
+// DHCP configures the DHCP client of an interface.
+type DHCP struct {
+	// Timeout of the initial DHCPv4 exchange and of the IPv6 address wait, it
+	// defaults to 30 seconds.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// WaitIPv6 waits for a global IPv6 address configured by SLAAC or DHCPv6.
+	WaitIPv6 bool `json:"waitIPv6,omitempty"`
+}
+
+// DefaultDHCPTimeout is the default timeout of the DHCP client.
+const DefaultDHCPTimeout = 30 * time.Second
+
+// GetTimeout returns the timeout of the DHCP client.
+func (d *DHCP) GetTimeout() time.Duration {
+	if d.Timeout.Duration <= 0 {
+		return DefaultDHCPTimeout
+	}
+	return d.Timeout.Duration
+}
+
+// Range is a node-local range of addresses.
+type Range struct {
+	// Subnet of the range in CIDR notation, the allocated addresses use its prefix length.
+	Subnet string `json:"subnet"`
+	// RangeStart and RangeEnd limit the addresses allocated from the subnet, they
+	// default to the first and the last usable addresses of the subnet.
+	RangeStart string `json:"rangeStart,omitempty"`
+	RangeEnd   string `json:"rangeEnd,omitempty"`
+	// Gateway of the subnet, it is never allocated.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// Route is a route added inside the pod through the interface.
+type Route struct {
+	// Destination of the route in CIDR notation.
+	Destination string `json:"destination"`
+	// Gateway of the route, it defaults to the gateway of the same IP family, if
+	// there is none the destination is directly connected.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// Empty returns true if the configuration does not request any address or route.
+func (c *Config) Empty() bool {
+	return len(c.Addresses) == 0 && len(c.Ranges) == 0 && len(c.Routes) == 0 && c.DHCP == nil && c.Pool == ""
+}
+
+// Validate checks the configuration.
+func (c *Config) Validate() error {
+	_, err := c.parse()
+	return err
+}
+
+// parsedRange is a Range with its addresses parsed.
+type parsedRange struct {
+	subnet  netip.Prefix
+	start   netip.Addr
+	end     netip.Addr
+	gateway netip.Addr
+}
+
+// contains returns true if the address can be allocated from the range.
+func (r parsedRange) contains(addr netip.Addr) bool {
+	return r.start.Compare(addr) <= 0 && addr.Compare(r.end) <= 0
+}
+
+// parsedConfig is a Config with its addresses parsed.
+type parsedConfig struct {
+	addresses []netip.Prefix
+	ranges    []parsedRange
+	gateways  []netip.Addr
+	routes    []Route
+}
+
+func (c *Config) parse() (*parsedConfig, error) {
+	parsed := &parsedConfig{}
+	if c.DHCP != nil && (len(c.Addresses) > 0 || len(c.Ranges) > 0 || len(c.Gateways) > 0) {
+		return nil, fmt.Errorf("DHCP can not be combined with static addresses, ranges or gateways")
+	}
+	if c.Pool != "" && (c.DHCP != nil || len(c.Addresses) > 0 || len(c.Ranges) > 0 || len(c.Gateways) > 0) {
+		return nil, fmt.Errorf("pool %s can not be combined with DHCP, static addresses, ranges or gateways", c.Pool)
+	}
+	for _, address := range c.Addresses {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		parsed.addresses = append(parsed.addresses, prefix)
+	}
+
+	for _, r := range c.Ranges {
+		pr, err := parseRange(r)
+		if err != nil {
+			return nil, err
+		}
+		parsed.ranges = append(parsed.ranges, pr)
+	}
+
+	var gateways []netip.Addr
+	for _, gateway := range c.Gateways {
+		addr, err := netip.ParseAddr(gateway)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gateway %q: %w", gateway, err)
+		}
+		gateways = append(gateways, addr)
+	}
+	for _, r := range parsed.ranges {
+		if r.gateway.IsValid() {
+			gateways = append(gateways, r.gateway)
+		}
+	}
+	for _, gw := range gateways {
+		existing := gatewayFor(parsed.gateways, gw.Is4())
+		if !existing.IsValid() {
+			parsed.gateways = append(parsed.gateways, gw)
+		} else if existing != gw {
+			return nil, fmt.Errorf("only one gateway per IP family is allowed, got %s and %s", existing, gw)
+		}
+	}
+
+	for _, route := range c.Routes {
+		dst, err := netip.ParsePrefix(route.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route destination %q: %w", route.Destination, err)
+		}
+		if route.Gateway != "" {
+			gw, err := netip.ParseAddr(route.Gateway)
+			if err != nil {
+				return nil, fmt.Errorf("invalid route gateway %q: %w", route.Gateway, err)
+			}
+			if gw.Is4() != dst.Addr().Is4() {
+				return nil, fmt.Errorf("route to %s and gateway %s have different IP families", dst, gw)
+			}
+		}
+		parsed.routes = append(parsed.routes, route)
+	}
+	return parsed, nil
+}
+
+// Validate checks the range.
+func (r Range) Validate() error {
+	_, err := parseRange(r)
+	return err
+}
+
+// Prefix returns the subnet of the range.
+func (r Range) Prefix() (netip.Prefix, error) {
+	pr, err := parseRange(r)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return pr.subnet, nil
+}
+
+// NextAddress returns the first address of the range that is not in use, with the
+// prefix length of the range subnet. The gateway of the range is never returned.
+func (r Range) NextAddress(inUse func(netip.Addr) bool) (netip.Prefix, error) {
+	pr, err := parseRange(r)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr, err := allocateFromRange(pr, inUse)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, pr.subnet.Bits()), nil
+}
+
+// Contains returns true if the address can be allocated from the range.
+func (r Range) Contains(addr netip.Addr) bool {
+	pr, err := parseRange(r)
+	if err != nil {
+		return false
+	}
+	return pr.contains(addr) && addr != pr.gateway
+}
+
+func parseRange(r Range) (parsedRange, error) {
+	subnet, err := netip.ParsePrefix(r.Subnet)
+	if err != nil {
+		return parsedRange{}, fmt.Errorf("invalid range subnet %q: %w", r.Subnet, err)
+	}
+	subnet = subnet.Masked()
+	pr := parsedRange{subnet: subnet}
+
+	pr.start = subnet.Addr()
+	// the network address of IPv4 subnets is not usable, nor the subnet-router
+	// anycast address of the IPv6 ones
+	if subnet.Addr().BitLen()-subnet.Bits() > 1 {
+		pr.start = pr.start.Next()
+	}
+	pr.end = lastAddr(subnet)
+	// the broadcast address of IPv4 subnets is not usable
+	if subnet.Addr().Is4() && subnet.Bits() < 31 {
+		pr.end = pr.end.Prev()
+	}
+
+	if r.RangeStart != "" {
+		if pr.start, err = parseRangeAddr(r.RangeStart, subnet); err != nil {
+			return parsedRange{}, err
+		}
+	}
+	if r.RangeEnd != "" {
+		if pr.end, err = parseRangeAddr(r.RangeEnd, subnet); err != nil {
+			return parsedRange{}, err
+		}
+	}
+	if pr.end.Less(pr.start) {
+		return parsedRange{}, fmt.Errorf("range %s has no addresses", r.Subnet)
+	}
+	if r.Gateway != "" {
+		if pr.gateway, err = parseRangeAddr(r.Gateway, subnet); err != nil {
+			return parsedRange{}, err
+		}
+	}
+	return pr, nil
+}
+
+func parseRangeAddr(s string, subnet netip.Prefix) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid address %q: %w", s, err)
+	}
+	if !subnet.Contains(addr) {
+		return netip.Addr{}, fmt.Errorf("address %s is not in subnet %s", addr, subnet)
+	}
+	return addr, nil
+}
+
+// lastAddr returns the last address of the subnet.
+func lastAddr(subnet netip.Prefix) netip.Addr {
+	bytes := subnet.Addr().AsSlice()
+	mask := net.CIDRMask(subnet.Bits(), subnet.Addr().BitLen())
+	for i := range bytes {
+		bytes[i] |= ^mask[i]
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// gatewayFor returns the first gateway of the IP family.
+func gatewayFor(gateways []netip.Addr, ipv4 bool) netip.Addr {
+	for _, gw := range gateways {
+		if gw.Is4() == ipv4 {
+			return gw
+		}
+	}
+	return netip.Addr{}
+}
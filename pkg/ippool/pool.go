@@ -0,0 +1,187 @@
+package ippool
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+)
+
+// Validate checks the ranges of the pool, they must not overlap.
+func (p *IPPool) Validate() error {
+	if len(p.Spec.Ranges) == 0 {
+		return fmt.Errorf("pool %s has no ranges", p.Name)
+	}
+	var prefixes []netip.Prefix
+	for _, r := range p.Spec.Ranges {
+		prefix, err := r.Prefix()
+		if err != nil {
+			return fmt.Errorf("pool %s: %w", p.Name, err)
+		}
+		for _, other := range prefixes {
+			if other.Overlaps(prefix) {
+				return fmt.Errorf("pool %s: ranges %s and %s overlap", p.Name, other, prefix)
+			}
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return nil
+}
+
+// Overlaps returns true if any range of the pool overlaps with a range of other.
+func (p *IPPool) Overlaps(other *IPPool) bool {
+	for _, r := range p.Spec.Ranges {
+		prefix, err := r.Prefix()
+		if err != nil {
+			continue
+		}
+		for _, o := range other.Spec.Ranges {
+			otherPrefix, err := o.Prefix()
+			if err == nil && prefix.Overlaps(otherPrefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Owner returns the owner of the address, if it is allocated.
+func (p *IPPool) Owner(addr netip.Addr) (Allocation, bool) {
+	allocation, ok := p.Status.Allocations[addr.String()]
+	return allocation, ok
+}
+
+// Allocate assigns one address of each range of the pool to the device of the claim.
+// The allocation is idempotent, the addresses already allocated to the device are
+// returned again. It returns true if the status of the pool was modified.
+func (p *IPPool) Allocate(claim *resourceapi.ResourceClaim, device string, now time.Time) (*ipam.Result, bool, error) {
+	if err := p.Validate(); err != nil {
+		return nil, false, err
+	}
+	if p.Status.Allocations == nil {
+		p.Status.Allocations = map[string]Allocation{}
+	}
+
+	result := &ipam.Result{}
+	changed := false
+	for _, r := range p.Spec.Ranges {
+		prefix, err := p.allocateFromRange(r, claim.UID, device)
+		if err != nil {
+			return nil, false, fmt.Errorf("pool %s: %w", p.Name, err)
+		}
+		if _, ok := p.Status.Allocations[prefix.Addr().String()]; !ok {
+			p.Status.Allocations[prefix.Addr().String()] = Allocation{
+				ClaimNamespace: claim.Namespace,
+				ClaimName:      claim.Name,
+				ClaimUID:       claim.UID,
+				Device:         device,
+				Timestamp:      metav1.NewTime(now),
+			}
+			changed = true
+		}
+		result.Addresses = append(result.Addresses, prefix)
+		if r.Gateway != "" {
+			gw, err := netip.ParseAddr(r.Gateway)
+			if err != nil {
+				return nil, false, err
+			}
+			if !hasFamily(result.Gateways, gw.Is4()) {
+				result.Gateways = append(result.Gateways, gw)
+			}
+		}
+	}
+	return result, changed, nil
+}
+
+// allocateFromRange returns the address of the range owned by the device, or the
+// first free address of the range.
+func (p *IPPool) allocateFromRange(r ipam.Range, claimUID types.UID, device string) (netip.Prefix, error) {
+	subnet, err := r.Prefix()
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	for key, allocation := range p.Status.Allocations {
+		if allocation.ClaimUID != claimUID || allocation.Device != device {
+			continue
+		}
+		addr, err := netip.ParseAddr(key)
+		if err == nil && r.Contains(addr) {
+			return netip.PrefixFrom(addr, subnet.Bits()), nil
+		}
+	}
+	return r.NextAddress(func(addr netip.Addr) bool {
+		_, ok := p.Status.Allocations[addr.String()]
+		return ok
+	})
+}
+
+// Adopt records in the pool the addresses of a result previously recorded in the
+// claim, so they are not handed out again if the status of the pool was lost. It
+// fails if an address is allocated to another owner, two pods would use it. It
+// returns true if the status of the pool was modified.
+func (p *IPPool) Adopt(claim *resourceapi.ResourceClaim, device string, result *ipam.Result, now time.Time) (bool, error) {
+	if p.Status.Allocations == nil {
+		p.Status.Allocations = map[string]Allocation{}
+	}
+	changed := false
+	for _, prefix := range result.Addresses {
+		if !p.contains(prefix.Addr()) {
+			return false, fmt.Errorf("address %s of %s of claim %s/%s is not in pool %s", prefix.Addr(), device, claim.Namespace, claim.Name, p.Name)
+		}
+		owner, ok := p.Owner(prefix.Addr())
+		if !ok {
+			p.Status.Allocations[prefix.Addr().String()] = Allocation{
+				ClaimNamespace: claim.Namespace,
+				ClaimName:      claim.Name,
+				ClaimUID:       claim.UID,
+				Device:         device,
+				Timestamp:      metav1.NewTime(now),
+			}
+			changed = true
+			continue
+		}
+		if owner.ClaimUID != claim.UID || owner.Device != device {
+			return false, fmt.Errorf("address %s of %s of claim %s/%s conflicts with %s of claim %s/%s in pool %s",
+				prefix.Addr(), device, claim.Namespace, claim.Name, owner.Device, owner.ClaimNamespace, owner.ClaimName, p.Name)
+		}
+	}
+	return changed, nil
+}
+
+// Release frees all the addresses allocated to the claim, it returns true if the
+// status of the pool was modified.
+func (p *IPPool) Release(claimUID types.UID) bool {
+	changed := false
+	for key, allocation := range p.Status.Allocations {
+		if allocation.ClaimUID == claimUID {
+			delete(p.Status.Allocations, key)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// contains returns true if the address belongs to a range of the pool.
+func (p *IPPool) contains(addr netip.Addr) bool {
+	for _, r := range p.Spec.Ranges {
+		if r.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFamily returns true if there is an address of the IP family.
+func hasFamily(addrs []netip.Addr, ipv4 bool) bool {
+	for _, addr := range addrs {
+		if addr.Is4() == ipv4 {
+			return true
+		}
+	}
+	return false
+}
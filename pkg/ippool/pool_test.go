@@ -0,0 +1,123 @@
+package ippool
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+)
+
+func testClaim(name string, uid types.UID) *resourceapi.ResourceClaim {
+	return &resourceapi.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: uid},
+	}
+}
+
+func TestPoolAllocate(t *testing.T) {
+	pool := &IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+		Spec: IPPoolSpec{Ranges: []ipam.Range{
+			{Subnet: "10.0.0.0/30", Gateway: "10.0.0.1"},
+			{Subnet: "fd00::/64", RangeStart: "fd00::10"},
+		}},
+	}
+	now := time.Now()
+	claimA := testClaim("a", "uid-a")
+	claimB := testClaim("b", "uid-b")
+
+	result, changed, err := pool.Allocate(claimA, "req/eth0", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !changed {
+		t.Errorf("expected the pool to change")
+	}
+	if len(result.Addresses) != 2 || result.Addresses[0] != netip.MustParsePrefix("10.0.0.2/30") || result.Addresses[1] != netip.MustParsePrefix("fd00::10/64") {
+		t.Errorf("unexpected addresses: %v", result.Addresses)
+	}
+	if len(result.Gateways) != 1 || result.Gateways[0] != netip.MustParseAddr("10.0.0.1") {
+		t.Errorf("unexpected gateways: %v", result.Gateways)
+	}
+
+	// the allocation is idempotent
+	again, changed, err := pool.Allocate(claimA, "req/eth0", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed || again.Addresses[0] != result.Addresses[0] {
+		t.Errorf("expected the same allocation, got %v changed %v", again.Addresses, changed)
+	}
+
+	// the IPv4 range is exhausted, .1 is the gateway and .3 the broadcast
+	if _, _, err := pool.Allocate(claimB, "req/eth0", now); err == nil {
+		t.Errorf("expected the pool to be exhausted")
+	}
+
+	if !pool.Release(claimA.UID) {
+		t.Errorf("expected the pool to change on release")
+	}
+	if len(pool.Status.Allocations) != 0 {
+		t.Errorf("expected no allocations, got %v", pool.Status.Allocations)
+	}
+	if _, _, err := pool.Allocate(claimB, "req/eth0", now); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPoolAdopt(t *testing.T) {
+	pool := &IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+		Spec:       IPPoolSpec{Ranges: []ipam.Range{{Subnet: "10.0.0.0/24"}}},
+	}
+	now := time.Now()
+	claimA := testClaim("a", "uid-a")
+	claimB := testClaim("b", "uid-b")
+	recorded := &ipam.Result{Addresses: []netip.Prefix{netip.MustParsePrefix("10.0.0.5/24")}}
+
+	// the address recorded in the claim is restored in the pool
+	changed, err := pool.Adopt(claimA, "req/eth0", recorded, now)
+	if err != nil || !changed {
+		t.Fatalf("expected the address to be adopted, changed %v error %v", changed, err)
+	}
+	if owner, ok := pool.Owner(netip.MustParseAddr("10.0.0.5")); !ok || owner.ClaimUID != claimA.UID {
+		t.Errorf("unexpected owner %v", owner)
+	}
+
+	// the same address recorded in another claim is a conflict
+	if _, err := pool.Adopt(claimB, "req/eth0", recorded, now); err == nil {
+		t.Errorf("expected a conflict")
+	}
+
+	outside := &ipam.Result{Addresses: []netip.Prefix{netip.MustParsePrefix("10.0.1.5/24")}}
+	if _, err := pool.Adopt(claimB, "req/eth0", outside, now); err == nil {
+		t.Errorf("expected an error for an address outside the pool")
+	}
+}
+
+func TestPoolValidate(t *testing.T) {
+	for name, spec := range map[string]IPPoolSpec{
+		"no ranges":         {},
+		"invalid subnet":    {Ranges: []ipam.Range{{Subnet: "10.0.0.0"}}},
+		"overlapping range": {Ranges: []ipam.Range{{Subnet: "10.0.0.0/24"}, {Subnet: "10.0.0.128/25"}}},
+	} {
+		pool := &IPPool{ObjectMeta: metav1.ObjectMeta{Name: "pool"}, Spec: spec}
+		if err := pool.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	a := &IPPool{Spec: IPPoolSpec{Ranges: []ipam.Range{{Subnet: "10.0.0.0/24"}}}}
+	b := &IPPool{Spec: IPPoolSpec{Ranges: []ipam.Range{{Subnet: "10.0.0.0/16"}}}}
+	c := &IPPool{Spec: IPPoolSpec{Ranges: []ipam.Range{{Subnet: "10.1.0.0/24"}}}}
+	if !a.Overlaps(b) {
+		t.Errorf("expected pools to overlap")
+	}
+	if a.Overlaps(c) {
+		t.Errorf("expected pools not to overlap")
+	}
+}
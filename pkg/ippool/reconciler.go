@@ -0,0 +1,339 @@
+package ippool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/driver"
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+)
+
+// deviceConfig is the part of the opaque configuration of the drivers read by the
+// reconciler, all the drivers embed the IPAM configuration in the same field.
+type deviceConfig struct {
+	IPAM ipam.Config `json:"ipam,omitempty"`
+}
+
+// Reconciler implements the controller.Reconciler interface, it allocates the
+// addresses of the claims that request a cluster IPPool and records them in the
+// ipam.ClaimAnnotation of the claim, where the node drivers read them.
+type Reconciler struct {
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+	// deviceClasses are the DeviceClasses whose claims are handled, all if empty.
+	deviceClasses sets.Set[string]
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// NewReconciler returns a Reconciler for the claims of the given DeviceClasses, or
+// for the claims of all the DeviceClasses if none is given. Only the claims with
+// devices configured with a pool are handled.
+func NewReconciler(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface, deviceClasses ...string) *Reconciler {
+	return &Reconciler{
+		kubeClient:    kubeClient,
+		dynamicClient: dynamicClient,
+		deviceClasses: sets.New(deviceClasses...),
+		now:           time.Now,
+	}
+}
+
+// IsDeviceClassRelevant checks if the claims of the DeviceClass are handled.
+func (r *Reconciler) IsDeviceClassRelevant(deviceClass *resourceapi.DeviceClass) bool {
+	return r.deviceClasses.Len() == 0 || r.deviceClasses.Has(deviceClass.Name)
+}
+
+// IsClaimRelevant checks if the claim has devices configured with a pool, or the
+// addresses of a previous allocation that have to be released. The controller does
+// not add its finalizer nor the Reconciled condition to the other claims.
+func (r *Reconciler) IsClaimRelevant(claim *resourceapi.ResourceClaim) bool {
+	if _, ok := claim.Annotations[ipam.ClaimAnnotation]; ok {
+		return true
+	}
+	devices, err := poolDevices(claim)
+	// the invalid configurations are reported by Reconcile
+	return err != nil || len(devices) > 0
+}
+
+// Reconcile allocates the addresses of the allocated devices configured with a pool.
+// The pools are updated before the claim, so an address recorded in a claim is
+// always owned by it in the pool. It does not create any ResourceSlice.
+func (r *Reconciler) Reconcile(ctx context.Context, claim *resourceapi.ResourceClaim) ([]*resourceapi.ResourceSlice, error) {
+	devicesByPool, err := poolDevices(claim)
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := ipam.ClaimResults(claim)
+	if err != nil {
+		return nil, err
+	}
+
+	results := map[string]*ipam.Result{}
+	for _, poolName := range slices.Sorted(maps.Keys(devicesByPool)) {
+		poolResults, err := r.allocate(ctx, poolName, claim, devicesByPool[poolName], recorded)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(results, poolResults)
+	}
+
+	// the devices that are not allocated anymore are dropped from the annotation
+	if maps.EqualFunc(results, recorded, resultsEqual) {
+		return nil, nil
+	}
+	if err := r.recordResults(ctx, claim, results); err != nil {
+		return nil, err
+	}
+	klog.Infof("Recorded the addresses of %d devices of claim %s/%s", len(results), claim.Namespace, claim.Name)
+	return nil, nil
+}
+
+// allocate allocates the addresses of the devices of the claim from the pool and
+// returns them. The results already recorded in the claim are kept.
+func (r *Reconciler) allocate(ctx context.Context, poolName string, claim *resourceapi.ResourceClaim, devices []string, recorded map[string]*ipam.Result) (map[string]*ipam.Result, error) {
+	var results map[string]*ipam.Result
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		results = map[string]*ipam.Result{}
+		pool, err := r.getPool(ctx, poolName)
+		if err != nil {
+			return err
+		}
+		if err := r.checkOverlaps(ctx, pool); err != nil {
+			return err
+		}
+
+		changed := false
+		for _, device := range devices {
+			if result, ok := recorded[device]; ok {
+				adopted, err := pool.Adopt(claim, device, result, r.now())
+				if err != nil {
+					return err
+				}
+				changed = changed || adopted
+				results[device] = result
+				continue
+			}
+			result, allocated, err := pool.Allocate(claim, device, r.now())
+			if err != nil {
+				return err
+			}
+			changed = changed || allocated
+			results[device] = result
+		}
+		if !changed {
+			return nil
+		}
+		return r.updatePoolStatus(ctx, pool)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate addresses of claim %s/%s from pool %s: %w", claim.Namespace, claim.Name, poolName, err)
+	}
+	return results, nil
+}
+
+// Delete frees the addresses allocated to the claim in all the pools.
+func (r *Reconciler) Delete(ctx context.Context, claim *resourceapi.ResourceClaim) error {
+	list, err := r.dynamicClient.Resource(GroupVersionResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list IP pools: %w", err)
+	}
+	var errs []error
+	for _, item := range list.Items {
+		if err := r.release(ctx, item.GetName(), sets.New(claim.UID), time.Time{}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GarbageCollect frees the addresses whose claims do not exist or are not allocated
+// anymore, they are leaked if the controller misses the deletion of a claim. The
+// allocations younger than minAge are kept, their claims may not be listed yet.
+func (r *Reconciler) GarbageCollect(ctx context.Context, minAge time.Duration) error {
+	// the pools are listed after the claims, so an allocation of a claim created in
+	// between is younger than the list of the claims
+	claims, err := r.kubeClient.ResourceV1().ResourceClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list resource claims: %w", err)
+	}
+	live := sets.New[types.UID]()
+	for _, claim := range claims.Items {
+		if claim.Status.Allocation != nil {
+			live.Insert(claim.UID)
+		}
+	}
+
+	list, err := r.dynamicClient.Resource(GroupVersionResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list IP pools: %w", err)
+	}
+	var errs []error
+	for _, item := range list.Items {
+		pool, err := FromUnstructured(&item)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		leaked := sets.New[types.UID]()
+		for _, allocation := range pool.Status.Allocations {
+			if !live.Has(allocation.ClaimUID) {
+				leaked.Insert(allocation.ClaimUID)
+			}
+		}
+		if leaked.Len() == 0 {
+			continue
+		}
+		if err := r.release(ctx, pool.Name, leaked, r.now().Add(-minAge)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run garbage collects the leaked addresses every interval until the context is done.
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := r.GarbageCollect(ctx, interval); err != nil {
+			klog.Errorf("Failed to garbage collect IP pool allocations: %v", err)
+		}
+	}, interval)
+}
+
+// release frees the addresses of the claims in the pool that were allocated before
+// the given time, or all of them if it is zero.
+func (r *Reconciler) release(ctx context.Context, poolName string, claimUIDs sets.Set[types.UID], before time.Time) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pool, err := r.getPool(ctx, poolName)
+		if err != nil {
+			return err
+		}
+		released := 0
+		for key, allocation := range pool.Status.Allocations {
+			if !claimUIDs.Has(allocation.ClaimUID) {
+				continue
+			}
+			if !before.IsZero() && !allocation.Timestamp.Time.Before(before) {
+				continue
+			}
+			klog.V(2).Infof("Releasing address %s of %s of claim %s/%s from pool %s",
+				key, allocation.Device, allocation.ClaimNamespace, allocation.ClaimName, poolName)
+			delete(pool.Status.Allocations, key)
+			released++
+		}
+		if released == 0 {
+			return nil
+		}
+		return r.updatePoolStatus(ctx, pool)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release addresses from pool %s: %w", poolName, err)
+	}
+	return nil
+}
+
+// checkOverlaps fails if the pool overlaps with another pool, the same address
+// could be allocated twice.
+func (r *Reconciler) checkOverlaps(ctx context.Context, pool *IPPool) error {
+	list, err := r.dynamicClient.Resource(GroupVersionResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list IP pools: %w", err)
+	}
+	for _, item := range list.Items {
+		if item.GetName() == pool.Name {
+			continue
+		}
+		other, err := FromUnstructured(&item)
+		if err != nil {
+			continue
+		}
+		if pool.Overlaps(other) {
+			return fmt.Errorf("pool %s overlaps with pool %s", pool.Name, other.Name)
+		}
+	}
+	return nil
+}
+
+func (r *Reconciler) getPool(ctx context.Context, name string) (*IPPool, error) {
+	obj, err := r.dynamicClient.Resource(GroupVersionResource).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return FromUnstructured(obj)
+}
+
+func (r *Reconciler) updatePoolStatus(ctx context.Context, pool *IPPool) error {
+	obj, err := ToUnstructured(pool)
+	if err != nil {
+		return err
+	}
+	_, err = r.dynamicClient.Resource(GroupVersionResource).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
+
+// recordResults replaces the annotation of the claim with the results.
+func (r *Reconciler) recordResults(ctx context.Context, claim *resourceapi.ResourceClaim, results map[string]*ipam.Result) error {
+	var value interface{}
+	if len(results) > 0 {
+		data, err := json.Marshal(results)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	// a null value removes the annotation
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{ipam.ClaimAnnotation: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.kubeClient.ResourceV1().ResourceClaims(claim.Namespace).Patch(ctx, claim.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to record addresses on claim %s/%s: %w", claim.Namespace, claim.Name, err)
+	}
+	return nil
+}
+
+// poolDevices returns the ipam.ResultKey of the allocated devices of the
+// claim that are configured with a pool, indexed by pool.
+func poolDevices(claim *resourceapi.ResourceClaim) (map[string][]string, error) {
+	devices := map[string][]string{}
+	if claim.Status.Allocation == nil {
+		return devices, nil
+	}
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		config := deviceConfig{}
+		if err := driver.DecodeDeviceConfig(claim, result.Driver, result.Request, &config); err != nil {
+			return nil, err
+		}
+		if config.IPAM.Pool == "" {
+			continue
+		}
+		if err := config.IPAM.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid IPAM configuration for request %s of claim %s/%s: %w", result.Request, claim.Namespace, claim.Name, err)
+		}
+		devices[config.IPAM.Pool] = append(devices[config.IPAM.Pool], ipam.ResultKey(result))
+	}
+	return devices, nil
+}
+
+func resultsEqual(a, b *ipam.Result) bool {
+	return slices.Equal(a.Addresses, b.Addresses) && slices.Equal(a.Gateways, b.Gateways)
+}
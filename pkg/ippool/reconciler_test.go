@@ -0,0 +1,166 @@
+package ippool
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+)
+
+func newTestReconciler(t *testing.T, pool *IPPool, claims ...runtime.Object) *Reconciler {
+	t.Helper()
+	obj, err := ToUnstructured(pool)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{GroupVersionResource: "IPPoolList"}, obj)
+	return NewReconciler(fake.NewSimpleClientset(claims...), dynamicClient)
+}
+
+func allocatedClaim(name string, pool string) *resourceapi.ResourceClaim {
+	parameters, _ := json.Marshal(deviceConfig{IPAM: ipam.Config{Pool: pool}})
+	claim := testClaim(name, types.UID("uid-"+name))
+	claim.Status.Allocation = &resourceapi.AllocationResult{
+		Devices: resourceapi.DeviceAllocationResult{
+			Results: []resourceapi.DeviceRequestAllocationResult{
+				{Request: "req", Driver: "example.com", Pool: "node", Device: "eth0"},
+			},
+			Config: []resourceapi.DeviceAllocationConfiguration{{
+				Source: resourceapi.AllocationConfigSourceClaim,
+				DeviceConfiguration: resourceapi.DeviceConfiguration{
+					Opaque: &resourceapi.OpaqueDeviceConfiguration{
+						Driver:     "example.com",
+						Parameters: runtime.RawExtension{Raw: parameters},
+					},
+				},
+			}},
+		},
+	}
+	return claim
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	pool := &IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+		Spec:       IPPoolSpec{Ranges: []ipam.Range{{Subnet: "10.0.0.0/24", Gateway: "10.0.0.1"}}},
+	}
+	claim := allocatedClaim("a", "pool")
+	r := newTestReconciler(t, pool, claim)
+
+	slice, err := r.Reconcile(ctx, claim)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if slice != nil {
+		t.Errorf("unexpected ResourceSlice %v", slice)
+	}
+
+	updated, err := r.kubeClient.ResourceV1().ResourceClaims("default").Get(ctx, "a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	device := updated.Status.Allocation.Devices.Results[0]
+	result, err := ipam.PoolResult(updated, device, ipam.Config{Pool: "pool", Routes: []ipam.Route{{Destination: "10.1.0.0/16"}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Addresses) != 1 || result.Addresses[0].String() != "10.0.0.2/24" {
+		t.Errorf("unexpected addresses %v", result.Addresses)
+	}
+	if len(result.Routes) != 1 || result.Routes[0].Gateway.String() != "10.0.0.1" {
+		t.Errorf("unexpected routes %v", result.Routes)
+	}
+
+	stored, err := r.getPool(ctx, "pool")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if owner, ok := stored.Status.Allocations["10.0.0.2"]; !ok || owner.ClaimUID != claim.UID || owner.Device != "req/eth0" {
+		t.Errorf("unexpected pool allocations %v", stored.Status.Allocations)
+	}
+
+	// a second reconciliation keeps the same address
+	if _, err := r.Reconcile(ctx, updated); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, err = r.getPool(ctx, "pool")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stored.Status.Allocations) != 1 {
+		t.Errorf("unexpected pool allocations %v", stored.Status.Allocations)
+	}
+
+	if err := r.Delete(ctx, updated); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, err = r.getPool(ctx, "pool")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stored.Status.Allocations) != 0 {
+		t.Errorf("expected no allocations after delete, got %v", stored.Status.Allocations)
+	}
+}
+
+func TestGarbageCollect(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	pool := &IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+		Spec:       IPPoolSpec{Ranges: []ipam.Range{{Subnet: "10.0.0.0/24"}}},
+		Status: IPPoolStatus{Allocations: map[string]Allocation{
+			// live claim
+			"10.0.0.1": {ClaimNamespace: "default", ClaimName: "a", ClaimUID: "uid-a", Device: "req/eth0", Timestamp: metav1.NewTime(now.Add(-time.Hour))},
+			// deleted claim
+			"10.0.0.2": {ClaimNamespace: "default", ClaimName: "b", ClaimUID: "uid-b", Device: "req/eth0", Timestamp: metav1.NewTime(now.Add(-time.Hour))},
+			// claim not listed yet
+			"10.0.0.3": {ClaimNamespace: "default", ClaimName: "c", ClaimUID: "uid-c", Device: "req/eth0", Timestamp: metav1.NewTime(now)},
+		}},
+	}
+	r := newTestReconciler(t, pool, allocatedClaim("a", "pool"))
+	r.now = func() time.Time { return now }
+
+	if err := r.GarbageCollect(ctx, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, err := r.getPool(ctx, "pool")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := stored.Status.Allocations["10.0.0.2"]; ok {
+		t.Errorf("leaked address was not released")
+	}
+	if len(stored.Status.Allocations) != 2 {
+		t.Errorf("unexpected allocations %v", stored.Status.Allocations)
+	}
+}
+
+func TestIsClaimRelevant(t *testing.T) {
+	r := NewReconciler(fake.NewSimpleClientset(), nil)
+
+	if !r.IsClaimRelevant(allocatedClaim("a", "pool")) {
+		t.Errorf("claim with a pool not relevant")
+	}
+	claim := allocatedClaim("b", "")
+	if r.IsClaimRelevant(claim) {
+		t.Errorf("claim without a pool relevant")
+	}
+	// the addresses of a previous allocation are released
+	claim.Annotations = map[string]string{ipam.ClaimAnnotation: "{}"}
+	if !r.IsClaimRelevant(claim) {
+		t.Errorf("claim with recorded addresses not relevant")
+	}
+}
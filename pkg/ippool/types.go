@@ -0,0 +1,80 @@
+package ippool
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+)
+
+const (
+	// Group of the IPPool custom resource.
+	Group = "knd.x-k8s.io"
+	// Version of the IPPool custom resource.
+	Version = "v1alpha1"
+	// Kind of the IPPool custom resource.
+	Kind = "IPPool"
+)
+
+// GroupVersionResource identifies the cluster scoped IPPool custom resource.
+var GroupVersionResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "ippools"}
+
+// IPPool is a cluster wide pool of addresses, the allocations are recorded in its
+// status so they are unique across all the nodes.
+type IPPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IPPoolSpec   `json:"spec"`
+	Status IPPoolStatus `json:"status,omitempty"`
+}
+
+// IPPoolSpec is the desired state of an IPPool.
+type IPPoolSpec struct {
+	// Ranges of the pool, one address is allocated from each of them to every device.
+	Ranges []ipam.Range `json:"ranges"`
+}
+
+// IPPoolStatus records the addresses handed out from an IPPool.
+type IPPoolStatus struct {
+	// Allocations maps the allocated addresses to their owners.
+	Allocations map[string]Allocation `json:"allocations,omitempty"`
+}
+
+// Allocation is the owner of an allocated address.
+type Allocation struct {
+	// ClaimNamespace and ClaimName of the ResourceClaim the address is allocated to.
+	ClaimNamespace string `json:"claimNamespace"`
+	ClaimName      string `json:"claimName"`
+	// ClaimUID of the ResourceClaim, a claim recreated with the same name is a different owner.
+	ClaimUID types.UID `json:"claimUID"`
+	// Device is the ipam.ResultKey of the allocated device inside the claim.
+	Device string `json:"device"`
+	// Timestamp of the allocation, recent allocations are not garbage collected.
+	Timestamp metav1.Time `json:"timestamp"`
+}
+
+// FromUnstructured converts the object returned by the dynamic client to an IPPool.
+func FromUnstructured(obj *unstructured.Unstructured) (*IPPool, error) {
+	pool := &IPPool{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), pool); err != nil {
+		return nil, fmt.Errorf("invalid IPPool %s: %w", obj.GetName(), err)
+	}
+	return pool, nil
+}
+
+// ToUnstructured converts the IPPool to the object used by the dynamic client.
+func ToUnstructured(pool *IPPool) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pool)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	obj.SetGroupVersionKind(GroupVersionResource.GroupVersion().WithKind(Kind))
+	return obj, nil
+}
@@ -0,0 +1,165 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// ethernetBroadcast is the destination of the gratuitous ARPs.
+	ethernetBroadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	// allNodesMulticast is the destination of the unsolicited neighbor advertisements,
+	// ff02::1 and its ethernet multicast address.
+	allNodesMulticast    = net.ParseIP("ff02::1")
+	allNodesMulticastMAC = net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x01}
+)
+
+// NsAnnounceAddresses sends count gratuitous ARPs for the IPv4 addresses and count
+// unsolicited neighbor advertisements for the IPv6 addresses of the interface inside
+// the namespace at containerNsPath, waiting interval between them, so the switches
+// and the peers update the entries that point to the previous owner of the
+// addresses. The addresses are listed before every round, the IPv6 addresses that
+// are still tentative are announced once the duplicate address detection succeeds.
+func NsAnnounceAddresses(containerNsPath string, ifName string, count int, interval time.Duration) error {
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPath, ifName, err)
+	}
+	defer containerNs.Close()
+	// to avoid golang problem with goroutines we create the socket in the
+	// namespace and use it directly
+	nhNs, err := netlink.NewHandleAt(containerNs)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace handle: %w", err)
+	}
+	defer nhNs.Close()
+
+	link, err := nhNs.LinkByName(ifName)
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return fmt.Errorf("link not found for interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+	hwAddr := link.Attrs().HardwareAddr
+	if len(hwAddr) != 6 {
+		return fmt.Errorf("interface %s has no ethernet address", ifName)
+	}
+
+	// the packet socket only sends, protocol 0 does not receive any frame
+	var fd int
+	err = inNamespace(containerNs, func() error {
+		var err error
+		fd, err = unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create packet socket on namespace %s: %w", containerNsPath, err)
+	}
+	defer unix.Close(fd)
+
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		addrs, err := nhNs.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+			return fmt.Errorf("failed to list addresses of interface %s: %w", ifName, err)
+		}
+		for _, addr := range addrs {
+			if addr.Flags&(unix.IFA_F_TENTATIVE|unix.IFA_F_DADFAILED) != 0 {
+				continue
+			}
+			var packet []byte
+			sockaddr := &unix.SockaddrLinklayer{Ifindex: link.Attrs().Index, Halen: 6}
+			if ip4 := addr.IP.To4(); ip4 != nil {
+				packet = gratuitousARP(hwAddr, ip4)
+				sockaddr.Protocol = htons(unix.ETH_P_ARP)
+				copy(sockaddr.Addr[:], ethernetBroadcast)
+			} else {
+				packet = unsolicitedNA(hwAddr, addr.IP)
+				sockaddr.Protocol = htons(unix.ETH_P_IPV6)
+				copy(sockaddr.Addr[:], allNodesMulticastMAC)
+			}
+			if err := unix.Sendto(fd, packet, 0, sockaddr); err != nil {
+				return fmt.Errorf("failed to announce address %s of interface %s: %w", addr.IP, ifName, err)
+			}
+			klog.V(4).Infof("Announced address %s of interface %s on namespace %s", addr.IP, ifName, containerNsPath)
+		}
+	}
+	return nil
+}
+
+// gratuitousARP returns an ARP request for the address sent by its owner.
+func gratuitousARP(hwAddr net.HardwareAddr, ip net.IP) []byte {
+	packet := make([]byte, 28)
+	binary.BigEndian.PutUint16(packet[0:], 1) // ethernet
+	binary.BigEndian.PutUint16(packet[2:], unix.ETH_P_IP)
+	packet[4] = 6                             // hardware address length
+	packet[5] = 4                             // protocol address length
+	binary.BigEndian.PutUint16(packet[6:], 1) // request
+	copy(packet[8:], hwAddr)
+	copy(packet[14:], ip)
+	// the target hardware address is unknown and the target address is the sender one
+	copy(packet[24:], ip)
+	return packet
+}
+
+// unsolicitedNA returns an IPv6 packet with a neighbor advertisement of the address
+// to all the nodes, with the override flag and the target link-layer address.
+func unsolicitedNA(hwAddr net.HardwareAddr, ip net.IP) []byte {
+	const (
+		headerLen  = 40
+		payloadLen = 32
+	)
+	packet := make([]byte, headerLen+payloadLen)
+	packet[0] = 6 << 4 // version
+	binary.BigEndian.PutUint16(packet[4:], payloadLen)
+	packet[6] = unix.IPPROTO_ICMPV6
+	packet[7] = 255 // hop limit, required by the neighbor discovery
+	copy(packet[8:], ip.To16())
+	copy(packet[24:], allNodesMulticast)
+
+	icmp := packet[headerLen:]
+	icmp[0] = 136  // neighbor advertisement
+	icmp[4] = 0x20 // override
+	copy(icmp[8:], ip.To16())
+	icmp[24] = 2 // target link-layer address option
+	icmp[25] = 1 // option length in units of 8 bytes
+	copy(icmp[26:], hwAddr)
+	binary.BigEndian.PutUint16(icmp[2:], icmpv6Checksum(packet[8:24], packet[24:40], icmp))
+	return packet
+}
+
+// icmpv6Checksum returns the checksum of the ICMPv6 message, that covers the IPv6
+// pseudo header.
+func icmpv6Checksum(src, dst, message []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src)
+	add(dst)
+	sum += uint32(len(message))
+	sum += unix.IPPROTO_ICMPV6
+	add(message)
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// htons converts the protocol to the network byte order expected by the packet sockets.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,128 @@
+package net
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+func TestNsAnnounceAddresses(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Test requires root privileges.")
+	}
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("unexpected error trying to get namespace: %v", err)
+	}
+	defer origns.Close()
+
+	rndString := make([]byte, 4)
+	_, err = rand.Read(rndString)
+	if err != nil {
+		t.Errorf("fail to generate random name: %v", err)
+	}
+	nsName := fmt.Sprintf("ns%x", rndString)
+	testNS, err := netns.NewNamed(nsName)
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer netns.DeleteNamed(nsName)
+	defer testNS.Close()
+
+	// Switch back to the original namespace
+	netns.Set(origns)
+
+	hostIfName := fmt.Sprintf("veth%x", rndString)
+	hostLink, _, err := NsAddVeth(hostIfName, path.Join("/run/netns", nsName), netlink.LinkAttrs{Name: "net1"},
+		[]*net.IPNet{{IP: net.ParseIP("192.168.77.2"), Mask: net.CIDRMask(24, 32)}})
+	if err != nil {
+		t.Fatalf("fail to create veth pair: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = DelHostLink(hostIfName)
+	})
+
+	// the duplicate address detection would delay the first advertisement
+	nhNs, err := netlink.NewHandleAt(testNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nhNs.Close()
+	nsLink, err := nhNs.LinkByName("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = nhNs.AddrAdd(nsLink, &netlink.Addr{
+		IPNet: &net.IPNet{IP: net.ParseIP("2001:db8::2"), Mask: net.CIDRMask(64, 128)},
+		Flags: unix.IFA_F_NODAD,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// capture the frames received on the host side of the veth pair
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: hostLink.Attrs().Index}); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Usec: 500000}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NsAnnounceAddresses(path.Join("/run/netns", nsName), "net1", 2, 100*time.Millisecond); err != nil {
+		t.Fatalf("fail to announce addresses: %v", err)
+	}
+
+	hwAddr := nsLink.Attrs().HardwareAddr
+	garps, nas := 0, 0
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			break
+		}
+		frame := buf[:n]
+		if n < 14 {
+			continue
+		}
+		payload := frame[14:]
+		switch binary.BigEndian.Uint16(frame[12:]) {
+		case unix.ETH_P_ARP:
+			if len(payload) >= 28 && net.HardwareAddr(payload[8:14]).String() == hwAddr.String() &&
+				net.IP(payload[14:18]).Equal(net.ParseIP("192.168.77.2")) && net.IP(payload[24:28]).Equal(net.ParseIP("192.168.77.2")) {
+				garps++
+			}
+		case unix.ETH_P_IPV6:
+			if len(payload) < 72 || payload[40] != 136 || !net.IP(payload[48:64]).Equal(net.ParseIP("2001:db8::2")) {
+				continue
+			}
+			if net.HardwareAddr(payload[66:72]).String() != hwAddr.String() {
+				t.Errorf("unexpected target link-layer address %s", net.HardwareAddr(payload[66:72]))
+			}
+			if icmpv6Checksum(payload[8:24], payload[24:40], payload[40:72]) != 0 {
+				t.Errorf("invalid checksum of the neighbor advertisement")
+			}
+			nas++
+		}
+	}
+	if garps != 2 {
+		t.Errorf("expected 2 gratuitous ARPs, got %d", garps)
+	}
+	if nas != 2 {
+		t.Errorf("expected 2 unsolicited neighbor advertisements, got %d", nas)
+	}
+}
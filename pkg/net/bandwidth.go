@@ -0,0 +1,308 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// tbfLatency is the maximum time a packet can wait in the egress queue, in microseconds.
+	tbfLatency = 25000
+	// minBurst is the minimum burst in bytes, it must fit several packets of the
+	// largest MTU or the rate is never reached.
+	minBurst = 64 * 1024
+	// ifbPrefix is the prefix of the IFB device that shapes the ingress traffic of an interface.
+	ifbPrefix = "ifb-"
+)
+
+// Bandwidth are the rate limits of the interface of a pod.
+type Bandwidth struct {
+	// EgressRate limits the traffic sent by the pod, in bits per second.
+	EgressRate *resource.Quantity `json:"egressRate,omitempty"`
+	// EgressBurst is the amount of bytes that can be sent at once over the rate, it
+	// defaults to the bytes sent at the rate in 10 milliseconds.
+	EgressBurst *resource.Quantity `json:"egressBurst,omitempty"`
+	// IngressRate limits the traffic received by the pod, in bits per second.
+	IngressRate *resource.Quantity `json:"ingressRate,omitempty"`
+	// IngressBurst is the amount of bytes that can be received at once over the rate,
+	// it defaults to the bytes received at the rate in 10 milliseconds.
+	IngressBurst *resource.Quantity `json:"ingressBurst,omitempty"`
+}
+
+// Empty returns true if no limit is configured.
+func (b *Bandwidth) Empty() bool {
+	return b == nil || (b.EgressRate == nil && b.IngressRate == nil)
+}
+
+// Validate checks the limits.
+func (b *Bandwidth) Validate() error {
+	if b == nil {
+		return nil
+	}
+	if b.EgressBurst != nil && b.EgressRate == nil {
+		return fmt.Errorf("egress burst requires an egress rate")
+	}
+	if b.IngressBurst != nil && b.IngressRate == nil {
+		return fmt.Errorf("ingress burst requires an ingress rate")
+	}
+	for name, q := range map[string]*resource.Quantity{
+		"egress rate":   b.EgressRate,
+		"egress burst":  b.EgressBurst,
+		"ingress rate":  b.IngressRate,
+		"ingress burst": b.IngressBurst,
+	} {
+		if q != nil && q.Sign() <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", name, q)
+		}
+	}
+	for name, q := range map[string]*resource.Quantity{"egress burst": b.EgressBurst, "ingress burst": b.IngressBurst} {
+		if q != nil && q.Value() > math.MaxUint32 {
+			return fmt.Errorf("%s %s is too high", name, q)
+		}
+	}
+	return nil
+}
+
+// AppliedBandwidth are the limits programmed in the kernel, they can differ from
+// the configured ones due to the precision of the traffic control parameters.
+type AppliedBandwidth struct {
+	// EgressRate in bits per second, 0 if it is not limited.
+	EgressRate uint64
+	// EgressBurst in bytes.
+	EgressBurst uint64
+	// IngressRate in bits per second, 0 if it is not limited.
+	IngressRate uint64
+	// IngressBurst in bytes.
+	IngressBurst uint64
+}
+
+func (a AppliedBandwidth) String() string {
+	return fmt.Sprintf("egress rate %d bps burst %d bytes, ingress rate %d bps burst %d bytes",
+		a.EgressRate, a.EgressBurst, a.IngressRate, a.IngressBurst)
+}
+
+// NsSetBandwidth limits the rates of the interface inside the pod namespace. The
+// egress traffic is shaped with a token bucket filter as root qdisc of the
+// interface. The ingress traffic is redirected to an IFB device and shaped with a
+// token bucket filter on its egress. It returns the limits applied by the kernel.
+func NsSetBandwidth(containerNsPath string, ifName string, bandwidth Bandwidth) (*AppliedBandwidth, error) {
+	if err := bandwidth.Validate(); err != nil {
+		return nil, err
+	}
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPath, ifName, err)
+	}
+	defer containerNs.Close()
+	// to avoid golang problem with goroutines we create the socket in the
+	// namespace and use it directly
+	nhNs, err := netlink.NewHandleAt(containerNs)
+	if err != nil {
+		return nil, fmt.Errorf("could not get network namespace handle: %w", err)
+	}
+	defer nhNs.Close()
+
+	link, err := nhNs.LinkByName(ifName)
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return nil, fmt.Errorf("link not found for interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+
+	if bandwidth.EgressRate != nil {
+		if err := setTbf(nhNs, link, bandwidth.EgressRate, bandwidth.EgressBurst); err != nil {
+			return nil, fmt.Errorf("failed to set egress rate on interface %s: %w", ifName, err)
+		}
+	}
+
+	if bandwidth.IngressRate != nil {
+		ifb, err := ensureIfb(nhNs, link)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create IFB device for interface %s: %w", ifName, err)
+		}
+		if err := setTbf(nhNs, ifb, bandwidth.IngressRate, bandwidth.IngressBurst); err != nil {
+			return nil, fmt.Errorf("failed to set ingress rate on interface %s: %w", ifName, err)
+		}
+		ingress := &netlink.Ingress{
+			QdiscAttrs: netlink.QdiscAttrs{
+				LinkIndex: link.Attrs().Index,
+				Handle:    netlink.MakeHandle(0xffff, 0),
+				Parent:    netlink.HANDLE_INGRESS,
+			},
+		}
+		if err := nhNs.QdiscReplace(ingress); err != nil {
+			return nil, fmt.Errorf("failed to add ingress qdisc on interface %s: %w", ifName, err)
+		}
+		// a selector without keys matches all the packets
+		filter := &netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: link.Attrs().Index,
+				Parent:    ingress.Handle,
+				Priority:  1,
+				Protocol:  unix.ETH_P_ALL,
+			},
+			ClassId: netlink.MakeHandle(1, 1),
+			Actions: []netlink.Action{netlink.NewMirredAction(ifb.Attrs().Index)},
+		}
+		if err := nhNs.FilterReplace(filter); err != nil {
+			return nil, fmt.Errorf("failed to redirect ingress traffic of interface %s: %w", ifName, err)
+		}
+	}
+	return getBandwidth(nhNs, link)
+}
+
+// setTbf replaces the root qdisc of the link with a token bucket filter.
+func setTbf(nhNs *netlink.Handle, link netlink.Link, rateBits *resource.Quantity, burstBytes *resource.Quantity) error {
+	rate := uint64(rateBits.Value()) / 8
+	burst := defaultBurst(rate, burstBytes)
+	qdisc := &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(1, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		Rate:   rate,
+		Buffer: netlink.Xmittime(rate, burst),
+		Limit:  uint32(min(rate*tbfLatency/netlink.TIME_UNITS_PER_SEC+uint64(burst), math.MaxUint32)),
+	}
+	return nhNs.QdiscReplace(qdisc)
+}
+
+// ifbName returns the name of the IFB device of the interface.
+func ifbName(ifName string) string {
+	name := ifbPrefix + ifName
+	if len(name) > unix.IFNAMSIZ-1 {
+		name = name[:unix.IFNAMSIZ-1]
+	}
+	return name
+}
+
+// ensureIfb returns the IFB device of the link, it is created if it does not exist.
+func ensureIfb(nhNs *netlink.Handle, link netlink.Link) (netlink.Link, error) {
+	name := ifbName(link.Attrs().Name)
+	ifb, err := nhNs.LinkByName(name)
+	if err == nil {
+		return ifb, nil
+	}
+	err = nhNs.LinkAdd(&netlink.Ifb{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:   name,
+			MTU:    link.Attrs().MTU,
+			TxQLen: 1000,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ifb, err = nhNs.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := nhNs.LinkSetUp(ifb); err != nil {
+		return nil, err
+	}
+	return ifb, nil
+}
+
+// NsGetBandwidth returns the limits applied to the interface inside the pod namespace.
+func NsGetBandwidth(containerNsPath string, ifName string) (*AppliedBandwidth, error) {
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPath, ifName, err)
+	}
+	defer containerNs.Close()
+	nhNs, err := netlink.NewHandleAt(containerNs)
+	if err != nil {
+		return nil, fmt.Errorf("could not get network namespace handle: %w", err)
+	}
+	defer nhNs.Close()
+
+	link, err := nhNs.LinkByName(ifName)
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return nil, fmt.Errorf("link not found for interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+	return getBandwidth(nhNs, link)
+}
+
+// NsRemoveBandwidth removes the limits of the interface inside the pod namespace,
+// the interface keeps its qdiscs when it is moved back to the host otherwise.
+func NsRemoveBandwidth(containerNsPath string, ifName string) error {
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPath, ifName, err)
+	}
+	defer containerNs.Close()
+	nhNs, err := netlink.NewHandleAt(containerNs)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace handle: %w", err)
+	}
+	defer nhNs.Close()
+
+	link, err := nhNs.LinkByName(ifName)
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return fmt.Errorf("link not found for interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+	qdiscs, err := nhNs.QdiscList(link)
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return fmt.Errorf("failed to list qdiscs of interface %s: %w", ifName, err)
+	}
+	for _, qdisc := range qdiscs {
+		switch qdisc.(type) {
+		case *netlink.Tbf, *netlink.Ingress:
+			if err := nhNs.QdiscDel(qdisc); err != nil && !errors.Is(err, unix.ENOENT) {
+				return fmt.Errorf("failed to remove %s qdisc of interface %s: %w", qdisc.Type(), ifName, err)
+			}
+		}
+	}
+	if ifb, err := nhNs.LinkByName(ifbName(ifName)); err == nil {
+		if err := nhNs.LinkDel(ifb); err != nil {
+			return fmt.Errorf("failed to remove IFB device of interface %s: %w", ifName, err)
+		}
+	}
+	return nil
+}
+
+func getBandwidth(nhNs *netlink.Handle, link netlink.Link) (*AppliedBandwidth, error) {
+	applied := &AppliedBandwidth{}
+	var err error
+	applied.EgressRate, applied.EgressBurst, err = getTbf(nhNs, link)
+	if err != nil {
+		return nil, err
+	}
+	if ifb, err := nhNs.LinkByName(ifbName(link.Attrs().Name)); err == nil {
+		applied.IngressRate, applied.IngressBurst, err = getTbf(nhNs, ifb)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return applied, nil
+}
+
+// getTbf returns the rate in bits per second and the burst in bytes of the root
+// token bucket filter of the link, or zero if there is none.
+func getTbf(nhNs *netlink.Handle, link netlink.Link) (uint64, uint64, error) {
+	qdiscs, err := nhNs.QdiscList(link)
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return 0, 0, fmt.Errorf("failed to list qdiscs of interface %s: %w", link.Attrs().Name, err)
+	}
+	for _, qdisc := range qdiscs {
+		tbf, ok := qdisc.(*netlink.Tbf)
+		if ok && tbf.Parent == netlink.HANDLE_ROOT {
+			return tbf.Rate * 8, uint64(netlink.Xmitsize(tbf.Rate, tbf.Buffer)), nil
+		}
+	}
+	return 0, 0, nil
+}
+
+// defaultBurst returns the configured burst, or the bytes sent at the rate in 10
+// milliseconds, with a minimum of minBurst.
+func defaultBurst(rate uint64, burst *resource.Quantity) uint32 {
+	if burst != nil {
+		return uint32(burst.Value())
+	}
+	return uint32(min(max(rate/100, minBurst), math.MaxUint32))
+}
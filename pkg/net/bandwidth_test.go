@@ -0,0 +1,105 @@
+package net
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestNsSetBandwidth(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Test requires root privileges.")
+	}
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("unexpected error trying to get namespace: %v", err)
+	}
+	defer origns.Close()
+
+	rndString := make([]byte, 4)
+	_, err = rand.Read(rndString)
+	if err != nil {
+		t.Errorf("fail to generate random name: %v", err)
+	}
+	nsName := fmt.Sprintf("ns%x", rndString)
+	testNS, err := netns.NewNamed(nsName)
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer netns.DeleteNamed(nsName)
+	defer testNS.Close()
+
+	// Switch back to the original namespace
+	netns.Set(origns)
+
+	hostIfName := fmt.Sprintf("veth%x", rndString)
+	_, _, err = NsAddVeth(hostIfName, path.Join("/run/netns", nsName), netlink.LinkAttrs{Name: "net1"}, nil)
+	if err != nil {
+		t.Fatalf("fail to create veth pair: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = DelHostLink(hostIfName)
+	})
+
+	for name, bandwidth := range map[string]Bandwidth{
+		"negative rate":      {EgressRate: resource.NewQuantity(-1, resource.DecimalSI)},
+		"burst without rate": {IngressBurst: resource.NewQuantity(1000, resource.DecimalSI)},
+	} {
+		if _, err := NsSetBandwidth(path.Join("/run/netns", nsName), "net1", bandwidth); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	egressRate := resource.MustParse("10M")
+	ingressRate := resource.MustParse("20M")
+	ingressBurst := resource.MustParse("100k")
+	applied, err := NsSetBandwidth(path.Join("/run/netns", nsName), "net1", Bandwidth{
+		EgressRate:   &egressRate,
+		IngressRate:  &ingressRate,
+		IngressBurst: &ingressBurst,
+	})
+	if err != nil {
+		t.Fatalf("fail to set bandwidth: %v", err)
+	}
+	if applied.EgressRate != 10000000 || applied.IngressRate != 20000000 {
+		t.Errorf("unexpected applied rates: %s", applied)
+	}
+	// the burst is converted to time units and back
+	if applied.EgressBurst < minBurst*99/100 || applied.EgressBurst > minBurst*101/100 {
+		t.Errorf("unexpected applied egress burst: %s", applied)
+	}
+	if applied.IngressBurst < 99000 || applied.IngressBurst > 101000 {
+		t.Errorf("unexpected applied ingress burst: %s", applied)
+	}
+
+	// setting the limits again replaces them
+	egressRate = resource.MustParse("5M")
+	if _, err := NsSetBandwidth(path.Join("/run/netns", nsName), "net1", Bandwidth{EgressRate: &egressRate}); err != nil {
+		t.Fatalf("fail to replace bandwidth: %v", err)
+	}
+	applied, err = NsGetBandwidth(path.Join("/run/netns", nsName), "net1")
+	if err != nil {
+		t.Fatalf("fail to get bandwidth: %v", err)
+	}
+	if applied.EgressRate != 5000000 {
+		t.Errorf("unexpected applied egress rate: %s", applied)
+	}
+
+	if err := NsRemoveBandwidth(path.Join("/run/netns", nsName), "net1"); err != nil {
+		t.Fatalf("fail to remove bandwidth: %v", err)
+	}
+	applied, err = NsGetBandwidth(path.Join("/run/netns", nsName), "net1")
+	if err != nil {
+		t.Fatalf("fail to get bandwidth: %v", err)
+	}
+	if *applied != (AppliedBandwidth{}) {
+		t.Errorf("expected no limits after removal, got %s", applied)
+	}
+}
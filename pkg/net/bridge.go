@@ -0,0 +1,130 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// defaultBridgePVID is the VLAN the kernel adds untagged to every bridge port.
+const defaultBridgePVID = 1
+
+// BridgeConfig describes a Linux bridge.
+type BridgeConfig struct {
+	// MTU of the bridge, it is only used if the bridge is created.
+	MTU int
+	// VlanFiltering enables the VLAN filtering on the bridge.
+	VlanFiltering bool
+}
+
+// EnsureBridge returns the bridge with the given name in the host namespace,
+// creating and setting it up if it does not exist.
+func EnsureBridge(name string, config BridgeConfig) (*netlink.Bridge, error) {
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		bridge, ok := link.(*netlink.Bridge)
+		if !ok {
+			return nil, fmt.Errorf("interface %s already exists and is not a bridge", name)
+		}
+		if config.VlanFiltering && (bridge.VlanFiltering == nil || !*bridge.VlanFiltering) {
+			if err := netlink.BridgeSetVlanFiltering(bridge, true); err != nil {
+				return nil, fmt.Errorf("failed to enable VLAN filtering on bridge %s: %w", name, err)
+			}
+			bridge.VlanFiltering = &config.VlanFiltering
+		}
+		return bridge, nil
+	}
+	var notFound netlink.LinkNotFoundError
+	if !errors.As(err, &notFound) {
+		return nil, err
+	}
+
+	bridge := &netlink.Bridge{
+		LinkAttrs: netlink.LinkAttrs{
+			Name: name,
+			MTU:  config.MTU,
+		},
+	}
+	// Only send the attribute if needed, kernels built without VLAN filtering reject it.
+	if config.VlanFiltering {
+		bridge.VlanFiltering = &config.VlanFiltering
+	}
+	if err := netlink.LinkAdd(bridge); err != nil && !errors.Is(err, unix.EEXIST) {
+		return nil, fmt.Errorf("failed to create bridge %s: %w", name, err)
+	}
+	if err := netlink.LinkSetUp(bridge); err != nil {
+		return nil, fmt.Errorf("failed to set up bridge %s: %w", name, err)
+	}
+	link, err = netlink.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	bridge, ok := link.(*netlink.Bridge)
+	if !ok {
+		return nil, fmt.Errorf("interface %s is not a bridge", name)
+	}
+	return bridge, nil
+}
+
+// BridgePortConfig describes how an interface is attached to a bridge.
+type BridgePortConfig struct {
+	// Vlan is the untagged VLAN of the port, it requires VLAN filtering on the bridge.
+	Vlan int
+	// TrunkVlans are the tagged VLANs allowed on the port, they require VLAN filtering on the bridge.
+	TrunkVlans []VlanRange
+	// Hairpin allows the traffic to be sent back through the port it was received on.
+	Hairpin bool
+	// Learning enables MAC address learning on the port, the kernel enables it by default.
+	Learning *bool
+}
+
+// SetBridgePort attaches the host interface link to the bridge and configures the port.
+func SetBridgePort(link netlink.Link, bridge *netlink.Bridge, config BridgePortConfig) error {
+	name := link.Attrs().Name
+	if err := netlink.LinkSetMaster(link, bridge); err != nil {
+		return fmt.Errorf("failed to attach interface %s to bridge %s: %w", name, bridge.Name, err)
+	}
+
+	if config.Hairpin {
+		if err := netlink.LinkSetHairpin(link, true); err != nil {
+			return fmt.Errorf("failed to enable hairpin mode on interface %s: %w", name, err)
+		}
+	}
+
+	if config.Learning != nil {
+		if err := netlink.LinkSetLearning(link, *config.Learning); err != nil {
+			return fmt.Errorf("failed to set MAC learning on interface %s: %w", name, err)
+		}
+	}
+
+	if config.Vlan == 0 && len(config.TrunkVlans) == 0 {
+		return nil
+	}
+	if bridge.VlanFiltering == nil || !*bridge.VlanFiltering {
+		return fmt.Errorf("bridge %s does not have VLAN filtering enabled", bridge.Name)
+	}
+
+	// Replace the default VLAN added by the kernel to every new port.
+	err := netlink.BridgeVlanDel(link, defaultBridgePVID, true, true, false, true)
+	if err != nil && !errors.Is(err, unix.ENOENT) {
+		return fmt.Errorf("failed to delete default VLAN from interface %s: %w", name, err)
+	}
+	if config.Vlan != 0 {
+		if err := netlink.BridgeVlanAdd(link, uint16(config.Vlan), true, true, false, true); err != nil {
+			return fmt.Errorf("failed to add VLAN %d to interface %s: %w", config.Vlan, name, err)
+		}
+	}
+	for _, r := range config.TrunkVlans {
+		if r.Min == r.Max {
+			err = netlink.BridgeVlanAdd(link, uint16(r.Min), false, false, false, true)
+		} else {
+			err = netlink.BridgeVlanAddRange(link, uint16(r.Min), uint16(r.Max), false, false, false, true)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add VLANs %s to interface %s: %w", r, name, err)
+		}
+	}
+	return nil
+}
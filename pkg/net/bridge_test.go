@@ -0,0 +1,97 @@
+package net
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func Test_bridgePort(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Test requires root privileges.")
+	}
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("unexpected error trying to get namespace: %v", err)
+	}
+	defer origns.Close()
+
+	rndString := make([]byte, 4)
+	_, err = rand.Read(rndString)
+	if err != nil {
+		t.Errorf("fail to generate random name: %v", err)
+	}
+	nsName := fmt.Sprintf("ns%x", rndString)
+	testNS, err := netns.NewNamed(nsName)
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer netns.DeleteNamed(nsName)
+	defer testNS.Close()
+
+	// Switch back to the original namespace
+	netns.Set(origns)
+
+	bridgeName := fmt.Sprintf("br%x", rndString)
+	bridge, err := EnsureBridge(bridgeName, BridgeConfig{})
+	if err != nil {
+		t.Fatalf("fail to create bridge: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = DelHostLink(bridgeName)
+	})
+
+	hostIfName := fmt.Sprintf("veth%x", rndString)
+	hostLink, networkData, err := NsAddVeth(hostIfName, path.Join("/run/netns", nsName), netlink.LinkAttrs{Name: "net1", MTU: 1400}, nil)
+	if err != nil {
+		t.Fatalf("fail to create veth pair: %v", err)
+	}
+	if networkData.InterfaceName != "net1" {
+		t.Errorf("expected interface net1 got %s", networkData.InterfaceName)
+	}
+
+	err = SetBridgePort(hostLink, bridge, BridgePortConfig{Hairpin: true})
+	if err != nil {
+		t.Fatalf("fail to configure bridge port: %v", err)
+	}
+
+	link, err := netlink.LinkByName(hostIfName)
+	if err != nil {
+		t.Fatalf("fail to get host interface: %v", err)
+	}
+	if link.Attrs().MasterIndex != bridge.Index {
+		t.Errorf("interface %s not attached to bridge %s", hostIfName, bridgeName)
+	}
+
+	protinfo, err := netlink.LinkGetProtinfo(link)
+	if err != nil {
+		t.Fatalf("fail to get bridge port information: %v", err)
+	}
+	if !protinfo.Hairpin {
+		t.Errorf("hairpin mode not enabled on interface %s", hostIfName)
+	}
+
+	// VLANs can not be configured on bridges without VLAN filtering
+	if err := SetBridgePort(hostLink, bridge, BridgePortConfig{Vlan: 100}); err == nil {
+		t.Errorf("expected error configuring VLAN on bridge %s without VLAN filtering", bridgeName)
+	}
+
+	// deleting the host end deletes the end in the namespace
+	if err := DelHostLink(hostIfName); err != nil {
+		t.Fatalf("fail to delete veth pair: %v", err)
+	}
+	nhNs, err := netlink.NewHandleAt(testNS)
+	if err != nil {
+		t.Fatalf("fail to open netlink handle: %v", err)
+	}
+	defer nhNs.Close()
+	if _, err := nhNs.LinkByName("net1"); err == nil {
+		t.Errorf("interface net1 still exists in namespace %s", nsName)
+	}
+}
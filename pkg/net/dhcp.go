@@ -0,0 +1,402 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+)
+
+const (
+	// defaultLeaseTime is used when the server does not send the lease time.
+	defaultLeaseTime = time.Hour
+	// minRenewRetry is the minimum time between two renewal attempts.
+	minRenewRetry = 10 * time.Second
+)
+
+// DHCPClient keeps the DHCPv4 lease of an interface inside a pod namespace,
+// renewing it in the background until it is stopped.
+type DHCPClient struct {
+	ifName          string
+	containerNsPath string
+	config          ipam.Config
+	client          *nclient4.Client
+	nhNs            *netlink.Handle
+	link            netlink.Link
+
+	mu    sync.Mutex
+	lease *nclient4.Lease
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NsStartDHCP obtains a DHCPv4 lease for the interface ifName inside the namespace
+// at containerNsPath and configures its address, MTU and routes. The routes of the
+// configuration without gateway use the router of the lease. If the configuration
+// requests it, it also waits for a global IPv6 address configured by SLAAC or DHCPv6.
+// The lease is renewed in the background until Stop is called.
+func NsStartDHCP(containerNsPath string, ifName string, config ipam.Config) (*DHCPClient, error) {
+	if config.DHCP == nil {
+		return nil, fmt.Errorf("interface %s does not have DHCP configured", ifName)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPath, ifName, err)
+	}
+	defer containerNs.Close()
+
+	// to avoid golang problem with goroutines we create the socket in the
+	// namespace and use it directly
+	nhNs, err := netlink.NewHandleAt(containerNs)
+	if err != nil {
+		return nil, fmt.Errorf("could not get network namespace handle: %w", err)
+	}
+
+	link, err := nhNs.LinkByName(ifName)
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		nhNs.Close()
+		return nil, fmt.Errorf("link not found for interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+	if err := nhNs.LinkSetUp(link); err != nil {
+		nhNs.Close()
+		return nil, fmt.Errorf("failed to set up interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+
+	// The raw socket of the client is bound to the interface of the namespace
+	// it is created in, it can be used from any thread afterwards.
+	timeout := config.DHCP.GetTimeout()
+	var client *nclient4.Client
+	err = inNamespace(containerNs, func() error {
+		var err error
+		client, err = nclient4.New(ifName, nclient4.WithTimeout(timeout/4))
+		return err
+	})
+	if err != nil {
+		nhNs.Close()
+		return nil, fmt.Errorf("failed to create DHCP client on interface %s: %w", ifName, err)
+	}
+
+	c := &DHCPClient{
+		ifName:          ifName,
+		containerNsPath: containerNsPath,
+		config:          config,
+		client:          client,
+		nhNs:            nhNs,
+		link:            link,
+		done:            make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	lease, err := client.Request(ctx, dhcpv4.WithRequestedOptions(dhcpv4.OptionInterfaceMTU, dhcpv4.OptionClasslessStaticRoute))
+	if err != nil {
+		c.close()
+		return nil, fmt.Errorf("failed to obtain a DHCP lease for interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+	if err := c.applyLease(lease); err != nil {
+		_ = c.release(lease)
+		c.close()
+		return nil, err
+	}
+	c.lease = lease
+	klog.Infof("DHCP lease %s obtained for interface %s on namespace %s", leaseAddress(lease), ifName, containerNsPath)
+
+	if config.DHCP.WaitIPv6 {
+		if err := c.waitIPv6(ctx); err != nil {
+			_ = c.release(lease)
+			c.close()
+			return nil, err
+		}
+	}
+
+	renewCtx, renewCancel := context.WithCancel(context.Background())
+	c.cancel = renewCancel
+	go c.renewLoop(renewCtx)
+	return c, nil
+}
+
+// Addresses returns the addresses configured on the interface by the DHCP client.
+func (c *DHCPClient) Addresses() []*net.IPNet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lease == nil {
+		return nil
+	}
+	return []*net.IPNet{leaseAddress(c.lease)}
+}
+
+// Stop stops the renewal of the lease, releases it and removes its address from
+// the interface, so it is not carried when the interface leaves the namespace.
+func (c *DHCPClient) Stop() error {
+	c.cancel()
+	<-c.done
+
+	c.mu.Lock()
+	lease := c.lease
+	c.lease = nil
+	c.mu.Unlock()
+
+	var errs []error
+	if lease != nil {
+		if err := c.release(lease); err != nil {
+			errs = append(errs, fmt.Errorf("failed to release DHCP lease of interface %s: %w", c.ifName, err))
+		}
+		err := c.nhNs.AddrDel(c.link, &netlink.Addr{IPNet: leaseAddress(lease)})
+		if err != nil && !errors.Is(err, unix.EADDRNOTAVAIL) && !errors.Is(err, unix.ENODEV) {
+			errs = append(errs, fmt.Errorf("failed to remove DHCP address of interface %s: %w", c.ifName, err))
+		}
+	}
+	c.close()
+	return errors.Join(errs...)
+}
+
+// release sends a DHCPRELEASE for the lease to the server. The raw socket of the
+// client uses 0.0.0.0 as source and the server drops it as a martian, so it is sent
+// from the lease address that is still configured on the interface.
+func (c *DHCPClient) release(lease *nclient4.Lease) error {
+	msg, err := dhcpv4.NewReleaseFromACK(lease.ACK)
+	if err != nil {
+		return err
+	}
+	serverID := lease.ACK.ServerIdentifier()
+	if serverID == nil {
+		return fmt.Errorf("lease does not have server identifier")
+	}
+
+	containerNs, err := netns.GetFromPath(c.containerNsPath)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace from path %s for network device %s : %w", c.containerNsPath, c.ifName, err)
+	}
+	defer containerNs.Close()
+
+	var conn *net.UDPConn
+	err = inNamespace(containerNs, func() error {
+		var err error
+		conn, err = net.DialUDP("udp4",
+			&net.UDPAddr{IP: lease.ACK.YourIPAddr, Port: dhcpv4.ClientPort},
+			&net.UDPAddr{IP: serverID, Port: dhcpv4.ServerPort})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(msg.ToBytes())
+	return err
+}
+
+func (c *DHCPClient) close() {
+	c.client.Close()
+	c.nhNs.Close()
+}
+
+// renewLoop renews the lease at the renewal time and obtains a new one if it expires.
+func (c *DHCPClient) renewLoop(ctx context.Context) {
+	defer close(c.done)
+
+	for {
+		c.mu.Lock()
+		lease := c.lease
+		c.mu.Unlock()
+
+		ack := lease.ACK
+		leaseTime := ack.IPAddressLeaseTime(defaultLeaseTime)
+		expiration := lease.CreationTime.Add(leaseTime)
+		renewal := lease.CreationTime.Add(ack.IPAddressRenewalTime(leaseTime / 2))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(renewal)):
+		}
+
+		newLease, err := c.renew(ctx, lease, expiration)
+		if err != nil {
+			klog.Errorf("failed to renew DHCP lease of interface %s on namespace %s: %v", c.ifName, c.containerNsPath, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(minRenewRetry):
+			}
+			continue
+		}
+		if err := c.applyLease(newLease); err != nil {
+			klog.Errorf("failed to apply DHCP lease of interface %s on namespace %s: %v", c.ifName, c.containerNsPath, err)
+		}
+		c.mu.Lock()
+		c.lease = newLease
+		c.mu.Unlock()
+	}
+}
+
+// renew tries to renew the lease until it expires, then it requests a new one.
+func (c *DHCPClient) renew(ctx context.Context, lease *nclient4.Lease, expiration time.Time) (*nclient4.Lease, error) {
+	for time.Now().Before(expiration) {
+		renewCtx, cancel := context.WithTimeout(ctx, c.config.DHCP.GetTimeout())
+		newLease, err := c.client.Renew(renewCtx, lease)
+		cancel()
+		if err == nil {
+			return newLease, nil
+		}
+		var nak *nclient4.ErrNak
+		if errors.As(err, &nak) {
+			break
+		}
+		klog.V(2).Infof("DHCP renewal of interface %s failed, retrying: %v", c.ifName, err)
+		retry := max(time.Until(expiration)/2, minRenewRetry)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+
+	// the address is not valid anymore
+	err := c.nhNs.AddrDel(c.link, &netlink.Addr{IPNet: leaseAddress(lease)})
+	if err != nil && !errors.Is(err, unix.EADDRNOTAVAIL) {
+		klog.Errorf("failed to remove expired DHCP address of interface %s: %v", c.ifName, err)
+	}
+	requestCtx, cancel := context.WithTimeout(ctx, c.config.DHCP.GetTimeout())
+	defer cancel()
+	return c.client.Request(requestCtx, dhcpv4.WithRequestedOptions(dhcpv4.OptionInterfaceMTU, dhcpv4.OptionClasslessStaticRoute))
+}
+
+// applyLease configures the address, the MTU and the routes of the lease.
+func (c *DHCPClient) applyLease(lease *nclient4.Lease) error {
+	ack := lease.ACK
+	leaseTime := ack.IPAddressLeaseTime(defaultLeaseTime)
+
+	addr := &netlink.Addr{
+		IPNet:       leaseAddress(lease),
+		ValidLft:    int(leaseTime.Seconds()),
+		PreferedLft: int(leaseTime.Seconds()),
+	}
+	if err := c.nhNs.AddrReplace(c.link, addr); err != nil {
+		return fmt.Errorf("failed to set up address %s on interface %s: %w", addr.IPNet, c.ifName, err)
+	}
+
+	if mtu, err := dhcpv4.GetUint16(dhcpv4.OptionInterfaceMTU, ack.Options); err == nil && int(mtu) != c.link.Attrs().MTU {
+		if err := c.nhNs.LinkSetMTU(c.link, int(mtu)); err != nil {
+			return fmt.Errorf("failed to set MTU %d on interface %s: %w", mtu, c.ifName, err)
+		}
+	}
+
+	var routes []ipam.ResolvedRoute
+	for _, route := range ack.ClasslessStaticRoute() {
+		ones, _ := route.Dest.Mask.Size()
+		dst, ok := netip.AddrFromSlice(route.Dest.IP.To4())
+		if !ok {
+			continue
+		}
+		resolved := ipam.ResolvedRoute{Destination: netip.PrefixFrom(dst, ones).Masked()}
+		if gw, ok := netip.AddrFromSlice(route.Router.To4()); ok && !gw.IsUnspecified() {
+			resolved.Gateway = gw
+		}
+		routes = append(routes, resolved)
+	}
+	var router netip.Addr
+	if routers := ack.Router(); len(routers) > 0 {
+		router, _ = netip.AddrFromSlice(routers[0].To4())
+	}
+	// the configuration was validated when the client was started
+	for _, route := range c.config.Routes {
+		dst, err := netip.ParsePrefix(route.Destination)
+		if err != nil {
+			return fmt.Errorf("invalid route destination %q: %w", route.Destination, err)
+		}
+		resolved := ipam.ResolvedRoute{Destination: dst.Masked()}
+		if route.Gateway != "" {
+			resolved.Gateway, err = netip.ParseAddr(route.Gateway)
+			if err != nil {
+				return fmt.Errorf("invalid route gateway %q: %w", route.Gateway, err)
+			}
+		} else if router.IsValid() && dst.Addr().Is4() {
+			resolved.Gateway = router
+		}
+		routes = append(routes, resolved)
+	}
+	return NsAddRoutes(c.containerNsPath, c.ifName, routes)
+}
+
+// waitIPv6 waits until the interface has a global IPv6 address that is not tentative.
+func (c *DHCPClient) waitIPv6(ctx context.Context) error {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		addrs, err := c.nhNs.AddrList(c.link, netlink.FAMILY_V6)
+		if err != nil {
+			return fmt.Errorf("failed to list addresses of interface %s: %w", c.ifName, err)
+		}
+		for _, addr := range addrs {
+			if addr.IP.IsGlobalUnicast() && addr.Flags&unix.IFA_F_TENTATIVE == 0 {
+				klog.Infof("IPv6 address %s configured on interface %s on namespace %s", addr.IPNet, c.ifName, c.containerNsPath)
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for an IPv6 address on interface %s: %w", c.ifName, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// leaseAddress returns the address of the lease with the subnet mask.
+func leaseAddress(lease *nclient4.Lease) *net.IPNet {
+	mask := lease.ACK.SubnetMask()
+	if mask == nil {
+		mask = lease.ACK.YourIPAddr.DefaultMask()
+	}
+	return &net.IPNet{IP: lease.ACK.YourIPAddr.To4(), Mask: mask}
+}
+
+// inNamespace runs fn with a thread in the network namespace ns. It runs in its
+// own goroutine, and the thread is only unlocked once it is back in the original
+// namespace, otherwise it stays locked and the runtime terminates it when the
+// goroutine exits, so no other goroutine runs in the namespace.
+func inNamespace(ns netns.NsHandle, fn func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		origNs, err := netns.Get()
+		if err != nil {
+			runtime.UnlockOSThread()
+			errCh <- err
+			return
+		}
+		defer origNs.Close()
+
+		if err := netns.Set(ns); err != nil {
+			runtime.UnlockOSThread()
+			errCh <- err
+			return
+		}
+		err = fn()
+		if restoreErr := netns.Set(origNs); restoreErr != nil {
+			errCh <- errors.Join(err, fmt.Errorf("failed to restore network namespace: %w", restoreErr))
+			return
+		}
+		runtime.UnlockOSThread()
+		errCh <- err
+	}()
+	return <-errCh
+}
@@ -0,0 +1,198 @@
+package net
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+)
+
+func TestNsStartDHCP(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Test requires root privileges.")
+	}
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("unexpected error trying to get namespace: %v", err)
+	}
+	defer origns.Close()
+
+	rndString := make([]byte, 4)
+	_, err = rand.Read(rndString)
+	if err != nil {
+		t.Errorf("fail to generate random name: %v", err)
+	}
+	serverNsName := fmt.Sprintf("srv%x", rndString)
+	serverNs, err := netns.NewNamed(serverNsName)
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer netns.DeleteNamed(serverNsName)
+	defer serverNs.Close()
+
+	clientNsName := fmt.Sprintf("cli%x", rndString)
+	clientNs, err := netns.NewNamed(clientNsName)
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer netns.DeleteNamed(clientNsName)
+	defer clientNs.Close()
+
+	// Switch back to the original namespace
+	netns.Set(origns)
+
+	// connect both namespaces with a veth pair
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "srv0", Namespace: netlink.NsFd(serverNs)},
+		PeerName:  "net1",
+	}
+	veth.PeerNamespace = netlink.NsFd(clientNs)
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("fail to create veth pair: %v", err)
+	}
+
+	nhServer, err := netlink.NewHandleAt(serverNs)
+	if err != nil {
+		t.Fatalf("fail to get namespace handle: %v", err)
+	}
+	defer nhServer.Close()
+	serverLink, err := nhServer.LinkByName("srv0")
+	if err != nil {
+		t.Fatalf("fail to get interface: %v", err)
+	}
+	serverIP := net.ParseIP("192.168.50.1")
+	if err := nhServer.AddrAdd(serverLink, &netlink.Addr{IPNet: &net.IPNet{IP: serverIP, Mask: net.CIDRMask(24, 32)}}); err != nil {
+		t.Fatalf("fail to add address: %v", err)
+	}
+	if err := nhServer.LinkSetUp(serverLink); err != nil {
+		t.Fatalf("fail to set up interface: %v", err)
+	}
+
+	released := make(chan struct{}, 1)
+	handler := func(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
+		var msgType dhcpv4.MessageType
+		switch m.MessageType() {
+		case dhcpv4.MessageTypeDiscover:
+			msgType = dhcpv4.MessageTypeOffer
+		case dhcpv4.MessageTypeRequest:
+			msgType = dhcpv4.MessageTypeAck
+		case dhcpv4.MessageTypeRelease:
+			released <- struct{}{}
+			return
+		default:
+			return
+		}
+		reply, err := dhcpv4.NewReplyFromRequest(m,
+			dhcpv4.WithMessageType(msgType),
+			dhcpv4.WithServerIP(serverIP),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverIP)),
+			dhcpv4.WithYourIP(net.ParseIP("192.168.50.10")),
+			dhcpv4.WithNetmask(net.CIDRMask(24, 32)),
+			dhcpv4.WithRouter(serverIP),
+			dhcpv4.WithLeaseTime(3600),
+			dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionInterfaceMTU, []byte{0x05, 0x78})),
+			dhcpv4.WithOption(dhcpv4.OptClasslessStaticRoute(&dhcpv4.Route{
+				Dest:   &net.IPNet{IP: net.ParseIP("10.10.0.0").To4(), Mask: net.CIDRMask(16, 32)},
+				Router: net.ParseIP("192.168.50.254"),
+			})),
+		)
+		if err != nil {
+			t.Errorf("fail to create DHCP reply: %v", err)
+			return
+		}
+		if _, err := conn.WriteTo(reply.ToBytes(), &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}); err != nil {
+			t.Errorf("fail to send DHCP reply: %v", err)
+		}
+	}
+
+	var server *server4.Server
+	err = inNamespace(serverNs, func() error {
+		var err error
+		server, err = server4.NewServer("srv0", &net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ServerPort}, handler)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("fail to create DHCP server: %v", err)
+	}
+	defer server.Close()
+	go func() {
+		_ = server.Serve()
+	}()
+
+	config := ipam.Config{
+		Routes: []ipam.Route{{Destination: "172.20.0.0/16"}},
+		DHCP:   &ipam.DHCP{Timeout: metav1.Duration{Duration: 10 * time.Second}},
+	}
+	client, err := NsStartDHCP(path.Join("/run/netns", clientNsName), "net1", config)
+	if err != nil {
+		t.Fatalf("fail to start DHCP client: %v", err)
+	}
+
+	if addrs := client.Addresses(); len(addrs) != 1 || addrs[0].String() != "192.168.50.10/24" {
+		t.Errorf("unexpected DHCP addresses: %v", addrs)
+	}
+
+	nhClient, err := netlink.NewHandleAt(clientNs)
+	if err != nil {
+		t.Fatalf("fail to get namespace handle: %v", err)
+	}
+	defer nhClient.Close()
+	link, err := nhClient.LinkByName("net1")
+	if err != nil {
+		t.Fatalf("fail to get interface: %v", err)
+	}
+	if link.Attrs().MTU != 1400 {
+		t.Errorf("expected MTU 1400, got %d", link.Attrs().MTU)
+	}
+	addrs, err := nhClient.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("fail to list addresses: %v", err)
+	}
+	if len(addrs) != 1 || addrs[0].IPNet.String() != "192.168.50.10/24" {
+		t.Errorf("unexpected addresses on interface: %v", addrs)
+	}
+	list, err := nhClient.RouteList(link, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("fail to list routes: %v", err)
+	}
+	found := map[string]string{}
+	for _, r := range list {
+		if r.Dst != nil {
+			found[r.Dst.String()] = r.Gw.String()
+		}
+	}
+	if found["10.10.0.0/16"] != "192.168.50.254" {
+		t.Errorf("route to 10.10.0.0/16 via 192.168.50.254 not found: %v", found)
+	}
+	if found["172.20.0.0/16"] != "192.168.50.1" {
+		t.Errorf("route to 172.20.0.0/16 via 192.168.50.1 not found: %v", found)
+	}
+
+	if err := client.Stop(); err != nil {
+		t.Fatalf("fail to stop DHCP client: %v", err)
+	}
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Errorf("DHCP lease was not released")
+	}
+	addrs, err = nhClient.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("fail to list addresses: %v", err)
+	}
+	if len(addrs) != 0 {
+		t.Errorf("expected no addresses after stop, got %v", addrs)
+	}
+}
@@ -122,7 +122,7 @@ func NsAttachNetdev(hostIfName string, containerNsPAth string, newAttr netlink.L
 		if err != nil {
 			return nil, fmt.Errorf("fail to set up address %s on namespace %s: %w", ipnet.IP.String(), containerNsPAth, err)
 		}
-		networkData.IPs = append(networkData.IPs, ipnet.IP.String())
+		networkData.IPs = append(networkData.IPs, ipnet.String())
 	}
 
 	err = nhNs.LinkSetUp(nsLink)
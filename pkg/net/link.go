@@ -0,0 +1,109 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+
+	resourceapi "k8s.io/api/resource/v1"
+)
+
+// nsAddLink creates the link from the host namespace directly inside the namespace
+// at containerNsPath, so it never exists with its final name in the host namespace,
+// then configures the addresses and brings it up. The link is deleted if it can not
+// be configured.
+func nsAddLink(link netlink.Link, containerNsPath string, addresses []*net.IPNet) (*resourceapi.NetworkDeviceData, error) {
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPath, link.Attrs().Name, err)
+	}
+	defer containerNs.Close()
+
+	// to avoid golang problem with goroutines we create the socket in the
+	// namespace and use it directly
+	nhNs, err := netlink.NewHandleAt(containerNs)
+	if err != nil {
+		return nil, err
+	}
+	defer nhNs.Close()
+
+	link.Attrs().Namespace = netlink.NsFd(containerNs)
+	if err := netlink.LinkAdd(link); err != nil {
+		return nil, fmt.Errorf("failed to create the %s %s interface: %w", link.Attrs().Name, link.Type(), err)
+	}
+
+	networkData, err := nsSetupLink(nhNs, link.Attrs().Name, containerNsPath, addresses)
+	if err != nil {
+		if nsLink, errDel := nhNs.LinkByName(link.Attrs().Name); errDel == nil {
+			if errDel := nhNs.LinkDel(nsLink); errDel != nil {
+				err = errors.Join(err, fmt.Errorf("failed to delete interface %s on namespace %s: %w", link.Attrs().Name, containerNsPath, errDel))
+			}
+		}
+		return nil, err
+	}
+	return networkData, nil
+}
+
+// nsSetupLink configures the addresses of the interface ifName of the namespace of
+// the handle nhNs and brings it up.
+func nsSetupLink(nhNs *netlink.Handle, ifName string, containerNsPath string, addresses []*net.IPNet) (*resourceapi.NetworkDeviceData, error) {
+	nsLink, err := nhNs.LinkByName(ifName)
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return nil, fmt.Errorf("link not found for interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+
+	networkData := &resourceapi.NetworkDeviceData{
+		InterfaceName:   nsLink.Attrs().Name,
+		HardwareAddress: nsLink.Attrs().HardwareAddr.String(),
+	}
+
+	for _, ipnet := range addresses {
+		err = nhNs.AddrAdd(nsLink, &netlink.Addr{IPNet: &net.IPNet{IP: ipnet.IP, Mask: ipnet.Mask}})
+		if err != nil {
+			return nil, fmt.Errorf("fail to set up address %s on namespace %s: %w", ipnet.IP.String(), containerNsPath, err)
+		}
+		networkData.IPs = append(networkData.IPs, ipnet.String())
+	}
+
+	if err := nhNs.LinkSetUp(nsLink); err != nil {
+		return nil, fmt.Errorf("failed to set up interface %s on namespace %s: %w", nsLink.Attrs().Name, containerNsPath, err)
+	}
+	return networkData, nil
+}
+
+// NsDelLink deletes the interface ifName from the namespace at containerNsPath.
+// It does not fail if the namespace or the interface are already gone, since
+// deleting the namespace deletes the virtual interfaces inside it.
+func NsDelLink(containerNsPath string, ifName string) error {
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPath, ifName, err)
+	}
+	defer containerNs.Close()
+
+	nhNs, err := netlink.NewHandleAt(containerNs)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace handle: %w", err)
+	}
+	defer nhNs.Close()
+
+	nsLink, err := nhNs.LinkByName(ifName)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return fmt.Errorf("link not found for interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+	if err := nhNs.LinkDel(nsLink); err != nil {
+		return fmt.Errorf("failed to delete interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+	return nil
+}
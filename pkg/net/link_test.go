@@ -0,0 +1,83 @@
+package net
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+func TestNsAddLinkCleanup(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Test requires root privileges.")
+	}
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("unexpected error trying to get namespace: %v", err)
+	}
+	defer origns.Close()
+
+	rndString := make([]byte, 4)
+	_, err = rand.Read(rndString)
+	if err != nil {
+		t.Errorf("fail to generate random name: %v", err)
+	}
+	nsName := fmt.Sprintf("ns%x", rndString)
+	testNS, err := netns.NewNamed(nsName)
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer netns.DeleteNamed(nsName)
+	defer testNS.Close()
+
+	// Switch back to the original namespace
+	netns.Set(origns)
+
+	parentName := fmt.Sprintf("veth%x", rndString)
+	parent := netlink.NewVeth(netlink.LinkAttrs{Name: parentName})
+	parent.PeerName = fmt.Sprintf("peer%x", rndString)
+	if err := netlink.LinkAdd(parent); err != nil {
+		t.Fatalf("fail to create parent interface: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = DelHostLink(parentName)
+	})
+
+	// the second address fails because it already exists
+	address := &net.IPNet{IP: net.ParseIP("192.168.99.2"), Mask: net.CIDRMask(24, 32)}
+	nsPath := path.Join("/run/netns", nsName)
+	_, err = NsAddMacvlan(parentName, nsPath, netlink.LinkAttrs{Name: "net1"}, netlink.MACVLAN_MODE_BRIDGE, []*net.IPNet{address, address})
+	if errors.Is(err, unix.EOPNOTSUPP) {
+		t.Skip("Test requires macvlan support in the kernel.")
+	}
+	if err == nil {
+		t.Fatalf("expected error configuring a duplicate address")
+	}
+
+	nhNs, err := netlink.NewHandleAt(testNS)
+	if err != nil {
+		t.Fatalf("fail to get namespace handle: %v", err)
+	}
+	defer nhNs.Close()
+	var notFound netlink.LinkNotFoundError
+	if _, err := nhNs.LinkByName("net1"); !errors.As(err, &notFound) {
+		t.Errorf("expected interface net1 deleted, got %v", err)
+	}
+
+	// the interface can be created again
+	networkData, err := NsAddMacvlan(parentName, nsPath, netlink.LinkAttrs{Name: "net1"}, netlink.MACVLAN_MODE_BRIDGE, []*net.IPNet{address})
+	if err != nil {
+		t.Fatalf("fail to create macvlan interface: %v", err)
+	}
+	if networkData.InterfaceName != "net1" || len(networkData.IPs) != 1 {
+		t.Errorf("unexpected network data %+v", networkData)
+	}
+}
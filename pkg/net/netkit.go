@@ -0,0 +1,141 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"unsafe"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	resourceapi "k8s.io/api/resource/v1"
+)
+
+const (
+	// bpfNetkitPrimary and bpfNetkitPeer are the BPF attach types of the netkit
+	// devices, they are not defined in golang.org/x/sys.
+	bpfNetkitPrimary = 54
+	bpfNetkitPeer    = 55
+)
+
+// ErrNetkitNotSupported is returned when the kernel can not create netkit devices,
+// they are available since Linux 6.7.
+var ErrNetkitNotSupported = errors.New("netkit devices are not supported by the kernel")
+
+// NetkitPrograms are the paths of the BPF programs pinned in the BPF filesystem
+// that are attached to a netkit pair.
+type NetkitPrograms struct {
+	// Primary runs for the packets sent by the host side device to the pod.
+	Primary string `json:"primary,omitempty"`
+	// Peer runs for the packets sent by the pod.
+	Peer string `json:"peer,omitempty"`
+}
+
+// Empty returns true if no program is configured.
+func (p *NetkitPrograms) Empty() bool {
+	return p == nil || (p.Primary == "" && p.Peer == "")
+}
+
+// NsAddNetkit creates a netkit pair in L2 mode, the primary device hostIfName is
+// created in the host namespace and the peer with the attributes of newAttr inside
+// the namespace at containerNsPath, with the addresses configured. Without BPF
+// programs the traffic is forwarded between both devices like on a veth pair. The
+// pair is deleted if it can not be configured. It returns ErrNetkitNotSupported if
+// the kernel does not support them.
+func NsAddNetkit(hostIfName string, containerNsPath string, newAttr netlink.LinkAttrs, addresses []*net.IPNet) (netlink.Link, *resourceapi.NetworkDeviceData, error) {
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPath, newAttr.Name, err)
+	}
+	defer containerNs.Close()
+
+	netkit := &netlink.Netkit{
+		LinkAttrs: netlink.LinkAttrs{
+			Name: hostIfName,
+			MTU:  newAttr.MTU,
+		},
+		Mode:       netlink.NETKIT_MODE_L2,
+		Policy:     netlink.NETKIT_POLICY_FORWARD,
+		PeerPolicy: netlink.NETKIT_POLICY_FORWARD,
+	}
+	netkit.SetPeerAttrs(&netlink.LinkAttrs{
+		Name:         newAttr.Name,
+		MTU:          newAttr.MTU,
+		HardwareAddr: newAttr.HardwareAddr,
+		Namespace:    netlink.NsFd(containerNs),
+	})
+	if err := netlink.LinkAdd(netkit); err != nil {
+		if errors.Is(err, unix.EOPNOTSUPP) {
+			return nil, nil, ErrNetkitNotSupported
+		}
+		return nil, nil, fmt.Errorf("failed to create the netkit pair %s/%s: %w", hostIfName, newAttr.Name, err)
+	}
+
+	return nsSetupPair(hostIfName, containerNs, containerNsPath, newAttr.Name, addresses)
+}
+
+// AttachNetkitPrograms attaches the pinned BPF programs to the netkit pair of the
+// primary device hostLink. The programs stay attached until the pair is deleted.
+func AttachNetkitPrograms(hostLink netlink.Link, programs NetkitPrograms) error {
+	if _, ok := hostLink.(*netlink.Netkit); !ok {
+		return fmt.Errorf("interface %s is not a netkit device", hostLink.Attrs().Name)
+	}
+	for _, program := range []struct {
+		path       string
+		attachType uint32
+	}{
+		{path: programs.Primary, attachType: bpfNetkitPrimary},
+		{path: programs.Peer, attachType: bpfNetkitPeer},
+	} {
+		if program.path == "" {
+			continue
+		}
+		if err := attachPinnedProgram(hostLink.Attrs().Index, program.path, program.attachType); err != nil {
+			return fmt.Errorf("failed to attach BPF program %s to interface %s: %w", program.path, hostLink.Attrs().Name, err)
+		}
+	}
+	return nil
+}
+
+// attachPinnedProgram attaches the BPF program pinned at path to the interface.
+// The programs of both netkit devices are attached through the primary one.
+func attachPinnedProgram(ifIndex int, path string, attachType uint32) error {
+	pathname, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	// struct of the BPF_OBJ_GET command in union bpf_attr
+	getAttr := struct {
+		pathname  uint64
+		bpfFd     uint32
+		fileFlags uint32
+		pathFd    int32
+		_         uint32
+	}{pathname: uint64(uintptr(unsafe.Pointer(pathname)))}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET, uintptr(unsafe.Pointer(&getAttr)), unsafe.Sizeof(getAttr))
+	if errno != 0 {
+		return fmt.Errorf("failed to open pinned program: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	// struct of the BPF_PROG_ATTACH command in union bpf_attr
+	attachAttr := struct {
+		targetIfindex    uint32
+		attachBpfFd      uint32
+		attachType       uint32
+		attachFlags      uint32
+		replaceBpfFd     uint32
+		relativeFd       uint32
+		expectedRevision uint64
+	}{
+		targetIfindex: uint32(ifIndex),
+		attachBpfFd:   uint32(fd),
+		attachType:    attachType,
+	}
+	_, _, errno = unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_ATTACH, uintptr(unsafe.Pointer(&attachAttr)), unsafe.Sizeof(attachAttr))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package net
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func TestNsAddNetkit(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Test requires root privileges.")
+	}
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("unexpected error trying to get namespace: %v", err)
+	}
+	defer origns.Close()
+
+	rndString := make([]byte, 4)
+	_, err = rand.Read(rndString)
+	if err != nil {
+		t.Errorf("fail to generate random name: %v", err)
+	}
+	nsName := fmt.Sprintf("ns%x", rndString)
+	testNS, err := netns.NewNamed(nsName)
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer netns.DeleteNamed(nsName)
+	defer testNS.Close()
+
+	// Switch back to the original namespace
+	netns.Set(origns)
+
+	if err := attachPinnedProgram(1, filepath.Join(t.TempDir(), "missing"), bpfNetkitPeer); err == nil {
+		t.Errorf("expected error opening a missing pinned program")
+	}
+
+	hostIfName := fmt.Sprintf("nk%x", rndString)
+	hostLink, networkData, err := NsAddNetkit(hostIfName, path.Join("/run/netns", nsName), netlink.LinkAttrs{Name: "net1", MTU: 1400},
+		[]*net.IPNet{{IP: net.ParseIP("192.168.99.2"), Mask: net.CIDRMask(24, 32)}})
+	if errors.Is(err, ErrNetkitNotSupported) {
+		t.Skip("Test requires netkit support in the kernel.")
+	}
+	if err != nil {
+		t.Fatalf("fail to create netkit pair: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = DelHostLink(hostIfName)
+	})
+
+	if hostLink.Attrs().MTU != 1400 {
+		t.Errorf("expected MTU 1400, got %d", hostLink.Attrs().MTU)
+	}
+	if networkData.InterfaceName != "net1" || len(networkData.IPs) != 1 || networkData.IPs[0] != "192.168.99.2/24" {
+		t.Errorf("unexpected network data %+v", networkData)
+	}
+
+	nhNs, err := netlink.NewHandleAt(testNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nhNs.Close()
+	nsLink, err := nhNs.LinkByName("net1")
+	if err != nil {
+		t.Fatalf("peer not found in the namespace: %v", err)
+	}
+	if nsLink.Type() != "netkit" {
+		t.Errorf("expected a netkit peer, got %s", nsLink.Type())
+	}
+
+	err = AttachNetkitPrograms(hostLink, NetkitPrograms{Peer: filepath.Join(t.TempDir(), "missing")})
+	if err == nil {
+		t.Errorf("expected error attaching a missing program")
+	}
+}
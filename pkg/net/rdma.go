@@ -0,0 +1,125 @@
+package net
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+const (
+	// rdmaNetnsExclusive is the RDMA subsystem mode where each RDMA device belongs to a
+	// single network namespace, in shared mode the devices are visible in all of them.
+	rdmaNetnsExclusive = "exclusive"
+
+	// rdmaDevDir is the directory with the RDMA char devices.
+	rdmaDevDir = "/dev/infiniband"
+)
+
+// RdmaDevice is the RDMA device associated to a network interface.
+type RdmaDevice struct {
+	// Name of the RDMA device, for example mlx5_0.
+	Name string
+	// CharDevices are the paths of the char devices used by the applications to
+	// access the RDMA device, for example /dev/infiniband/uverbs0.
+	CharDevices []string
+}
+
+// GetRdmaDevice returns the RDMA device associated to the network interface ifName
+// in the host namespace, or nil if the interface has no RDMA device.
+func GetRdmaDevice(ifName string) (*RdmaDevice, error) {
+	devicePath := filepath.Join(sysfsRoot, "class", "net", ifName, "device")
+	entries, err := os.ReadDir(filepath.Join(devicePath, "infiniband"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	rdmaDevice := &RdmaDevice{
+		Name:        entries[0].Name(),
+		CharDevices: []string{filepath.Join(rdmaDevDir, "rdma_cm")},
+	}
+	for _, class := range []string{"infiniband_verbs", "infiniband_mad"} {
+		devices, err := os.ReadDir(filepath.Join(devicePath, class))
+		if err != nil {
+			continue
+		}
+		for _, device := range devices {
+			name := device.Name()
+			if strings.HasPrefix(name, "uverbs") || strings.HasPrefix(name, "umad") || strings.HasPrefix(name, "issm") {
+				rdmaDevice.CharDevices = append(rdmaDevice.CharDevices, filepath.Join(rdmaDevDir, name))
+			}
+		}
+	}
+	return rdmaDevice, nil
+}
+
+// RdmaNetnsExclusive returns true if the RDMA subsystem is in exclusive network
+// namespace mode, so the RDMA devices have to be moved with their network interfaces.
+func RdmaNetnsExclusive() bool {
+	mode, err := netlink.RdmaSystemGetNetnsMode()
+	if err != nil {
+		return false
+	}
+	return mode == rdmaNetnsExclusive
+}
+
+// NsAttachRdma moves the RDMA device rdmaName from the host namespace to the
+// namespace at containerNsPath.
+func NsAttachRdma(rdmaName string, containerNsPath string) error {
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace from path %s for RDMA device %s : %w", containerNsPath, rdmaName, err)
+	}
+	defer containerNs.Close()
+
+	rdmaLink, err := netlink.RdmaLinkByName(rdmaName)
+	if err != nil {
+		return fmt.Errorf("RDMA device %s not found: %w", rdmaName, err)
+	}
+	if err := netlink.RdmaLinkSetNsFd(rdmaLink, uint32(containerNs)); err != nil {
+		return fmt.Errorf("failed to move RDMA device %s to namespace %s: %w", rdmaName, containerNsPath, err)
+	}
+	return nil
+}
+
+// NsDetachRdma moves the RDMA device rdmaName from the namespace at containerNsPath
+// back to the host namespace.
+func NsDetachRdma(containerNsPath string, rdmaName string) error {
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace from path %s for RDMA device %s : %w", containerNsPath, rdmaName, err)
+	}
+	defer containerNs.Close()
+
+	rootNs, err := netns.Get()
+	if err != nil {
+		return err
+	}
+	defer rootNs.Close()
+
+	// to avoid golang problem with goroutines we create the socket in the
+	// namespace and use it directly
+	nhNs, err := netlink.NewHandleAt(containerNs)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace handle: %w", err)
+	}
+	defer nhNs.Close()
+
+	rdmaLink, err := nhNs.RdmaLinkByName(rdmaName)
+	if err != nil {
+		return fmt.Errorf("RDMA device %s not found on namespace %s: %w", rdmaName, containerNsPath, err)
+	}
+	if err := nhNs.RdmaLinkSetNsFd(rdmaLink, uint32(rootNs)); err != nil {
+		return fmt.Errorf("failed to move RDMA device %s back to the host namespace: %w", rdmaName, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package net
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetRdmaDevice(t *testing.T) {
+	root := fakeSysfs(t)
+	oldRoot := sysfsRoot
+	sysfsRoot = root
+	t.Cleanup(func() { sysfsRoot = oldRoot })
+
+	device := filepath.Join(root, "class", "net", "eth0", "device")
+	for _, dir := range []string{"infiniband/mlx5_0", "infiniband_verbs/uverbs0", "infiniband_mad/umad0", "infiniband_mad/issm0"} {
+		if err := os.MkdirAll(filepath.Join(device, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rdmaDevice, err := GetRdmaDevice("eth0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &RdmaDevice{
+		Name: "mlx5_0",
+		CharDevices: []string{
+			"/dev/infiniband/rdma_cm",
+			"/dev/infiniband/uverbs0",
+			"/dev/infiniband/issm0",
+			"/dev/infiniband/umad0",
+		},
+	}
+	if !reflect.DeepEqual(rdmaDevice, expected) {
+		t.Errorf("GetRdmaDevice() = %+v, want %+v", rdmaDevice, expected)
+	}
+
+	rdmaDevice, err = GetRdmaDevice("eth1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rdmaDevice != nil {
+		t.Errorf("expected no RDMA device for eth1, got %+v", rdmaDevice)
+	}
+}
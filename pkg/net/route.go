@@ -0,0 +1,65 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+)
+
+// NsAddRoutes adds the routes through the interface ifName inside the namespace at
+// containerNsPath, routes without gateway are added as directly connected. The
+// routes of an interface enslaved to a VRF are added to the table of the VRF.
+func NsAddRoutes(containerNsPath string, ifName string, routes []ipam.ResolvedRoute) error {
+	if len(routes) == 0 {
+		return nil
+	}
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPath, ifName, err)
+	}
+	defer containerNs.Close()
+
+	// to avoid golang problem with goroutines we create the socket in the
+	// namespace and use it directly
+	nhNs, err := netlink.NewHandleAt(containerNs)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace handle: %w", err)
+	}
+	defer nhNs.Close()
+
+	nsLink, err := nhNs.LinkByName(ifName)
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return fmt.Errorf("link not found for interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+
+	table := 0
+	if vrf, ok := vrfOf(nhNs, nsLink); ok {
+		table = int(vrf.Table)
+	}
+	for _, route := range routes {
+		dst := route.Destination
+		r := &netlink.Route{
+			Table:     table,
+			LinkIndex: nsLink.Attrs().Index,
+			Dst: &net.IPNet{
+				IP:   dst.Addr().AsSlice(),
+				Mask: net.CIDRMask(dst.Bits(), dst.Addr().BitLen()),
+			},
+			Scope: netlink.SCOPE_LINK,
+		}
+		if route.Gateway.IsValid() {
+			r.Gw = route.Gateway.AsSlice()
+			r.Scope = netlink.SCOPE_UNIVERSE
+		}
+		if err := nhNs.RouteAdd(r); err != nil && !errors.Is(err, unix.EEXIST) {
+			return fmt.Errorf("failed to add route to %s via %s on interface %s: %w", dst, route.Gateway, ifName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package net
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+)
+
+func TestNsAddRoutes(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Test requires root privileges.")
+	}
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("unexpected error trying to get namespace: %v", err)
+	}
+	defer origns.Close()
+
+	rndString := make([]byte, 4)
+	_, err = rand.Read(rndString)
+	if err != nil {
+		t.Errorf("fail to generate random name: %v", err)
+	}
+	nsName := fmt.Sprintf("ns%x", rndString)
+	testNS, err := netns.NewNamed(nsName)
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer netns.DeleteNamed(nsName)
+	defer testNS.Close()
+
+	// Switch back to the original namespace
+	netns.Set(origns)
+
+	hostIfName := fmt.Sprintf("veth%x", rndString)
+	_, addr, _ := net.ParseCIDR("192.168.7.2/24")
+	addr.IP = net.ParseIP("192.168.7.2")
+	_, _, err = NsAddVeth(hostIfName, path.Join("/run/netns", nsName), netlink.LinkAttrs{Name: "net1"}, []*net.IPNet{addr})
+	if err != nil {
+		t.Fatalf("fail to create veth pair: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = DelHostLink(hostIfName)
+	})
+
+	routes := []ipam.ResolvedRoute{
+		{Destination: netip.MustParsePrefix("10.0.0.0/8"), Gateway: netip.MustParseAddr("192.168.7.1")},
+		{Destination: netip.MustParsePrefix("172.16.0.0/12")},
+	}
+	if err := NsAddRoutes(path.Join("/run/netns", nsName), "net1", routes); err != nil {
+		t.Fatalf("fail to add routes: %v", err)
+	}
+	// adding the same routes again is not an error
+	if err := NsAddRoutes(path.Join("/run/netns", nsName), "net1", routes); err != nil {
+		t.Fatalf("fail to add routes again: %v", err)
+	}
+
+	nhNs, err := netlink.NewHandleAt(testNS)
+	if err != nil {
+		t.Fatalf("fail to get namespace handle: %v", err)
+	}
+	defer nhNs.Close()
+	link, err := nhNs.LinkByName("net1")
+	if err != nil {
+		t.Fatalf("fail to get interface: %v", err)
+	}
+	list, err := nhNs.RouteList(link, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("fail to list routes: %v", err)
+	}
+	found := map[string]string{}
+	for _, r := range list {
+		if r.Dst != nil {
+			found[r.Dst.String()] = r.Gw.String()
+		}
+	}
+	if found["10.0.0.0/8"] != "192.168.7.1" {
+		t.Errorf("route to 10.0.0.0/8 via 192.168.7.1 not found: %v", found)
+	}
+	if _, ok := found["172.16.0.0/12"]; !ok {
+		t.Errorf("route to 172.16.0.0/12 not found: %v", found)
+	}
+}
@@ -0,0 +1,297 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"k8s.io/utils/ptr"
+)
+
+// sysfsRoot is the mount point of sysfs, tests replace it with a fake tree.
+var sysfsRoot = "/sys"
+
+// PhysicalFunction is an SR-IOV capable network device.
+type PhysicalFunction struct {
+	// Name of the network interface of the PF.
+	Name string
+	// PCIAddress of the PF, for example 0000:01:00.0.
+	PCIAddress string
+	// Driver is the kernel driver bound to the PF.
+	Driver string
+	// NUMANode of the PF, -1 if it is unknown.
+	NUMANode int
+	// TotalVFs is the maximum number of VFs supported by the PF.
+	TotalVFs int
+	// NumVFs is the number of VFs currently enabled on the PF.
+	NumVFs int
+}
+
+// VirtualFunction is an SR-IOV virtual function of a PhysicalFunction.
+type VirtualFunction struct {
+	// PFName is the name of the network interface of the parent PF.
+	PFName string
+	// Index of the VF on its PF.
+	Index int
+	// PCIAddress of the VF, for example 0000:01:00.2.
+	PCIAddress string
+	// Name of the network interface of the VF in the host namespace, it is empty
+	// if the VF is bound to a driver without network interface or it has been
+	// moved to another namespace.
+	Name string
+	// Driver is the kernel driver bound to the VF.
+	Driver string
+	// NUMANode of the VF, -1 if it is unknown.
+	NUMANode int
+}
+
+// DiscoverPhysicalFunctions returns the SR-IOV capable network devices in the host namespace.
+func DiscoverPhysicalFunctions() ([]PhysicalFunction, error) {
+	entries, err := os.ReadDir(filepath.Join(sysfsRoot, "class", "net"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pfs []PhysicalFunction
+	for _, entry := range entries {
+		pf, err := GetPhysicalFunction(entry.Name())
+		if err != nil {
+			continue
+		}
+		pfs = append(pfs, *pf)
+	}
+	return pfs, nil
+}
+
+// GetPhysicalFunction returns the SR-IOV information of the network interface name,
+// it fails if the interface is not SR-IOV capable.
+func GetPhysicalFunction(name string) (*PhysicalFunction, error) {
+	devicePath := filepath.Join(sysfsRoot, "class", "net", name, "device")
+	totalVFs, err := readIntFile(filepath.Join(devicePath, "sriov_totalvfs"))
+	if err != nil {
+		return nil, fmt.Errorf("interface %s is not SR-IOV capable: %w", name, err)
+	}
+	numVFs, err := readIntFile(filepath.Join(devicePath, "sriov_numvfs"))
+	if err != nil {
+		return nil, fmt.Errorf("interface %s is not SR-IOV capable: %w", name, err)
+	}
+	pciAddress, err := linkBase(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	return &PhysicalFunction{
+		Name:       name,
+		PCIAddress: pciAddress,
+		Driver:     deviceDriver(devicePath),
+		NUMANode:   numaNode(devicePath),
+		TotalVFs:   totalVFs,
+		NumVFs:     numVFs,
+	}, nil
+}
+
+// SetNumVFs enables numVFs virtual functions on the PF. The kernel does not allow
+// to change the number of VFs once they are enabled, so they are disabled first,
+// and it fails if any of them is in use; it is a no-op if the PF already has numVFs
+// virtual functions.
+func SetNumVFs(pfName string, numVFs int) error {
+	pf, err := GetPhysicalFunction(pfName)
+	if err != nil {
+		return err
+	}
+	if pf.NumVFs == numVFs {
+		return nil
+	}
+	if numVFs > pf.TotalVFs {
+		return fmt.Errorf("interface %s supports %d VFs at most, requested %d", pfName, pf.TotalVFs, numVFs)
+	}
+
+	numVFsPath := filepath.Join(sysfsRoot, "class", "net", pfName, "device", "sriov_numvfs")
+	if pf.NumVFs != 0 {
+		vfs, err := ListVirtualFunctions(pfName)
+		if err != nil {
+			return err
+		}
+		for _, vf := range vfs {
+			if vf.InUse() {
+				return fmt.Errorf("can not change the number of VFs on interface %s: VF %d is in use", pfName, vf.Index)
+			}
+		}
+		if err := os.WriteFile(numVFsPath, []byte("0"), 0644); err != nil {
+			return fmt.Errorf("failed to disable VFs on interface %s: %w", pfName, err)
+		}
+	}
+	if err := os.WriteFile(numVFsPath, []byte(strconv.Itoa(numVFs)), 0644); err != nil {
+		return fmt.Errorf("failed to enable %d VFs on interface %s: %w", numVFs, pfName, err)
+	}
+	return nil
+}
+
+// InUse returns true if the VF is bound to a driver but has no network interface
+// in the host namespace, because it was moved to a pod or it is bound to a
+// userspace driver like vfio-pci.
+func (vf VirtualFunction) InUse() bool {
+	return vf.Driver != "" && vf.Name == ""
+}
+
+// ListVirtualFunctions returns the enabled virtual functions of the PF sorted by index.
+func ListVirtualFunctions(pfName string) ([]VirtualFunction, error) {
+	devicePath := filepath.Join(sysfsRoot, "class", "net", pfName, "device")
+	matches, err := filepath.Glob(filepath.Join(devicePath, "virtfn*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var vfs []VirtualFunction
+	for _, match := range matches {
+		index, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(match), "virtfn"))
+		if err != nil {
+			continue
+		}
+		pciAddress, err := linkBase(match)
+		if err != nil {
+			return nil, err
+		}
+		vf := VirtualFunction{
+			PFName:     pfName,
+			Index:      index,
+			PCIAddress: pciAddress,
+			Driver:     deviceDriver(match),
+			NUMANode:   numaNode(match),
+		}
+		// the net directory only lists the interfaces of the current namespace
+		if netdevs, err := os.ReadDir(filepath.Join(match, "net")); err == nil && len(netdevs) > 0 {
+			vf.Name = netdevs[0].Name()
+		}
+		vfs = append(vfs, vf)
+	}
+	sort.Slice(vfs, func(i, j int) bool { return vfs[i].Index < vfs[j].Index })
+	return vfs, nil
+}
+
+// VFConfig is the configuration of a virtual function applied through its PF.
+type VFConfig struct {
+	// HardwareAddr of the VF.
+	HardwareAddr net.HardwareAddr
+	// Vlan is the VLAN the VF traffic is tagged with by the PF, 0 disables it.
+	Vlan int
+	// VlanQoS is the 802.1p priority of the VLAN.
+	VlanQoS int
+	// VlanProtocol is the tag protocol of the VLAN, it defaults to 802.1Q.
+	VlanProtocol netlink.VlanProtocol
+	// SpoofCheck enables the MAC spoof checking on the VF.
+	SpoofCheck *bool
+	// Trust allows the VF to change its MAC address and to enter promiscuous mode.
+	Trust *bool
+	// MinTxRate and MaxTxRate limit the VF transmit rate in Mbps, 0 disables the limit.
+	MinTxRate int
+	MaxTxRate int
+}
+
+// ConfigureVF applies the configuration to the VF with the given index of the PF.
+func ConfigureVF(pfName string, vfIndex int, config VFConfig) error {
+	pfLink, err := netlink.LinkByName(pfName)
+	// recover same behavior on vishvananda/netlink@1.2.1 and do not fail when the kernel returns NLM_F_DUMP_INTR.
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return fmt.Errorf("could not find physical function %s : %w", pfName, err)
+	}
+
+	if config.HardwareAddr != nil {
+		if err := netlink.LinkSetVfHardwareAddr(pfLink, vfIndex, config.HardwareAddr); err != nil {
+			return fmt.Errorf("failed to set hardware address %s on VF %d of %s: %w", config.HardwareAddr, vfIndex, pfName, err)
+		}
+	}
+
+	protocol := config.VlanProtocol
+	if protocol == netlink.VLAN_PROTOCOL_UNKNOWN {
+		protocol = netlink.VLAN_PROTOCOL_8021Q
+	}
+	if config.Vlan != 0 || config.VlanQoS != 0 || protocol != netlink.VLAN_PROTOCOL_8021Q {
+		err = netlink.LinkSetVfVlanQosProto(pfLink, vfIndex, config.Vlan, config.VlanQoS, int(protocol))
+	} else {
+		err = netlink.LinkSetVfVlan(pfLink, vfIndex, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set VLAN %d on VF %d of %s: %w", config.Vlan, vfIndex, pfName, err)
+	}
+
+	if config.SpoofCheck != nil {
+		if err := netlink.LinkSetVfSpoofchk(pfLink, vfIndex, *config.SpoofCheck); err != nil {
+			return fmt.Errorf("failed to set spoof check on VF %d of %s: %w", vfIndex, pfName, err)
+		}
+	}
+
+	if config.Trust != nil {
+		if err := netlink.LinkSetVfTrust(pfLink, vfIndex, *config.Trust); err != nil {
+			return fmt.Errorf("failed to set trust on VF %d of %s: %w", vfIndex, pfName, err)
+		}
+	}
+
+	// many drivers do not support rate limits, so they are only set if configured
+	// or to remove the limits of a previous user of the VF
+	if config.MinTxRate != 0 || config.MaxTxRate != 0 || vfRateLimited(pfLink, vfIndex) {
+		if err := netlink.LinkSetVfRate(pfLink, vfIndex, config.MinTxRate, config.MaxTxRate); err != nil {
+			return fmt.Errorf("failed to set rate on VF %d of %s: %w", vfIndex, pfName, err)
+		}
+	}
+	return nil
+}
+
+// vfRateLimited returns true if the VF with the given index of the PF has rate limits.
+func vfRateLimited(pfLink netlink.Link, vfIndex int) bool {
+	for _, vf := range pfLink.Attrs().Vfs {
+		if vf.ID == vfIndex {
+			return vf.TxRate != 0 || vf.MinTxRate != 0 || vf.MaxTxRate != 0
+		}
+	}
+	return false
+}
+
+// ResetVF removes the VLAN and the rate limits of the VF, enables the spoof check and
+// disables the trust, so the next user of the VF does not inherit the configuration.
+func ResetVF(pfName string, vfIndex int) error {
+	return ConfigureVF(pfName, vfIndex, VFConfig{
+		SpoofCheck: ptr.To(true),
+		Trust:      ptr.To(false),
+	})
+}
+
+func readIntFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// linkBase returns the last element of the path the symlink points to.
+func linkBase(path string) (string, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(target), nil
+}
+
+// deviceDriver returns the kernel driver bound to the device, if any.
+func deviceDriver(devicePath string) string {
+	driver, err := linkBase(filepath.Join(devicePath, "driver"))
+	if err != nil {
+		return ""
+	}
+	return driver
+}
+
+// numaNode returns the NUMA node of the device, -1 if it is unknown.
+func numaNode(devicePath string) int {
+	node, err := readIntFile(filepath.Join(devicePath, "numa_node"))
+	if err != nil {
+		return -1
+	}
+	return node
+}
@@ -0,0 +1,216 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"k8s.io/utils/ptr"
+)
+
+// fakeSysfs creates a sysfs tree with a PF eth0 at 0000:01:00.0 with two VFs,
+// the first one with network interface eth0v0, and a non SR-IOV interface eth1.
+func fakeSysfs(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	pciDevices := filepath.Join(root, "devices", "pci0000:00", "0000:00:01.0")
+
+	mkdir := func(path string) {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path, content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlink := func(target, link string) {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mkdir(filepath.Join(root, "bus", "pci", "drivers", "mlx5_core"))
+	mkdir(filepath.Join(root, "class", "net"))
+
+	pf := filepath.Join(pciDevices, "0000:01:00.0")
+	mkdir(filepath.Join(pf, "net", "eth0"))
+	write(filepath.Join(pf, "sriov_totalvfs"), "8\n")
+	write(filepath.Join(pf, "sriov_numvfs"), "2\n")
+	write(filepath.Join(pf, "numa_node"), "1\n")
+	symlink(filepath.Join(root, "bus", "pci", "drivers", "mlx5_core"), filepath.Join(pf, "driver"))
+	mkdir(filepath.Join(root, "class", "net", "eth0"))
+	symlink(pf, filepath.Join(root, "class", "net", "eth0", "device"))
+
+	for i := 0; i < 2; i++ {
+		vf := filepath.Join(pciDevices, fmt.Sprintf("0000:01:00.%d", i+2))
+		mkdir(vf)
+		write(filepath.Join(vf, "numa_node"), "1\n")
+		symlink(filepath.Join(root, "bus", "pci", "drivers", "mlx5_core"), filepath.Join(vf, "driver"))
+		symlink(vf, filepath.Join(pf, "virtfn"+strconv.Itoa(i)))
+	}
+	mkdir(filepath.Join(pciDevices, "0000:01:00.2", "net", "eth0v0"))
+
+	nic := filepath.Join(pciDevices, "0000:02:00.0")
+	mkdir(filepath.Join(nic, "net", "eth1"))
+	write(filepath.Join(nic, "numa_node"), "-1\n")
+	mkdir(filepath.Join(root, "class", "net", "eth1"))
+	symlink(nic, filepath.Join(root, "class", "net", "eth1", "device"))
+
+	return root
+}
+
+func TestSriovDiscovery(t *testing.T) {
+	root := fakeSysfs(t)
+	oldRoot := sysfsRoot
+	sysfsRoot = root
+	t.Cleanup(func() { sysfsRoot = oldRoot })
+
+	pfs, err := DiscoverPhysicalFunctions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedPFs := []PhysicalFunction{{
+		Name:       "eth0",
+		PCIAddress: "0000:01:00.0",
+		Driver:     "mlx5_core",
+		NUMANode:   1,
+		TotalVFs:   8,
+		NumVFs:     2,
+	}}
+	if !reflect.DeepEqual(pfs, expectedPFs) {
+		t.Errorf("DiscoverPhysicalFunctions() = %+v, want %+v", pfs, expectedPFs)
+	}
+
+	vfs, err := ListVirtualFunctions("eth0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedVFs := []VirtualFunction{
+		{PFName: "eth0", Index: 0, PCIAddress: "0000:01:00.2", Name: "eth0v0", Driver: "mlx5_core", NUMANode: 1},
+		{PFName: "eth0", Index: 1, PCIAddress: "0000:01:00.3", Driver: "mlx5_core", NUMANode: 1},
+	}
+	if !reflect.DeepEqual(vfs, expectedVFs) {
+		t.Errorf("ListVirtualFunctions() = %+v, want %+v", vfs, expectedVFs)
+	}
+
+	if err := SetNumVFs("eth0", 16); err == nil {
+		t.Errorf("expected error enabling more VFs than supported")
+	}
+	if err := SetNumVFs("eth0", 2); err != nil {
+		t.Errorf("unexpected error keeping the number of VFs: %v", err)
+	}
+	// the interface of the second VF is not in the host namespace
+	if err := SetNumVFs("eth0", 4); err == nil {
+		t.Errorf("expected error changing the number of VFs in use")
+	}
+	if err := os.MkdirAll(filepath.Join(root, "devices", "pci0000:00", "0000:00:01.0", "0000:01:00.3", "net", "eth0v1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetNumVFs("eth0", 4); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	numVFs, err := readIntFile(filepath.Join(root, "class", "net", "eth0", "device", "sriov_numvfs"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if numVFs != 4 {
+		t.Errorf("expected 4 VFs got %d", numVFs)
+	}
+
+	if _, err := GetPhysicalFunction("eth1"); err == nil {
+		t.Errorf("expected error for interface without SR-IOV")
+	}
+}
+
+func TestConfigureVFNetdevsim(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Test requires root privileges.")
+	}
+	if _, err := os.Stat("/sys/bus/netdevsim"); err != nil {
+		t.Skip("Test requires the netdevsim module.")
+	}
+
+	id := 4242
+	if err := os.WriteFile("/sys/bus/netdevsim/new_device", []byte(fmt.Sprintf("%d 1", id)), 0200); err != nil {
+		t.Fatalf("fail to create netdevsim device: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = os.WriteFile("/sys/bus/netdevsim/del_device", []byte(strconv.Itoa(id)), 0200)
+	})
+	devicePath := fmt.Sprintf("/sys/bus/netdevsim/devices/netdevsim%d", id)
+	if err := os.WriteFile(filepath.Join(devicePath, "sriov_numvfs"), []byte("2"), 0200); err != nil {
+		t.Fatalf("fail to enable VFs: %v", err)
+	}
+
+	var pfName string
+	for i := 0; i < 50 && pfName == ""; i++ {
+		if netdevs, err := os.ReadDir(filepath.Join(devicePath, "net")); err == nil && len(netdevs) > 0 {
+			pfName = netdevs[0].Name()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if pfName == "" {
+		t.Fatalf("netdevsim device has no network interface")
+	}
+
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	config := VFConfig{
+		HardwareAddr: mac,
+		Vlan:         100,
+		SpoofCheck:   ptr.To(false),
+		Trust:        ptr.To(true),
+		MaxTxRate:    1000,
+	}
+	if err := ConfigureVF(pfName, 1, config); err != nil {
+		t.Fatalf("fail to configure VF: %v", err)
+	}
+
+	link, err := netlink.LinkByName(pfName)
+	if err != nil {
+		t.Fatalf("fail to get PF: %v", err)
+	}
+	var vf *netlink.VfInfo
+	for i := range link.Attrs().Vfs {
+		if link.Attrs().Vfs[i].ID == 1 {
+			vf = &link.Attrs().Vfs[i]
+		}
+	}
+	if vf == nil {
+		t.Fatalf("VF 1 not found on %s", pfName)
+	}
+	if vf.Mac.String() != mac.String() || vf.Vlan != 100 || vf.Spoofchk || vf.Trust != 1 || vf.MaxTxRate != 1000 {
+		t.Errorf("VF configuration not applied: %+v", vf)
+	}
+
+	if err := ResetVF(pfName, 1); err != nil {
+		t.Fatalf("fail to reset VF: %v", err)
+	}
+	link, err = netlink.LinkByName(pfName)
+	if err != nil {
+		t.Fatalf("fail to get PF: %v", err)
+	}
+	if vfRateLimited(link, 1) {
+		t.Errorf("VF rate limits not removed: %+v", link.Attrs().Vfs)
+	}
+}
+
+func TestVFRateLimited(t *testing.T) {
+	link := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Vfs: []netlink.VfInfo{
+		{ID: 0},
+		{ID: 1, MaxTxRate: 1000},
+		{ID: 2, TxRate: 100},
+	}}}
+	for vfIndex, expected := range map[int]bool{0: false, 1: true, 2: true, 3: false} {
+		if got := vfRateLimited(link, vfIndex); got != expected {
+			t.Errorf("vfRateLimited(%d) = %v, want %v", vfIndex, got, expected)
+		}
+	}
+}
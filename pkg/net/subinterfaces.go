@@ -2,64 +2,101 @@ package net
 
 import (
 	"fmt"
+	"net"
 
 	"github.com/vishvananda/netlink"
-	"github.com/vishvananda/netns"
-)
 
-func addMacVlan(containerNsPAth string, devName string, mode netlink.MacvlanMode) error {
-	containerNs, err := netns.GetFromPath(containerNsPAth)
-	if err != nil {
-		return fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPAth, devName, err)
-	}
-	defer containerNs.Close()
+	resourceapi "k8s.io/api/resource/v1"
+)
 
-	parentLink, err := netlink.LinkByName(devName)
+// NsAddMacvlan creates a macvlan interface on top of the host interface parentName
+// inside the namespace at containerNsPath. The attributes Name, MTU and HardwareAddr
+// of newAttr are used for the new interface.
+func NsAddMacvlan(parentName string, containerNsPath string, newAttr netlink.LinkAttrs, mode netlink.MacvlanMode, addresses []*net.IPNet) (*resourceapi.NetworkDeviceData, error) {
+	parentLink, err := netlink.LinkByName(parentName)
 	if err != nil {
-		return fmt.Errorf("could not find parent interface %s : %w", devName, err)
+		return nil, fmt.Errorf("could not find parent interface %s : %w", parentName, err)
 	}
 
 	macvlan := &netlink.Macvlan{
 		LinkAttrs: netlink.LinkAttrs{
-			Name:        "mavlan-" + devName,
-			ParentIndex: parentLink.Attrs().Index,
-			NetNsID:     int(containerNs),
+			Name:         newAttr.Name,
+			MTU:          newAttr.MTU,
+			HardwareAddr: newAttr.HardwareAddr,
+			ParentIndex:  parentLink.Attrs().Index,
 		},
 		Mode: mode,
 	}
-	if err := netlink.LinkAdd(macvlan); err != nil {
-		// If a user creates a macvlan and ipvlan on same parent, only one slave iface can be active at a time.
-		return fmt.Errorf("failed to create the %s macvlan interface: %v", macvlan.Name, err)
-	}
-
-	return nil
+	// If a user creates a macvlan and ipvlan on same parent, only one slave iface can be active at a time.
+	return nsAddLink(macvlan, containerNsPath, addresses)
 }
 
-func addIPVlan(containerNsPAth string, devName string, mode netlink.IPVlanMode) error {
-	containerNs, err := netns.GetFromPath(containerNsPAth)
+// NsAddIPVlan creates an ipvlan interface on top of the host interface parentName
+// inside the namespace at containerNsPath. The attributes Name and MTU of newAttr
+// are used for the new interface, ipvlan children share the parent MAC address.
+func NsAddIPVlan(parentName string, containerNsPath string, newAttr netlink.LinkAttrs, mode netlink.IPVlanMode, flag netlink.IPVlanFlag, addresses []*net.IPNet) (*resourceapi.NetworkDeviceData, error) {
+	parentLink, err := netlink.LinkByName(parentName)
 	if err != nil {
-		return fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPAth, devName, err)
-	}
-	defer containerNs.Close()
-
-	parentLink, err := netlink.LinkByName(devName)
-	if err != nil {
-		return fmt.Errorf("could not find parent interface %s : %w", devName, err)
+		return nil, fmt.Errorf("could not find parent interface %s : %w", parentName, err)
 	}
 
 	ipvlan := &netlink.IPVlan{
 		LinkAttrs: netlink.LinkAttrs{
-			Name:        "ipvaln-" + devName,
+			Name:        newAttr.Name,
+			MTU:         newAttr.MTU,
 			ParentIndex: parentLink.Attrs().Index,
-			NetNsID:     int(containerNs),
 		},
 		Mode: mode,
+		Flag: flag,
 	}
+	// If a user creates a macvlan and ipvlan on same parent, only one slave iface can be active at a time.
+	return nsAddLink(ipvlan, containerNsPath, addresses)
+}
 
-	if err := netlink.LinkAdd(ipvlan); err != nil {
-		// If a user creates a macvlan and ipvlan on same parent, only one slave iface can be active at a time.
-		return fmt.Errorf("failed to create the %s ipvlan interface: %v", ipvlan.Name, err)
+// ParseMacvlanMode parses the macvlan mode names used by iproute2, an empty
+// string defaults to bridge mode.
+func ParseMacvlanMode(mode string) (netlink.MacvlanMode, error) {
+	switch mode {
+	case "", "bridge":
+		return netlink.MACVLAN_MODE_BRIDGE, nil
+	case "private":
+		return netlink.MACVLAN_MODE_PRIVATE, nil
+	case "vepa":
+		return netlink.MACVLAN_MODE_VEPA, nil
+	case "passthru":
+		return netlink.MACVLAN_MODE_PASSTHRU, nil
+	case "source":
+		return netlink.MACVLAN_MODE_SOURCE, nil
+	default:
+		return 0, fmt.Errorf("unknown macvlan mode %q", mode)
 	}
+}
 
-	return nil
+// ParseIPVlanMode parses the ipvlan mode and flag names used by iproute2, empty
+// strings default to l2 mode and bridge flag.
+func ParseIPVlanMode(mode, flag string) (netlink.IPVlanMode, netlink.IPVlanFlag, error) {
+	var ipvlanMode netlink.IPVlanMode
+	switch mode {
+	case "", "l2":
+		ipvlanMode = netlink.IPVLAN_MODE_L2
+	case "l3":
+		ipvlanMode = netlink.IPVLAN_MODE_L3
+	case "l3s":
+		ipvlanMode = netlink.IPVLAN_MODE_L3S
+	default:
+		return 0, 0, fmt.Errorf("unknown ipvlan mode %q", mode)
+	}
+
+	var ipvlanFlag netlink.IPVlanFlag
+	switch flag {
+	case "", "bridge":
+		ipvlanFlag = netlink.IPVLAN_FLAG_BRIDGE
+	case "private":
+		ipvlanFlag = netlink.IPVLAN_FLAG_PRIVATE
+	case "vepa":
+		ipvlanFlag = netlink.IPVLAN_FLAG_VEPA
+	default:
+		return 0, 0, fmt.Errorf("unknown ipvlan flag %q", flag)
+	}
+	return ipvlanMode, ipvlanFlag, nil
 }
@@ -0,0 +1,95 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+
+	resourceapi "k8s.io/api/resource/v1"
+)
+
+// NsAddVeth creates a veth pair with one end named hostIfName in the host namespace
+// and the other end inside the namespace at containerNsPath. The attributes Name,
+// MTU and HardwareAddr of newAttr are used for the end inside the namespace, the
+// MTU is used for both ends. Both ends are set up and the host end is returned so
+// it can be attached to a bridge. The pair is deleted if it can not be configured.
+func NsAddVeth(hostIfName string, containerNsPath string, newAttr netlink.LinkAttrs, addresses []*net.IPNet) (netlink.Link, *resourceapi.NetworkDeviceData, error) {
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPath, newAttr.Name, err)
+	}
+	defer containerNs.Close()
+
+	veth := netlink.NewVeth(netlink.LinkAttrs{
+		Name: hostIfName,
+		MTU:  newAttr.MTU,
+	})
+	veth.PeerName = newAttr.Name
+	veth.PeerHardwareAddr = newAttr.HardwareAddr
+	veth.PeerNamespace = netlink.NsFd(containerNs)
+	if newAttr.MTU != 0 {
+		veth.PeerMTU = uint32(newAttr.MTU)
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		return nil, nil, fmt.Errorf("failed to create the veth pair %s/%s: %w", hostIfName, newAttr.Name, err)
+	}
+
+	return nsSetupPair(hostIfName, containerNs, containerNsPath, newAttr.Name, addresses)
+}
+
+// nsSetupPair configures the pair created with the end hostIfName in the host
+// namespace and the end nsIfName inside containerNs, it configures the addresses
+// of the end inside the namespace, brings both ends up and returns the host end.
+// The pair is deleted if it can not be configured.
+func nsSetupPair(hostIfName string, containerNs netns.NsHandle, containerNsPath string, nsIfName string, addresses []*net.IPNet) (netlink.Link, *resourceapi.NetworkDeviceData, error) {
+	fail := func(err error) (netlink.Link, *resourceapi.NetworkDeviceData, error) {
+		if errDel := DelHostLink(hostIfName); errDel != nil {
+			err = errors.Join(err, errDel)
+		}
+		return nil, nil, err
+	}
+
+	hostLink, err := netlink.LinkByName(hostIfName)
+	// recover same behavior on vishvananda/netlink@1.2.1 and do not fail when the kernel returns NLM_F_DUMP_INTR.
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return fail(err)
+	}
+
+	// to avoid golang problem with goroutines we create the socket in the
+	// namespace and use it directly
+	nhNs, err := netlink.NewHandleAt(containerNs)
+	if err != nil {
+		return fail(err)
+	}
+	defer nhNs.Close()
+
+	networkData, err := nsSetupLink(nhNs, nsIfName, containerNsPath, addresses)
+	if err != nil {
+		return fail(err)
+	}
+	if err := netlink.LinkSetUp(hostLink); err != nil {
+		return fail(fmt.Errorf("failed to set up interface %s: %w", hostIfName, err))
+	}
+	return hostLink, networkData, nil
+}
+
+// DelHostLink deletes the interface ifName from the host namespace, it does not
+// fail if the interface does not exist. Deleting one end of a veth pair deletes
+// the other one too.
+func DelHostLink(ifName string) error {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return err
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete interface %s: %w", ifName, err)
+	}
+	return nil
+}
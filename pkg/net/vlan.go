@@ -0,0 +1,319 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	resourceapi "k8s.io/api/resource/v1"
+)
+
+const (
+	// MinVlanID and MaxVlanID are the usable 802.1Q VLAN identifiers, 0 and 4095 are reserved.
+	MinVlanID = 1
+	MaxVlanID = 4094
+)
+
+var (
+	// netnsDir is the directory where the container runtimes pin the network
+	// namespaces of the pods.
+	netnsDir = "/var/run/netns"
+	// serviceVlanMu serializes the creation and the deletion of the 802.1ad
+	// interfaces shared by the QinQ interfaces.
+	serviceVlanMu sync.Mutex
+)
+
+// VlanConfig describes the VLAN interface to create.
+type VlanConfig struct {
+	// ID is the VLAN identifier of the interface.
+	ID int
+	// Protocol is the tag protocol of ID, it defaults to 802.1Q.
+	Protocol netlink.VlanProtocol
+	// OuterID is the service VLAN identifier for QinQ. If set, an 802.1ad interface
+	// with this identifier is created on the parent in the host namespace, if it does
+	// not exist, and the interface is created on top of it. DelServiceVlan deletes it
+	// once no interface uses it.
+	OuterID int
+}
+
+// Validate checks the VLAN identifiers and protocols.
+func (c VlanConfig) Validate() error {
+	if c.ID < MinVlanID || c.ID > MaxVlanID {
+		return fmt.Errorf("invalid VLAN ID %d, must be between %d and %d", c.ID, MinVlanID, MaxVlanID)
+	}
+	if c.OuterID != 0 {
+		if c.OuterID < MinVlanID || c.OuterID > MaxVlanID {
+			return fmt.Errorf("invalid outer VLAN ID %d, must be between %d and %d", c.OuterID, MinVlanID, MaxVlanID)
+		}
+		if c.Protocol == netlink.VLAN_PROTOCOL_8021AD {
+			return fmt.Errorf("the inner VLAN of a QinQ interface must use the 802.1Q protocol")
+		}
+	}
+	return nil
+}
+
+// ParseVlanProtocol parses 802.1Q or 802.1ad, case insensitive, an empty string defaults to 802.1Q.
+func ParseVlanProtocol(protocol string) (netlink.VlanProtocol, error) {
+	if protocol == "" {
+		return netlink.VLAN_PROTOCOL_8021Q, nil
+	}
+	p := netlink.StringToVlanProtocol(strings.ToLower(protocol))
+	if p == netlink.VLAN_PROTOCOL_UNKNOWN {
+		return p, fmt.Errorf("unknown VLAN protocol %q", protocol)
+	}
+	return p, nil
+}
+
+// NsAddVlan creates a VLAN interface on top of the host interface parentName
+// inside the namespace at containerNsPath. The attributes Name, MTU and HardwareAddr
+// of newAttr are used for the new interface.
+func NsAddVlan(parentName string, containerNsPath string, newAttr netlink.LinkAttrs, config VlanConfig, addresses []*net.IPNet) (*resourceapi.NetworkDeviceData, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	parentLink, err := netlink.LinkByName(parentName)
+	if err != nil {
+		return nil, fmt.Errorf("could not find parent interface %s : %w", parentName, err)
+	}
+
+	if config.OuterID != 0 {
+		serviceVlanMu.Lock()
+		defer serviceVlanMu.Unlock()
+		parentLink, err = ensureServiceVlan(parentLink, config.OuterID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	protocol := config.Protocol
+	if protocol == netlink.VLAN_PROTOCOL_UNKNOWN {
+		protocol = netlink.VLAN_PROTOCOL_8021Q
+	}
+
+	vlan := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:         newAttr.Name,
+			MTU:          newAttr.MTU,
+			HardwareAddr: newAttr.HardwareAddr,
+			ParentIndex:  parentLink.Attrs().Index,
+		},
+		VlanId:       config.ID,
+		VlanProtocol: protocol,
+	}
+	return nsAddLink(vlan, containerNsPath, addresses)
+}
+
+// serviceVlanName returns the name of the 802.1ad interface with the given
+// identifier on the parent.
+func serviceVlanName(parentName string, id int) string {
+	suffix := "." + strconv.Itoa(id)
+	if len(parentName)+len(suffix) > unix.IFNAMSIZ-1 {
+		parentName = parentName[:unix.IFNAMSIZ-1-len(suffix)]
+	}
+	return parentName + suffix
+}
+
+// ensureServiceVlan returns the 802.1ad interface with the given identifier on the
+// parent, creating it if it does not exist. The interface is shared by all the QinQ
+// interfaces using the same service VLAN, it is deleted by DelServiceVlan once none
+// of them uses it.
+func ensureServiceVlan(parent netlink.Link, id int) (netlink.Link, error) {
+	name := serviceVlanName(parent.Attrs().Name, id)
+
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		vlan, ok := link.(*netlink.Vlan)
+		if !ok || vlan.VlanId != id || vlan.ParentIndex != parent.Attrs().Index || vlan.VlanProtocol != netlink.VLAN_PROTOCOL_8021AD {
+			return nil, fmt.Errorf("interface %s already exists and is not the 802.1ad VLAN %d of %s", name, id, parent.Attrs().Name)
+		}
+		return link, nil
+	}
+	var notFound netlink.LinkNotFoundError
+	if !errors.As(err, &notFound) {
+		return nil, err
+	}
+
+	vlan := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:        name,
+			ParentIndex: parent.Attrs().Index,
+		},
+		VlanId:       id,
+		VlanProtocol: netlink.VLAN_PROTOCOL_8021AD,
+	}
+	if err := netlink.LinkAdd(vlan); err != nil && !errors.Is(err, unix.EEXIST) {
+		return nil, fmt.Errorf("failed to create the 802.1ad interface %s: %w", name, err)
+	}
+	link, err = netlink.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to set up interface %s: %w", name, err)
+	}
+	return link, nil
+}
+
+// DelServiceVlan deletes the 802.1ad interface with the given identifier on the
+// parent created by NsAddVlan for the QinQ interfaces, if no VLAN interface of the
+// host namespace or of the pod namespaces uses it anymore. It does not fail if the
+// interface does not exist.
+func DelServiceVlan(parentName string, id int) error {
+	serviceVlanMu.Lock()
+	defer serviceVlanMu.Unlock()
+
+	name := serviceVlanName(parentName, id)
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return err
+	}
+	inUse, err := serviceVlanInUse(link)
+	if err != nil {
+		return fmt.Errorf("failed to check the users of interface %s: %w", name, err)
+	}
+	if inUse {
+		return nil
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete interface %s: %w", name, err)
+	}
+	return nil
+}
+
+// serviceVlanInUse returns true if a VLAN interface of the host namespace, or of
+// the namespaces pinned in netnsDir, is on top of the 802.1ad interface. Deleting
+// the interface would delete them too.
+func serviceVlanInUse(serviceVlan netlink.Link) (bool, error) {
+	isChild := func(link netlink.Link, netnsID int) bool {
+		vlan, ok := link.(*netlink.Vlan)
+		return ok && vlan.ParentIndex == serviceVlan.Attrs().Index && vlan.NetNsID == netnsID
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return false, err
+	}
+	for _, link := range links {
+		if isChild(link, -1) {
+			return true, nil
+		}
+	}
+
+	hostNs, err := netns.Get()
+	if err != nil {
+		return false, err
+	}
+	defer hostNs.Close()
+	entries, err := os.ReadDir(netnsDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, entry := range entries {
+		inUse, err := nsServiceVlanInUse(filepath.Join(netnsDir, entry.Name()), hostNs, isChild)
+		if err != nil {
+			klog.V(2).Infof("failed to list the interfaces of namespace %s: %v", entry.Name(), err)
+			continue
+		}
+		if inUse {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// nsServiceVlanInUse returns true if an interface of the namespace at nsPath is a
+// child of the 802.1ad interface of the host namespace.
+func nsServiceVlanInUse(nsPath string, hostNs netns.NsHandle, isChild func(netlink.Link, int) bool) (bool, error) {
+	ns, err := netns.GetFromPath(nsPath)
+	if err != nil {
+		return false, err
+	}
+	defer ns.Close()
+	nhNs, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return false, err
+	}
+	defer nhNs.Close()
+
+	links, err := nhNs.LinkList()
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return false, err
+	}
+	// the parents of the interfaces are identified by the id of their namespace,
+	// it is assigned when the interfaces are listed
+	hostNsID, err := nhNs.GetNetNsIdByFd(int(hostNs))
+	if err != nil || hostNsID < 0 {
+		return false, err
+	}
+	for _, link := range links {
+		if isChild(link, hostNsID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// VlanRange is an inclusive range of VLAN identifiers.
+type VlanRange struct {
+	Min int
+	Max int
+}
+
+// Contains returns true if id is in the range.
+func (r VlanRange) Contains(id int) bool {
+	return id >= r.Min && id <= r.Max
+}
+
+func (r VlanRange) String() string {
+	if r.Min == r.Max {
+		return strconv.Itoa(r.Min)
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// ParseVlanRanges parses a comma separated list of VLAN identifiers and ranges,
+// for example "100,200-299".
+func ParseVlanRanges(s string) ([]VlanRange, error) {
+	var ranges []VlanRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		low, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VLAN range %q: %w", part, err)
+		}
+		high := low
+		if isRange {
+			high, err = strconv.Atoi(last)
+			if err != nil {
+				return nil, fmt.Errorf("invalid VLAN range %q: %w", part, err)
+			}
+		}
+		if low < MinVlanID || high > MaxVlanID || low > high {
+			return nil, fmt.Errorf("invalid VLAN range %q, VLAN IDs must be between %d and %d", part, MinVlanID, MaxVlanID)
+		}
+		ranges = append(ranges, VlanRange{Min: low, Max: high})
+	}
+	return ranges, nil
+}
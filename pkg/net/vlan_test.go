@@ -0,0 +1,183 @@
+package net
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseVlanRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []VlanRange
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "single and ranges",
+			input: "100, 200-299,4094",
+			want:  []VlanRange{{Min: 100, Max: 100}, {Min: 200, Max: 299}, {Min: 4094, Max: 4094}},
+		},
+		{
+			name:    "reserved VLAN",
+			input:   "0-10",
+			wantErr: true,
+		},
+		{
+			name:    "inverted range",
+			input:   "20-10",
+			wantErr: true,
+		},
+		{
+			name:    "not a number",
+			input:   "a-b",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVlanRanges(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVlanRanges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseVlanRanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNsAddVlan(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Test requires root privileges.")
+	}
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("unexpected error trying to get namespace: %v", err)
+	}
+	defer origns.Close()
+
+	rndString := make([]byte, 4)
+	_, err = rand.Read(rndString)
+	if err != nil {
+		t.Errorf("fail to generate random name: %v", err)
+	}
+	var nsPaths []string
+	var nsHandles []netns.NsHandle
+	for i := 0; i < 2; i++ {
+		nsName := fmt.Sprintf("ns%x-%d", rndString, i)
+		testNS, err := netns.NewNamed(nsName)
+		if err != nil {
+			t.Fatalf("Failed to create network namespace: %v", err)
+		}
+		defer netns.DeleteNamed(nsName)
+		defer testNS.Close()
+		nsPaths = append(nsPaths, path.Join("/run/netns", nsName))
+		nsHandles = append(nsHandles, testNS)
+	}
+
+	// Switch back to the original namespace
+	netns.Set(origns)
+
+	parentName := fmt.Sprintf("veth%x", rndString)
+	parent := netlink.NewVeth(netlink.LinkAttrs{Name: parentName})
+	parent.PeerName = fmt.Sprintf("peer%x", rndString)
+	if err := netlink.LinkAdd(parent); err != nil {
+		t.Fatalf("fail to create parent interface: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = DelHostLink(parentName)
+	})
+
+	nsVlan := func(ns netns.NsHandle, name string) *netlink.Vlan {
+		t.Helper()
+		nhNs, err := netlink.NewHandleAt(ns)
+		if err != nil {
+			t.Fatalf("fail to get namespace handle: %v", err)
+		}
+		defer nhNs.Close()
+		link, err := nhNs.LinkByName(name)
+		if err != nil {
+			t.Fatalf("fail to get interface %s: %v", name, err)
+		}
+		vlan, ok := link.(*netlink.Vlan)
+		if !ok {
+			t.Fatalf("interface %s is a %s, not a VLAN", name, link.Type())
+		}
+		return vlan
+	}
+
+	address := &net.IPNet{IP: net.ParseIP("192.168.99.2"), Mask: net.CIDRMask(24, 32)}
+	networkData, err := NsAddVlan(parentName, nsPaths[0], netlink.LinkAttrs{Name: "net1"}, VlanConfig{ID: 100}, []*net.IPNet{address})
+	if errors.Is(err, unix.EOPNOTSUPP) {
+		t.Skip("Test requires VLAN support in the kernel.")
+	}
+	if err != nil {
+		t.Fatalf("fail to create VLAN interface: %v", err)
+	}
+	if networkData.InterfaceName != "net1" || len(networkData.IPs) != 1 || networkData.IPs[0] != address.String() {
+		t.Errorf("unexpected network data %+v", networkData)
+	}
+	if vlan := nsVlan(nsHandles[0], "net1"); vlan.VlanId != 100 || vlan.VlanProtocol != netlink.VLAN_PROTOCOL_8021Q {
+		t.Errorf("unexpected VLAN %d protocol %s", vlan.VlanId, vlan.VlanProtocol)
+	}
+
+	// the QinQ interfaces of both namespaces share the 802.1ad interface
+	for i, nsPath := range nsPaths {
+		if _, err := NsAddVlan(parentName, nsPath, netlink.LinkAttrs{Name: "net2"}, VlanConfig{ID: 200 + i, OuterID: 300}, nil); err != nil {
+			t.Fatalf("fail to create QinQ interface: %v", err)
+		}
+	}
+	svlanName := serviceVlanName(parentName, 300)
+	link, err := netlink.LinkByName(svlanName)
+	if err != nil {
+		t.Fatalf("802.1ad interface not created: %v", err)
+	}
+	serviceVlan, ok := link.(*netlink.Vlan)
+	if !ok || serviceVlan.VlanId != 300 || serviceVlan.VlanProtocol != netlink.VLAN_PROTOCOL_8021AD || serviceVlan.ParentIndex != parent.Attrs().Index {
+		t.Errorf("unexpected 802.1ad interface %+v", link)
+	}
+	for i, ns := range nsHandles {
+		if vlan := nsVlan(ns, "net2"); vlan.VlanId != 200+i || vlan.ParentIndex != serviceVlan.Attrs().Index {
+			t.Errorf("unexpected QinQ interface VLAN %d parent %d", vlan.VlanId, vlan.ParentIndex)
+		}
+	}
+
+	// the 802.1ad interface is kept while an interface uses it
+	if err := NsDelLink(nsPaths[0], "net2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DelServiceVlan(parentName, 300); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := netlink.LinkByName(svlanName); err != nil {
+		t.Errorf("802.1ad interface in use deleted: %v", err)
+	}
+	if err := NsDelLink(nsPaths[1], "net2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DelServiceVlan(parentName, 300); err != nil {
+		t.Fatal(err)
+	}
+	var notFound netlink.LinkNotFoundError
+	if _, err := netlink.LinkByName(svlanName); !errors.As(err, &notFound) {
+		t.Errorf("expected 802.1ad interface deleted, got %v", err)
+	}
+	if err := DelServiceVlan(parentName, 300); err != nil {
+		t.Errorf("unexpected error deleting a missing 802.1ad interface: %v", err)
+	}
+}
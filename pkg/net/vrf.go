@@ -0,0 +1,203 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// vrfUnreachableMetric is the metric of the unreachable default routes of the VRF
+// tables, the highest one so any other default route is preferred.
+const vrfUnreachableMetric = 4278198272
+
+// VRF is a virtual routing and forwarding domain inside the pod namespace, the
+// interfaces enslaved to it use its own routing table.
+type VRF struct {
+	// Name of the VRF device.
+	Name string `json:"name"`
+	// Table is the routing table of the VRF.
+	Table uint32 `json:"table"`
+}
+
+// Validate checks the VRF.
+func (v *VRF) Validate() error {
+	if v.Name == "" {
+		return fmt.Errorf("VRF name is required")
+	}
+	if len(v.Name) > unix.IFNAMSIZ-1 {
+		return fmt.Errorf("VRF name %s is longer than %d characters", v.Name, unix.IFNAMSIZ-1)
+	}
+	// the local, main and default tables are used by the namespace itself
+	switch v.Table {
+	case 0, unix.RT_TABLE_LOCAL, unix.RT_TABLE_MAIN, unix.RT_TABLE_DEFAULT:
+		return fmt.Errorf("VRF %s can not use table %d", v.Name, v.Table)
+	}
+	return nil
+}
+
+// NsSetVRF enslaves the interface ifName to the VRF inside the namespace at
+// containerNsPath, the VRF device is created if it does not exist. The connected
+// routes of the interface are moved to the table of the VRF, and the routes added
+// afterwards with NsAddRoutes are installed there too.
+func NsSetVRF(containerNsPath string, ifName string, vrf VRF) error {
+	if err := vrf.Validate(); err != nil {
+		return err
+	}
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPath, ifName, err)
+	}
+	defer containerNs.Close()
+	// to avoid golang problem with goroutines we create the socket in the
+	// namespace and use it directly
+	nhNs, err := netlink.NewHandleAt(containerNs)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace handle: %w", err)
+	}
+	defer nhNs.Close()
+
+	nsLink, err := nhNs.LinkByName(ifName)
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return fmt.Errorf("link not found for interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+
+	vrfLink, err := ensureVRF(nhNs, vrf)
+	if err != nil {
+		return fmt.Errorf("failed to create VRF %s on namespace %s: %w", vrf.Name, containerNsPath, err)
+	}
+	if nsLink.Attrs().MasterIndex == vrfLink.Attrs().Index {
+		return nil
+	}
+
+	// the interface is cycled when it is enslaved and the IPv6 addresses are flushed,
+	// unless keep_addr_on_down is set, they are restored afterwards
+	addrs, err := nhNs.AddrList(nsLink, netlink.FAMILY_ALL)
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return fmt.Errorf("failed to list addresses of interface %s: %w", ifName, err)
+	}
+	if err := nhNs.LinkSetMasterByIndex(nsLink, vrfLink.Attrs().Index); err != nil {
+		return fmt.Errorf("failed to enslave interface %s to VRF %s: %w", ifName, vrf.Name, err)
+	}
+	for _, addr := range addrs {
+		if addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		restored := &netlink.Addr{IPNet: addr.IPNet, Flags: addr.Flags & unix.IFA_F_NODAD}
+		if err := nhNs.AddrReplace(nsLink, restored); err != nil {
+			return fmt.Errorf("failed to restore address %s of interface %s: %w", addr.IPNet, ifName, err)
+		}
+	}
+	return nil
+}
+
+// ensureVRF returns the VRF device, it is created with the unreachable default
+// routes of its table if it does not exist, so the lookups never fall through to
+// the main table.
+func ensureVRF(nhNs *netlink.Handle, vrf VRF) (netlink.Link, error) {
+	link, err := nhNs.LinkByName(vrf.Name)
+	if err == nil {
+		existing, ok := link.(*netlink.Vrf)
+		if !ok {
+			return nil, fmt.Errorf("interface %s is not a VRF", vrf.Name)
+		}
+		if existing.Table != vrf.Table {
+			return nil, fmt.Errorf("VRF %s uses table %d, not %d", vrf.Name, existing.Table, vrf.Table)
+		}
+		return link, nil
+	}
+
+	err = nhNs.LinkAdd(&netlink.Vrf{LinkAttrs: netlink.LinkAttrs{Name: vrf.Name}, Table: vrf.Table})
+	if err != nil {
+		return nil, err
+	}
+	link, err = nhNs.LinkByName(vrf.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := nhNs.LinkSetUp(link); err != nil {
+		return nil, err
+	}
+	for _, dst := range []*net.IPNet{
+		{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+		{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+	} {
+		route := &netlink.Route{
+			Dst:      dst,
+			Table:    int(vrf.Table),
+			Type:     unix.RTN_UNREACHABLE,
+			Priority: vrfUnreachableMetric,
+		}
+		if err := nhNs.RouteReplace(route); err != nil {
+			return nil, fmt.Errorf("failed to add unreachable default route to table %d: %w", vrf.Table, err)
+		}
+	}
+	return link, nil
+}
+
+// NsRemoveVRF releases the interface ifName from its VRF inside the namespace at
+// containerNsPath, the VRF device is deleted when it has no other interface. It does
+// not fail if the namespace or the interface are already gone.
+func NsRemoveVRF(containerNsPath string, ifName string) error {
+	containerNs, err := netns.GetFromPath(containerNsPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("could not get network namespace from path %s for network device %s : %w", containerNsPath, ifName, err)
+	}
+	defer containerNs.Close()
+
+	nhNs, err := netlink.NewHandleAt(containerNs)
+	if err != nil {
+		return fmt.Errorf("could not get network namespace handle: %w", err)
+	}
+	defer nhNs.Close()
+
+	nsLink, err := nhNs.LinkByName(ifName)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return fmt.Errorf("link not found for interface %s on namespace %s: %w", ifName, containerNsPath, err)
+	}
+	vrfLink, ok := vrfOf(nhNs, nsLink)
+	if !ok {
+		return nil
+	}
+	if err := nhNs.LinkSetNoMaster(nsLink); err != nil {
+		return fmt.Errorf("failed to release interface %s from VRF %s: %w", ifName, vrfLink.Name, err)
+	}
+
+	links, err := nhNs.LinkList()
+	if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+		return fmt.Errorf("failed to list interfaces on namespace %s: %w", containerNsPath, err)
+	}
+	for _, link := range links {
+		if link.Attrs().MasterIndex == vrfLink.Index {
+			return nil
+		}
+	}
+	if err := nhNs.LinkDel(vrfLink); err != nil {
+		return fmt.Errorf("failed to delete VRF %s: %w", vrfLink.Name, err)
+	}
+	return nil
+}
+
+// vrfOf returns the VRF the link is enslaved to, if any.
+func vrfOf(nhNs *netlink.Handle, link netlink.Link) (*netlink.Vrf, bool) {
+	if link.Attrs().MasterIndex == 0 {
+		return nil, false
+	}
+	master, err := nhNs.LinkByIndex(link.Attrs().MasterIndex)
+	if err != nil {
+		return nil, false
+	}
+	vrf, ok := master.(*netlink.Vrf)
+	return vrf, ok
+}
@@ -0,0 +1,135 @@
+package net
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/ipam"
+)
+
+func TestNsSetVRF(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Test requires root privileges.")
+	}
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("unexpected error trying to get namespace: %v", err)
+	}
+	defer origns.Close()
+
+	rndString := make([]byte, 4)
+	_, err = rand.Read(rndString)
+	if err != nil {
+		t.Errorf("fail to generate random name: %v", err)
+	}
+	nsName := fmt.Sprintf("ns%x", rndString)
+	testNS, err := netns.NewNamed(nsName)
+	if err != nil {
+		t.Fatalf("Failed to create network namespace: %v", err)
+	}
+	defer netns.DeleteNamed(nsName)
+	defer testNS.Close()
+
+	// Switch back to the original namespace
+	netns.Set(origns)
+
+	nhNs, err := netlink.NewHandleAt(testNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nhNs.Close()
+	if err := nhNs.LinkAdd(&netlink.Vrf{LinkAttrs: netlink.LinkAttrs{Name: "probe"}, Table: 1000}); err != nil {
+		if errors.Is(err, unix.EOPNOTSUPP) {
+			t.Skip("Test requires VRF support in the kernel.")
+		}
+		t.Fatalf("fail to create VRF: %v", err)
+	}
+	if link, err := nhNs.LinkByName("probe"); err == nil {
+		_ = nhNs.LinkDel(link)
+	}
+
+	nsPath := path.Join("/run/netns", nsName)
+	hostIfName := fmt.Sprintf("veth%x", rndString)
+	_, _, err = NsAddVeth(hostIfName, nsPath, netlink.LinkAttrs{Name: "net1"},
+		[]*net.IPNet{{IP: net.ParseIP("192.168.88.2"), Mask: net.CIDRMask(24, 32)}})
+	if err != nil {
+		t.Fatalf("fail to create veth pair: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = DelHostLink(hostIfName)
+	})
+
+	if err := NsSetVRF(nsPath, "net1", VRF{Name: "red", Table: unix.RT_TABLE_MAIN}); err == nil {
+		t.Errorf("expected error using the main table")
+	}
+	vrf := VRF{Name: "red", Table: 100}
+	if err := NsSetVRF(nsPath, "net1", vrf); err != nil {
+		t.Fatalf("fail to set VRF: %v", err)
+	}
+	// it is idempotent
+	if err := NsSetVRF(nsPath, "net1", vrf); err != nil {
+		t.Fatalf("fail to set VRF again: %v", err)
+	}
+	if err := NsSetVRF(nsPath, "net1", VRF{Name: "red", Table: 200}); err == nil {
+		t.Errorf("expected error changing the table of the VRF")
+	}
+
+	err = NsAddRoutes(nsPath, "net1", []ipam.ResolvedRoute{{
+		Destination: netip.MustParsePrefix("10.20.0.0/16"),
+		Gateway:     netip.MustParseAddr("192.168.88.1"),
+	}})
+	if err != nil {
+		t.Fatalf("fail to add routes: %v", err)
+	}
+
+	nsLink, err := nhNs.LinkByName("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vrfLink, ok := vrfOf(nhNs, nsLink)
+	if !ok || vrfLink.Name != "red" {
+		t.Fatalf("interface is not enslaved to the VRF")
+	}
+	addrs, err := nhNs.AddrList(nsLink, netlink.FAMILY_V4)
+	if err != nil || len(addrs) != 1 {
+		t.Errorf("expected the address to be kept, got %v: %v", addrs, err)
+	}
+	routes, err := nhNs.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: 100}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, route := range routes {
+		if route.Dst != nil && route.Dst.String() == "10.20.0.0/16" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("route not found in the VRF table: %v", routes)
+	}
+
+	if err := NsRemoveVRF(nsPath, "net1"); err != nil {
+		t.Fatalf("fail to remove VRF: %v", err)
+	}
+	if _, err := nhNs.LinkByName("red"); err == nil {
+		t.Errorf("expected the VRF to be deleted")
+	}
+	nsLink, err = nhNs.LinkByName("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nsLink.Attrs().MasterIndex != 0 {
+		t.Errorf("expected the interface to be released")
+	}
+}
@@ -0,0 +1,107 @@
+package vlanid
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	resourceapi "k8s.io/api/resource/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	kndnet "github.com/aojea/kubernetes-network-drivers/pkg/net"
+)
+
+const (
+	// poolPrefix is the prefix of the pools of the VLAN IDs of the fabrics, the node
+	// names of the pools of the node drivers can not contain a slash.
+	poolPrefix = "fabric/"
+	// devicePrefix is the prefix of the devices of the VLAN IDs.
+	devicePrefix = "vlan-"
+
+	// FabricAttribute and VlanIDAttribute are the attributes of the VLAN ID devices.
+	FabricAttribute = "fabric"
+	VlanIDAttribute = "vlanId"
+)
+
+// Fabric is a network reachable from a set of nodes, with the range of VLAN IDs
+// that can be allocated to the claims.
+type Fabric struct {
+	Name   string
+	Ranges []kndnet.VlanRange
+	// NodeLabels are the labels of the nodes attached to the fabric, all the nodes
+	// if empty.
+	NodeLabels map[string]string
+}
+
+// ParseFabrics parses the fabrics in the format
+// <name>:<vlan ranges>[:<node labels>][;<name>:<vlan ranges>[:<node labels>]...],
+// for example "storage:100-199:fabric=storage;backend:10,20".
+func ParseFabrics(s string) ([]Fabric, error) {
+	var fabrics []Fabric
+	names := map[string]bool{}
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid fabric %q, expected <name>:<vlan ranges>[:<node labels>]", entry)
+		}
+		fabric := Fabric{Name: parts[0]}
+		if errs := validation.IsDNS1123Label(fabric.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid fabric name %q: %s", fabric.Name, strings.Join(errs, ", "))
+		}
+		if names[fabric.Name] {
+			return nil, fmt.Errorf("duplicate fabric %q", fabric.Name)
+		}
+		names[fabric.Name] = true
+
+		var err error
+		fabric.Ranges, err = kndnet.ParseVlanRanges(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid fabric %q: %w", entry, err)
+		}
+		if len(fabric.Ranges) == 0 {
+			return nil, fmt.Errorf("invalid fabric %q: no VLANs allowed", entry)
+		}
+		if len(parts) == 3 {
+			fabric.NodeLabels, err = labels.ConvertSelectorToLabelsMap(parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid node labels of fabric %q: %w", entry, err)
+			}
+		}
+		fabrics = append(fabrics, fabric)
+	}
+	return fabrics, nil
+}
+
+// PoolName returns the pool of the VLAN IDs of the fabric.
+func PoolName(fabric string) string {
+	return poolPrefix + fabric
+}
+
+// DeviceName returns the device of the VLAN ID.
+func DeviceName(id int) string {
+	return devicePrefix + strconv.Itoa(id)
+}
+
+// IsFabricPool returns true if the pool contains the VLAN IDs of a fabric.
+func IsFabricPool(pool string) bool {
+	return strings.HasPrefix(pool, poolPrefix)
+}
+
+// AllocatedID returns the fabric and the VLAN ID of an allocated device, or false if
+// the device is not the VLAN ID of a fabric.
+func AllocatedID(result resourceapi.DeviceRequestAllocationResult) (string, int, bool) {
+	fabric, ok := strings.CutPrefix(result.Pool, poolPrefix)
+	if !ok {
+		return "", 0, false
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(result.Device, devicePrefix))
+	if err != nil || !strings.HasPrefix(result.Device, devicePrefix) {
+		return "", 0, false
+	}
+	return fabric, id, true
+}
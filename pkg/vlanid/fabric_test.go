@@ -0,0 +1,114 @@
+package vlanid
+
+import (
+	"reflect"
+	"testing"
+
+	resourceapi "k8s.io/api/resource/v1"
+
+	kndnet "github.com/aojea/kubernetes-network-drivers/pkg/net"
+)
+
+func TestParseFabrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []Fabric
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "fabrics",
+			input: "storage:100-199:fabric=storage,zone=a; backend:10,20",
+			want: []Fabric{
+				{
+					Name:       "storage",
+					Ranges:     []kndnet.VlanRange{{Min: 100, Max: 199}},
+					NodeLabels: map[string]string{"fabric": "storage", "zone": "a"},
+				},
+				{
+					Name:   "backend",
+					Ranges: []kndnet.VlanRange{{Min: 10, Max: 10}, {Min: 20, Max: 20}},
+				},
+			},
+		},
+		{
+			name:    "missing ranges",
+			input:   "storage",
+			wantErr: true,
+		},
+		{
+			name:    "no ranges",
+			input:   "storage:",
+			wantErr: true,
+		},
+		{
+			name:    "invalid name",
+			input:   "Storage:100",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate",
+			input:   "storage:100;storage:200",
+			wantErr: true,
+		},
+		{
+			name:    "invalid range",
+			input:   "storage:5000",
+			wantErr: true,
+		},
+		{
+			name:    "invalid labels",
+			input:   "storage:100:fabric",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFabrics(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFabrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFabrics() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocatedID(t *testing.T) {
+	tests := []struct {
+		name       string
+		result     resourceapi.DeviceRequestAllocationResult
+		wantFabric string
+		wantID     int
+		wantOK     bool
+	}{
+		{
+			name:       "fabric device",
+			result:     resourceapi.DeviceRequestAllocationResult{Pool: PoolName("storage"), Device: DeviceName(100)},
+			wantFabric: "storage",
+			wantID:     100,
+			wantOK:     true,
+		},
+		{
+			name:   "node device",
+			result: resourceapi.DeviceRequestAllocationResult{Pool: "node1", Device: "vlan-100"},
+		},
+		{
+			name:   "invalid device",
+			result: resourceapi.DeviceRequestAllocationResult{Pool: PoolName("storage"), Device: "eth0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fabric, id, ok := AllocatedID(tt.result)
+			if fabric != tt.wantFabric || id != tt.wantID || ok != tt.wantOK {
+				t.Errorf("AllocatedID() = %s, %d, %v, want %s, %d, %v", fabric, id, ok, tt.wantFabric, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
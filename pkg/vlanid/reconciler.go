@@ -0,0 +1,152 @@
+package vlanid
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	"github.com/aojea/kubernetes-network-drivers/pkg/controller"
+)
+
+// maxDevicesPerSlice is the maximum number of devices of a ResourceSlice.
+const maxDevicesPerSlice = resourceapi.ResourceSliceMaxDevices
+
+var (
+	_ controller.PoolReconciler            = &Reconciler{}
+	_ controller.NetworkAttachedReconciler = &Reconciler{}
+)
+
+// Reconciler implements the controller.PoolReconciler interface, it publishes the
+// VLAN IDs of the fabrics as devices of the VLAN driver, reachable from the nodes
+// of the fabric. The scheduler allocates every VLAN ID to a single claim, and the
+// node driver creates the VLAN interface with the ID allocated to the claim.
+type Reconciler struct {
+	driverName string
+	fabrics    []Fabric
+	// deviceClasses are the DeviceClasses of the VLAN IDs, whose claims are tracked.
+	deviceClasses sets.Set[string]
+
+	mu sync.Mutex
+	// allocated are the VLAN IDs allocated to the claims by fabric, indexed by claim
+	// UID. The IDs removed from the ranges of a fabric are published until they are
+	// released. It is rebuilt from the cached claims by the controller, that
+	// reconciles them before the first sync of the pools.
+	allocated map[types.UID]map[string]sets.Set[int]
+}
+
+// NewReconciler returns a Reconciler of the fabrics, that publishes their VLAN IDs
+// as devices of the driver and tracks the claims of the given DeviceClasses.
+func NewReconciler(driverName string, fabrics []Fabric, deviceClasses ...string) *Reconciler {
+	return &Reconciler{
+		driverName:    driverName,
+		fabrics:       fabrics,
+		deviceClasses: sets.New(deviceClasses...),
+		allocated:     map[types.UID]map[string]sets.Set[int]{},
+	}
+}
+
+// IsDeviceClassRelevant checks if the claims of the DeviceClass are tracked.
+func (r *Reconciler) IsDeviceClassRelevant(deviceClass *resourceapi.DeviceClass) bool {
+	return r.deviceClasses.Has(deviceClass.Name)
+}
+
+// Reconcile records the VLAN IDs allocated to the claim, it does not create any
+// ResourceSlice.
+func (r *Reconciler) Reconcile(ctx context.Context, claim *resourceapi.ResourceClaim) ([]*resourceapi.ResourceSlice, error) {
+	allocated := map[string]sets.Set[int]{}
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if result.Driver != r.driverName {
+			continue
+		}
+		fabric, id, ok := AllocatedID(result)
+		if !ok {
+			continue
+		}
+		if allocated[fabric] == nil {
+			allocated[fabric] = sets.New[int]()
+		}
+		allocated[fabric].Insert(id)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(allocated) == 0 {
+		delete(r.allocated, claim.UID)
+		return nil, nil
+	}
+	if _, ok := r.allocated[claim.UID]; !ok {
+		for fabric, ids := range allocated {
+			klog.Infof("VLAN IDs %v of fabric %s allocated to claim %s/%s", sets.List(ids), fabric, claim.Namespace, claim.Name)
+		}
+	}
+	r.allocated[claim.UID] = allocated
+	return nil, nil
+}
+
+// Delete forgets the VLAN IDs of the claim, the scheduler can allocate them again.
+func (r *Reconciler) Delete(ctx context.Context, claim *resourceapi.ResourceClaim) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.allocated, claim.UID)
+	return nil
+}
+
+// Pools returns the ResourceSlices with the VLAN IDs of the fabrics, in a pool per
+// fabric.
+func (r *Reconciler) Pools(ctx context.Context) ([]*resourceapi.ResourceSlice, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var resourceSlices []*resourceapi.ResourceSlice
+	for _, fabric := range r.fabrics {
+		ids := sets.New[int]()
+		for _, vlanRange := range fabric.Ranges {
+			for id := vlanRange.Min; id <= vlanRange.Max; id++ {
+				ids.Insert(id)
+			}
+		}
+		for _, allocated := range r.allocated {
+			ids.Insert(allocated[fabric.Name].UnsortedList()...)
+		}
+
+		var devices []resourceapi.Device
+		for _, id := range sets.List(ids) {
+			devices = append(devices, resourceapi.Device{
+				Name: DeviceName(id),
+				Attributes: map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
+					FabricAttribute: {StringValue: ptr.To(fabric.Name)},
+					VlanIDAttribute: {IntValue: ptr.To(int64(id))},
+				},
+			})
+		}
+		for i, chunk := range slices.Collect(slices.Chunk(devices, maxDevicesPerSlice)) {
+			resourceSlices = append(resourceSlices, &resourceapi.ResourceSlice{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("vlan-ids-%s-%d", fabric.Name, i)},
+				Spec: resourceapi.ResourceSliceSpec{
+					Driver:  r.driverName,
+					Pool:    resourceapi.ResourcePool{Name: PoolName(fabric.Name)},
+					Devices: chunk,
+				},
+			})
+		}
+	}
+	return resourceSlices, nil
+}
+
+// NodeLabels returns the labels of the nodes of the fabric of the slice.
+func (r *Reconciler) NodeLabels(slice *resourceapi.ResourceSlice) (map[string]string, bool) {
+	for _, fabric := range r.fabrics {
+		if slice.Spec.Pool.Name == PoolName(fabric.Name) {
+			return fabric.NodeLabels, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,111 @@
+package vlanid
+
+import (
+	"context"
+	"testing"
+
+	resourceapi "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	kndnet "github.com/aojea/kubernetes-network-drivers/pkg/net"
+)
+
+func allocatedClaim(name string, results ...resourceapi.DeviceRequestAllocationResult) *resourceapi.ResourceClaim {
+	return &resourceapi.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Status: resourceapi.ResourceClaimStatus{
+			Allocation: &resourceapi.AllocationResult{
+				Devices: resourceapi.DeviceAllocationResult{Results: results},
+			},
+		},
+	}
+}
+
+func poolDevices(t *testing.T, slices []*resourceapi.ResourceSlice, pool string) []string {
+	t.Helper()
+	var devices []string
+	for _, slice := range slices {
+		if slice.Spec.Pool.Name != pool {
+			continue
+		}
+		if slice.Spec.Driver != "vlan.k8s.io" {
+			t.Errorf("slice %s of driver %s", slice.Name, slice.Spec.Driver)
+		}
+		for _, device := range slice.Spec.Devices {
+			devices = append(devices, device.Name)
+		}
+	}
+	return devices
+}
+
+func TestReconcilerPools(t *testing.T) {
+	ctx := context.Background()
+	fabrics := []Fabric{
+		{Name: "storage", Ranges: []kndnet.VlanRange{{Min: 1, Max: 200}}, NodeLabels: map[string]string{"fabric": "storage"}},
+		{Name: "backend", Ranges: []kndnet.VlanRange{{Min: 10, Max: 11}}},
+	}
+	r := NewReconciler("vlan.k8s.io", fabrics, "vlan-id")
+
+	slices, err := r.Pools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the storage pool does not fit in a slice
+	if len(slices) != 3 {
+		t.Fatalf("expected 3 slices, got %d", len(slices))
+	}
+	if got := len(poolDevices(t, slices, PoolName("storage"))); got != 200 {
+		t.Errorf("expected 200 storage VLAN IDs, got %d", got)
+	}
+	got := poolDevices(t, slices, PoolName("backend"))
+	if len(got) != 2 || got[0] != "vlan-10" || got[1] != "vlan-11" {
+		t.Errorf("unexpected backend VLAN IDs %v", got)
+	}
+	device := slices[2].Spec.Devices[0]
+	if *device.Attributes[FabricAttribute].StringValue != "backend" || *device.Attributes[VlanIDAttribute].IntValue != 10 {
+		t.Errorf("unexpected attributes %+v", device.Attributes)
+	}
+
+	// the VLAN IDs removed from the range are published until they are released
+	r.fabrics[1].Ranges = []kndnet.VlanRange{{Min: 10, Max: 10}}
+	claim := allocatedClaim("claim1",
+		resourceapi.DeviceRequestAllocationResult{Driver: "vlan.k8s.io", Pool: PoolName("backend"), Device: "vlan-11"},
+		resourceapi.DeviceRequestAllocationResult{Driver: "vlan.k8s.io", Pool: "node1", Device: "eth0"},
+	)
+	if _, err := r.Reconcile(ctx, claim); err != nil {
+		t.Fatal(err)
+	}
+	slices, err = r.Pools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := poolDevices(t, slices, PoolName("backend")); len(got) != 2 {
+		t.Errorf("expected the allocated VLAN ID published, got %v", got)
+	}
+	if err := r.Delete(ctx, claim); err != nil {
+		t.Fatal(err)
+	}
+	slices, err = r.Pools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := poolDevices(t, slices, PoolName("backend")); len(got) != 1 || got[0] != "vlan-10" {
+		t.Errorf("expected the released VLAN ID removed, got %v", got)
+	}
+}
+
+func TestReconcilerNodeLabels(t *testing.T) {
+	fabrics := []Fabric{{Name: "storage", Ranges: []kndnet.VlanRange{{Min: 1, Max: 1}}, NodeLabels: map[string]string{"fabric": "storage"}}}
+	r := NewReconciler("vlan.k8s.io", fabrics)
+
+	slice := &resourceapi.ResourceSlice{Spec: resourceapi.ResourceSliceSpec{Pool: resourceapi.ResourcePool{Name: PoolName("storage")}}}
+	labels, ok := r.NodeLabels(slice)
+	if !ok || labels["fabric"] != "storage" {
+		t.Errorf("unexpected node labels %v, %v", labels, ok)
+	}
+	slice.Spec.Pool.Name = PoolName("backend")
+	if _, ok := r.NodeLabels(slice); ok {
+		t.Errorf("unexpected node labels for an unknown fabric")
+	}
+}